@@ -0,0 +1,165 @@
+package swagger
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/marvold/todo/model"
+)
+
+// A client may make several changes all or nothing by posting them to Batch
+// as an array of operations.  Each operation stands for a request to another
+// endpoint: it names the store method behind it, such as "addTask", and gives
+// the request's IDs and body.  The operations are made in order, each seeing
+// the ones before it; see the model's batch.go.
+//
+// The response has a result for each operation, with the status and body the
+// request would have had on its own.  If an operation fails, none of the
+// changes are made: the response takes the failed operation's status, its
+// result is the error, and the others' results are 424 Failed Dependency.
+
+// maxBatch limits how many operations a batch may have, and so how long it
+// holds up other changes.
+const maxBatch = 100
+
+// batchOperation is an operation in a batch request.
+type batchOperation struct {
+	Op      string          `json:"op"`
+	List    string          `json:"list,omitempty"`
+	Task    string          `json:"task,omitempty"`
+	Comment string          `json:"comment,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+}
+
+// batchResult is the result of an operation in a batch response.
+type batchResult struct {
+	Status int         `json:"status"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+func (api *TodoAPI) Batch(w http.ResponseWriter, r *http.Request) {
+	// The operations change different things, so there's no one version for
+	// If-Match to compare; see etag.go.
+	if len(r.Header.Values("If-Match")) > 0 {
+		writeError(w, badRequest("If-Match: not supported for batches"))
+		return
+	}
+
+	// Parse the JSON and the operations' bodies.
+	var body []batchOperation
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, badRequest("malformed batch: %v", err))
+		return
+	}
+	if len(body) > maxBatch {
+		writeError(w, badRequest("too many operations: at most %d are allowed", maxBatch))
+		return
+	}
+	ops := make([]model.Operation, len(body))
+	for i, op := range body {
+		var err error
+		ops[i], err = op.operation()
+		if err != nil {
+			writeBatchError(w, len(body), &model.BatchError{Index: i, Err: err})
+			return
+		}
+	}
+
+	// Make the changes.
+	results, err := api.store.Batch(ops)
+	var batchErr *model.BatchError
+	if errors.As(err, &batchErr) {
+		writeBatchError(w, len(body), batchErr)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Encode the results.
+	response := batchResponse{Results: make([]batchResult, len(results))}
+	for i, result := range results {
+		response.Results[i] = body[i].result(result)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// operation parses an operation's body into the model's form.  Unknown
+// operations are left to the store to reject.
+func (op batchOperation) operation() (model.Operation, error) {
+	result := model.Operation{Op: op.Op, ID: op.List, TaskID: op.Task, CommentID: op.Comment}
+	var err error
+	switch op.Op {
+	case "addList":
+		if err = json.Unmarshal(op.Body, &result.List); err != nil {
+			err = badRequest("malformed list: %v", err)
+		}
+	case "addTask":
+		if err = json.Unmarshal(op.Body, &result.Task); err != nil {
+			err = badRequest("malformed task: %v", err)
+		}
+	case "setCompleted":
+		if err = json.Unmarshal(op.Body, &result.Completed); err != nil {
+			err = badRequest("malformed completion state: %v", err)
+		}
+	case "updateList":
+		result.ListPatch, err = model.DecodeTodoListPatch(op.Body)
+	case "updateTask":
+		result.TaskPatch, err = model.DecodeTaskPatch(op.Body)
+	case "moveTask":
+		if err = json.Unmarshal(op.Body, &result.Move); err != nil {
+			err = badRequest("malformed move: %v", err)
+		}
+	case "addComment":
+		if err = json.Unmarshal(op.Body, &result.Comment); err != nil {
+			err = badRequest("malformed comment: %v", err)
+		}
+	case "updateComment":
+		result.CommentPatch, err = model.DecodeCommentPatch(op.Body)
+	}
+	return result, err
+}
+
+// result returns the result of an operation which succeeded, as the request
+// it stands for would have returned it.
+func (op batchOperation) result(result model.OperationResult) batchResult {
+	status := http.StatusOK
+	switch op.Op {
+	case "addList", "addTask", "setCompleted", "addComment":
+		status = http.StatusCreated
+	case "deleteList", "deleteTask", "deleteComment":
+		status = http.StatusNoContent
+	}
+	switch {
+	case result.List != nil:
+		return batchResult{status, result.List}
+	case result.Task != nil:
+		return batchResult{status, result.Task}
+	case result.Comment != nil:
+		return batchResult{status, result.Comment}
+	}
+	return batchResult{Status: status}
+}
+
+// writeBatchError sends the response to a batch of n operations which
+// failed.
+func writeBatchError(w http.ResponseWriter, n int, err *model.BatchError) {
+	response := batchResponse{Results: make([]batchResult, n)}
+	for i := range response.Results {
+		response.Results[i] = batchResult{Status: http.StatusFailedDependency}
+	}
+	status, body := errorResponse(err.Err)
+	response.Results[err.Index] = batchResult{status, body}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,177 @@
+package swagger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/marvold/todo/model"
+)
+
+// Cursors let clients page through SearchLists, and through the tasks of a
+// list with GetList, without the pages drifting as lists and tasks come and
+// go.  To the client a cursor is an opaque token; inside, it holds the sort
+// key of the last item on the page and a digest of the request it belongs
+// to, its path and parameters, including the sort order.  It's signed with
+// HMAC-SHA256 so that it can't be forged or carried over to another search or
+// list.
+
+type cursorToken struct {
+	Score     int        `json:"s,omitempty"`
+	Name      string     `json:"n"`
+	Created   *time.Time `json:"c,omitempty"`
+	Updated   *time.Time `json:"u,omitempty"`
+	Tasks     int        `json:"t,omitempty"`
+	Completed int        `json:"d,omitempty"`
+	ID        string     `json:"i"`
+	Search    string     `json:"q"`
+}
+
+type taskCursorToken struct {
+	Name      string     `json:"n"`
+	Position  string     `json:"p,omitempty"`
+	Priority  string     `json:"r,omitempty"`
+	Due       *time.Time `json:"e,omitempty"`
+	Created   *time.Time `json:"c,omitempty"`
+	Updated   *time.Time `json:"u,omitempty"`
+	Completed bool       `json:"d,omitempty"`
+	ID        string     `json:"i"`
+	Search    string     `json:"q"`
+}
+
+// optionalTime leaves a zero time out of a token.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeValue(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// searchDigest summarizes a request, other than the parameters choosing the
+// page and those only choosing what to include.
+func searchDigest(path string, query url.Values) string {
+	search := url.Values{}
+	for k, v := range query {
+		switch k {
+		case "cursor", "skip", "limit", "include", "summary":
+		default:
+			search[k] = v
+		}
+	}
+	sum := sha256.Sum256([]byte(path + "?" + search.Encode()))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// nextLink returns the Link header pointing to the next page of a request.
+func nextLink(path string, query url.Values) string {
+	return "<" + path + "?" + query.Encode() + ">; rel=\"next\""
+}
+
+func (api *TodoAPI) sign(payload string) string {
+	mac := hmac.New(sha256.New, api.cursorKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// seal returns the signed token for the contents of a cursor.
+func (api *TodoAPI) seal(token interface{}) string {
+	data, _ := json.Marshal(token)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + api.sign(payload)
+}
+
+// open checks a token's signature and decodes its contents.
+func (api *TodoAPI) open(tokenString string, token interface{}) error {
+	payload, signature, ok := strings.Cut(tokenString, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(api.sign(payload))) {
+		return badRequest("cursor: not a valid cursor")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || json.Unmarshal(data, token) != nil {
+		return badRequest("cursor: not a valid cursor")
+	}
+	return nil
+}
+
+// encodeCursor returns the token for a cursor into a search.
+func (api *TodoAPI) encodeCursor(cursor model.ListCursor, path string, query url.Values) string {
+	return api.seal(cursorToken{
+		Score:     cursor.Score,
+		Name:      cursor.Name,
+		Created:   optionalTime(cursor.Created),
+		Updated:   optionalTime(cursor.Updated),
+		Tasks:     cursor.Tasks,
+		Completed: cursor.Completed,
+		ID:        cursor.ID,
+		Search:    searchDigest(path, query),
+	})
+}
+
+// decodeCursor checks a token and returns the cursor it holds, which must be
+// for the same search.
+func (api *TodoAPI) decodeCursor(tokenString string, path string, query url.Values) (*model.ListCursor, error) {
+	token := cursorToken{}
+	if err := api.open(tokenString, &token); err != nil {
+		return nil, err
+	}
+	if token.Search != searchDigest(path, query) {
+		return nil, badRequest("cursor: belongs to a different search")
+	}
+	return &model.ListCursor{
+		Score:     token.Score,
+		Name:      token.Name,
+		Created:   timeValue(token.Created),
+		Updated:   timeValue(token.Updated),
+		Tasks:     token.Tasks,
+		Completed: token.Completed,
+		ID:        token.ID,
+	}, nil
+}
+
+// encodeTaskCursor returns the token for a cursor into a list's tasks.
+func (api *TodoAPI) encodeTaskCursor(cursor model.TaskCursor, path string, query url.Values) string {
+	return api.seal(taskCursorToken{
+		Name:      cursor.Name,
+		Position:  cursor.Position,
+		Priority:  cursor.Priority,
+		Due:       optionalTime(cursor.Due),
+		Created:   optionalTime(cursor.Created),
+		Updated:   optionalTime(cursor.Updated),
+		Completed: cursor.Completed,
+		ID:        cursor.ID,
+		Search:    searchDigest(path, query),
+	})
+}
+
+// decodeTaskCursor checks a token and returns the cursor it holds, which must
+// be for the same list, filter and order.
+func (api *TodoAPI) decodeTaskCursor(tokenString string, path string, query url.Values) (*model.TaskCursor, error) {
+	token := taskCursorToken{}
+	if err := api.open(tokenString, &token); err != nil {
+		return nil, err
+	}
+	if token.Search != searchDigest(path, query) {
+		return nil, badRequest("cursor: belongs to a different search")
+	}
+	return &model.TaskCursor{
+		Name:      token.Name,
+		Position:  token.Position,
+		Priority:  token.Priority,
+		Due:       timeValue(token.Due),
+		Created:   timeValue(token.Created),
+		Updated:   timeValue(token.Updated),
+		Completed: token.Completed,
+		ID:        token.ID,
+	}, nil
+}
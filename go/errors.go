@@ -0,0 +1,90 @@
+package swagger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/marvold/todo/model"
+)
+
+// ErrorResponse is the body returned with an error status.
+type ErrorResponse struct {
+	Message string             `json:"message"`
+	Fields  []model.FieldError `json:"fields,omitempty"`
+}
+
+// requestError is a problem with the request itself, caught before it ever
+// reaches the store: a malformed body, a bad query parameter, a misused
+// idempotency key and so on.
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &requestError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func unprocessable(format string, args ...interface{}) error {
+	return &requestError{http.StatusUnprocessableEntity, fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) error {
+	return &requestError{http.StatusConflict, fmt.Sprintf(format, args...)}
+}
+
+func tooLarge(format string, args ...interface{}) error {
+	return &requestError{http.StatusRequestEntityTooLarge, fmt.Sprintf(format, args...)}
+}
+
+// statusCode maps an error to an HTTP status code.  This is the one place
+// which knows how the model's errors look over HTTP.
+func statusCode(err error) int {
+	var reqErr *requestError
+	var validationErr *model.ValidationError
+	switch {
+	case errors.As(err, &reqErr):
+		return reqErr.status
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest
+	case errors.Is(err, model.ErrInvalidID):
+		return http.StatusBadRequest
+	case errors.Is(err, model.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, model.ErrConflict), errors.Is(err, model.ErrBlocked):
+		return http.StatusConflict
+	case errors.Is(err, model.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// errorResponse returns the status and body describing an error.  Internal
+// errors aren't described to the client.
+func errorResponse(err error) (int, ErrorResponse) {
+	status := statusCode(err)
+	response := ErrorResponse{Message: err.Error()}
+	if status == http.StatusInternalServerError {
+		response.Message = http.StatusText(status)
+	}
+	var validationErr *model.ValidationError
+	if errors.As(err, &validationErr) {
+		response.Fields = validationErr.Fields
+	}
+	return status, response
+}
+
+// writeError sends an error response.
+func writeError(w http.ResponseWriter, err error) {
+	status, response := errorResponse(err)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,91 @@
+package swagger
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marvold/todo/model"
+)
+
+// Lists and tasks carry versions, which the store increases with every change
+// to them; see the model's version.go.  Over HTTP, a task's version is a
+// strong entity tag, the version in decimal between quotes, such as "7".  A
+// list's tag, which GetList sends as its ETag, adds how many of its tasks are
+// overdue, such as "12.3", since that changes its output as time passes.
+//
+// A change may be made conditional with If-Match: AddTask, PatchList and
+// DeleteList on the list's tag, and the others changing a task or its comments
+// on the task's.  Only the versions are compared, so a list's tag still matches
+// after more of its tasks fall overdue.  A mismatch fails with 412
+// Precondition Failed.  A batch can't be made conditional as a whole, so Batch
+// refuses If-Match with 400 rather than ignore it.
+//
+// GetList and SearchLists also send Last-Modified, and answer If-None-Match
+// and If-Modified-Since with 304 Not Modified if nothing has changed.
+// SearchLists's tag is built from the store's version, covering all of the
+// lists, as in "s40.3".  The tags are worked out before the lists are read,
+// but only compared once the store has accepted the request, so that a bad
+// request fails with 400 whether or not the client has the lists.  If the
+// lists change in between, the tags are older than the output, which only
+// means the client gets them again next time.
+
+// listTag returns the entity tag for a list, or for all of them, from its
+// version info.
+func listTag(prefix string, info model.VersionInfo) string {
+	return `"` + prefix + strconv.FormatUint(info.Version, 10) + "." + strconv.Itoa(info.Overdue) + `"`
+}
+
+// conditional returns the store to make a change through: the store itself,
+// or a view of it which makes the change only if the tags in the request's
+// If-Match header match.  Weak tags never match, as If-Match compares
+// strongly, and neither do tags which aren't ours.
+func (api *TodoAPI) conditional(r *http.Request) model.Store {
+	header := r.Header.Values("If-Match")
+	if len(header) == 0 {
+		return api.store
+	}
+	versions := []uint64{}
+	for _, tag := range strings.Split(strings.Join(header, ","), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return api.store
+		}
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		version, _, _ := strings.Cut(tag[1:len(tag)-1], ".")
+		if v, err := strconv.ParseUint(version, 10, 64); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return api.store.IfVersion(versions...)
+}
+
+// notModified sets the validators of a response, and if the request's
+// If-None-Match or If-Modified-Since header shows that the client already
+// has it, sends 304 Not Modified and returns true.  As for any GET, the
+// entity tags are compared weakly, and If-Modified-Since is ignored if
+// If-None-Match is given.
+func notModified(w http.ResponseWriter, r *http.Request, tag string, modified time.Time) bool {
+	w.Header().Set("ETag", tag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	match := false
+	if header := r.Header.Values("If-None-Match"); len(header) > 0 {
+		for _, other := range strings.Split(strings.Join(header, ","), ",") {
+			other = strings.TrimPrefix(strings.TrimSpace(other), "W/")
+			match = match || other == "*" || other == tag
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+		// The header only has whole seconds.
+		match = !modified.Truncate(time.Second).After(since)
+	}
+	if match {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return match
+}
@@ -0,0 +1,236 @@
+package swagger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A client may send a POST with an Idempotency-Key header, so that it can
+// retry the request safely after losing the response.  The first request with
+// a key goes ahead and its response is kept for a while; a retry with the same
+// key gets the same response back, marked with an Idempotent-Replayed header,
+// without the request being made again.  Reusing a key for a different
+// request, with another method, path, query, If-Match or body, fails with
+// 422, and a retry while the first request is still being served fails with
+// 409.
+//
+// Server errors aren't kept, since retrying them is what the client should
+// do.  Keys are kept in memory only, so they don't survive a restart, and are
+// shared by all clients, which should make them unique, for example by using
+// random UUIDs.  The memory they take is limited: once the kept responses
+// outgrow a budget, the oldest are forgotten early, and bodies are limited in
+// size, since they must be read in full to compare them.
+
+// DefaultIdempotencyTTL is how long responses are kept for replay if no other
+// time is given.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// DefaultIdempotencyBudget is roughly how many bytes the kept responses may
+// take if no other budget is given.
+const DefaultIdempotencyBudget = 64 << 20
+
+// maxKeyLength limits the keys clients may use, and so the memory they take.
+const maxKeyLength = 255
+
+// maxBodySize limits the bodies of requests with keys.
+const maxBodySize = 1 << 20
+
+// entryOverhead is roughly what an entry takes besides its key and response.
+const entryOverhead = 256
+
+// idempotencyCache keeps the responses to requests made with keys.
+type idempotencyCache struct {
+	ttl     time.Duration
+	budget  int
+	now     func() time.Time
+	lock    sync.Mutex
+	entries map[string]*idempotencyEntry
+	queue   []*idempotencyEntry // In the order they expire, which is the order added
+	size    int                 // Of the entries, roughly
+}
+
+// idempotencyEntry is a request made with a key, and its response once done.
+type idempotencyEntry struct {
+	key         string
+	fingerprint [sha256.Size]byte // Of the request
+	expires     time.Time
+	size        int
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+func newIdempotencyCache(ttl time.Duration, budget int, now func() time.Time) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, budget: budget, now: now, entries: make(map[string]*idempotencyEntry)}
+}
+
+// start looks up a request's key.  It returns the entry for the request if it
+// is new, with true, or an earlier one with the key, with false.  The caller
+// must finish a new entry.
+func (c *idempotencyCache) start(key string, fingerprint [sha256.Size]byte) (*idempotencyEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Forget the expired entries.
+	now := c.now()
+	for len(c.queue) > 0 && !c.queue[0].expires.After(now) {
+		c.pop()
+	}
+
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+	e := &idempotencyEntry{key: key, fingerprint: fingerprint, expires: now.Add(c.ttl), size: len(key) + entryOverhead}
+	c.entries[key] = e
+	c.queue = append(c.queue, e)
+	c.size += e.size
+	c.trim()
+	return e, true
+}
+
+// finish keeps the response to a new request, or forgets the request if it
+// failed on our side, so that it may be retried.
+func (c *idempotencyCache) finish(e *idempotencyEntry, rec *recorder) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+		c.forget(e)
+		return
+	}
+	e.done = true
+	e.status = rec.status
+	e.header = rec.header
+	e.body = rec.body.Bytes()
+
+	// Count the response, unless the entry has already been forgotten.
+	if c.entries[e.key] == e {
+		grown := len(e.body)
+		for name, values := range e.header {
+			for _, value := range values {
+				grown += len(name) + len(value)
+			}
+		}
+		e.size += grown
+		c.size += grown
+		c.trim()
+	}
+}
+
+// forget drops an entry, if it is still kept.  The caller must hold the lock.
+func (c *idempotencyCache) forget(e *idempotencyEntry) {
+	if c.entries[e.key] == e {
+		delete(c.entries, e.key)
+		c.size -= e.size
+	}
+}
+
+// pop forgets the oldest entry.  The caller must hold the lock.
+func (c *idempotencyCache) pop() {
+	c.forget(c.queue[0])
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+}
+
+// trim forgets the oldest entries until the rest fit the budget.  The caller
+// must hold the lock.
+func (c *idempotencyCache) trim() {
+	for c.size > c.budget && len(c.queue) > 0 {
+		c.pop()
+	}
+}
+
+// recorder passes a response through, keeping a copy.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// idempotent wraps a handler to honor idempotency keys.
+func (api *TodoAPI) idempotent(inner http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			inner.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			writeError(w, badRequest("Idempotency-Key: must be at most %d characters", maxKeyLength))
+			return
+		}
+
+		// Read the body, to tell if a retry is the same request, and leave it
+		// for the handler.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeError(w, tooLarge("body too large: at most %d bytes are allowed with an Idempotency-Key", maxBodySize))
+			return
+		}
+		if err != nil {
+			writeError(w, badRequest("unreadable body: %v", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+		io.WriteString(hash, strings.Join(r.Header.Values("If-Match"), ", ")+"\n")
+		hash.Write(body)
+		var fingerprint [sha256.Size]byte
+		hash.Sum(fingerprint[:0])
+
+		e, fresh := api.idempotency.start(key, fingerprint)
+		if fresh {
+			rec := &recorder{ResponseWriter: w}
+			defer func() {
+				api.idempotency.finish(e, rec)
+			}()
+			inner.ServeHTTP(rec, r)
+			return
+		}
+
+		// Replay the response to the first request with the key.  The entry
+		// is only changed under the lock.
+		api.idempotency.lock.Lock()
+		done, status, header, body := e.done, e.status, e.header, e.body
+		api.idempotency.lock.Unlock()
+		switch {
+		case e.fingerprint != fingerprint:
+			writeError(w, unprocessable("Idempotency-Key: already used for a different request"))
+		case !done:
+			writeError(w, conflict("Idempotency-Key: the first request with this key is still in progress"))
+		default:
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(status)
+			w.Write(body)
+		}
+	})
+}
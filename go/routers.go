@@ -11,11 +11,15 @@
 package swagger
 
 import (
+	"crypto/rand"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/marvold/todo/model"
 )
 
 type Route struct {
@@ -27,11 +31,57 @@ type Route struct {
 
 type Routes []Route
 
-func NewRouter() *mux.Router {
+// Option configures a router.
+type Option func(*TodoAPI)
+
+// WithCursorKey sets the key which signs the cursors for paging through
+// SearchLists.  Without one the router makes up a key, and cursors don't
+// survive a restart.
+func WithCursorKey(key []byte) Option {
+	return func(api *TodoAPI) {
+		api.cursorKey = key
+	}
+}
+
+// WithIdempotencyTTL sets how long the responses to requests with
+// idempotency keys are kept for replay; see idempotency.go.  The default is
+// DefaultIdempotencyTTL.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(api *TodoAPI) {
+		api.idempotencyTTL = ttl
+	}
+}
+
+// WithIdempotencyBudget sets roughly how many bytes the responses kept for
+// replay may take; past it, the oldest are forgotten before they expire.  The
+// default is DefaultIdempotencyBudget.
+func WithIdempotencyBudget(budget int) Option {
+	return func(api *TodoAPI) {
+		api.idempotencyBudget = budget
+	}
+}
+
+// NewRouter returns a router serving the API against the given store.
+func NewRouter(store model.Store, options ...Option) *mux.Router {
+	api := &TodoAPI{store: store, idempotencyTTL: DefaultIdempotencyTTL, idempotencyBudget: DefaultIdempotencyBudget, now: time.Now}
+	for _, option := range options {
+		option(api)
+	}
+	api.idempotency = newIdempotencyCache(api.idempotencyTTL, api.idempotencyBudget, api.now)
+	if api.cursorKey == nil {
+		api.cursorKey = make([]byte, 32)
+		if _, err := rand.Read(api.cursorKey); err != nil {
+			panic(err)
+		}
+	}
+
 	router := mux.NewRouter().StrictSlash(true)
-	for _, route := range routes {
+	for _, route := range api.routes() {
 		var handler http.Handler
 		handler = route.HandlerFunc
+		if route.Method == http.MethodPost {
+			handler = api.idempotent(handler)
+		}
 		handler = Logger(handler, route.Name)
 
 		router.
@@ -44,50 +94,155 @@ func NewRouter() *mux.Router {
 	return router
 }
 
+// Paths of created resources, for Location headers.  These must agree with
+// the routes below.
+
+func listPath(id string) string {
+	return "/aweiker/ToDo/1.0.0/list/" + url.PathEscape(id)
+}
+
+func searchPath() string {
+	return "/aweiker/ToDo/1.0.0/lists"
+}
+
+func taskPath(id string, taskID string) string {
+	return listPath(id) + "/task/" + url.PathEscape(taskID)
+}
+
+func commentPath(id string, taskID string, commentID string) string {
+	return taskPath(id, taskID) + "/comment/" + url.PathEscape(commentID)
+}
+
 func Index(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Hello World!")
 }
 
-var routes = Routes{
-	Route{
-		"Index",
-		"GET",
-		"/aweiker/ToDo/1.0.0/",
-		Index,
-	},
-
-	Route{
-		"AddList",
-		strings.ToUpper("Post"),
-		"/aweiker/ToDo/1.0.0/lists",
-		AddList,
-	},
-
-	Route{
-		"AddTask",
-		strings.ToUpper("Post"),
-		"/aweiker/ToDo/1.0.0/list/{id}/tasks",
-		AddTask,
-	},
-
-	Route{
-		"GetList",
-		strings.ToUpper("Get"),
-		"/aweiker/ToDo/1.0.0/list/{id}",
-		GetList,
-	},
-
-	Route{
-		"PutTask",
-		strings.ToUpper("Post"),
-		"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}/complete",
-		PutTask,
-	},
-
-	Route{
-		"SearchLists",
-		strings.ToUpper("Get"),
-		"/aweiker/ToDo/1.0.0/lists",
-		SearchLists,
-	},
+func (api *TodoAPI) routes() Routes {
+	return Routes{
+		Route{
+			"Index",
+			"GET",
+			"/aweiker/ToDo/1.0.0/",
+			Index,
+		},
+
+		Route{
+			"AddList",
+			strings.ToUpper("Post"),
+			"/aweiker/ToDo/1.0.0/lists",
+			api.AddList,
+		},
+
+		Route{
+			"AddTask",
+			strings.ToUpper("Post"),
+			"/aweiker/ToDo/1.0.0/list/{id}/tasks",
+			api.AddTask,
+		},
+
+		Route{
+			"PatchList",
+			strings.ToUpper("Patch"),
+			"/aweiker/ToDo/1.0.0/list/{id}",
+			api.PatchList,
+		},
+
+		Route{
+			"PatchTask",
+			strings.ToUpper("Patch"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}",
+			api.PatchTask,
+		},
+
+		Route{
+			"DeleteList",
+			strings.ToUpper("Delete"),
+			"/aweiker/ToDo/1.0.0/list/{id}",
+			api.DeleteList,
+		},
+
+		Route{
+			"DeleteTask",
+			strings.ToUpper("Delete"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}",
+			api.DeleteTask,
+		},
+
+		Route{
+			"GetList",
+			strings.ToUpper("Get"),
+			"/aweiker/ToDo/1.0.0/list/{id}",
+			api.GetList,
+		},
+
+		Route{
+			"PutTask",
+			strings.ToUpper("Post"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}/complete",
+			api.PutTask,
+		},
+
+		Route{
+			"MoveTask",
+			strings.ToUpper("Post"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}/move",
+			api.MoveTask,
+		},
+
+		Route{
+			"AddComment",
+			strings.ToUpper("Post"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}/comments",
+			api.AddComment,
+		},
+
+		Route{
+			"GetComments",
+			strings.ToUpper("Get"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}/comments",
+			api.GetComments,
+		},
+
+		Route{
+			"PatchComment",
+			strings.ToUpper("Patch"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}/comment/{commentId}",
+			api.PatchComment,
+		},
+
+		Route{
+			"DeleteComment",
+			strings.ToUpper("Delete"),
+			"/aweiker/ToDo/1.0.0/list/{id}/task/{taskId}/comment/{commentId}",
+			api.DeleteComment,
+		},
+
+		Route{
+			"SearchLists",
+			strings.ToUpper("Get"),
+			"/aweiker/ToDo/1.0.0/lists",
+			api.SearchLists,
+		},
+
+		Route{
+			"GetTasks",
+			strings.ToUpper("Get"),
+			"/aweiker/ToDo/1.0.0/tasks",
+			api.GetTasks,
+		},
+
+		Route{
+			"GetTags",
+			strings.ToUpper("Get"),
+			"/aweiker/ToDo/1.0.0/tags",
+			api.GetTags,
+		},
+
+		Route{
+			"Batch",
+			strings.ToUpper("Post"),
+			"/aweiker/ToDo/1.0.0/batch",
+			api.Batch,
+		},
+	}
 }
@@ -12,93 +12,386 @@ package swagger
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/marvold/todo/model"
 )
 
-func AddList(w http.ResponseWriter, r *http.Request) {
-	status := http.StatusBadRequest
+// TodoAPI implements the API operations on top of a store.
+type TodoAPI struct {
+	store             model.Store
+	cursorKey         []byte // Signs pagination cursors; see cursor.go
+	idempotencyTTL    time.Duration
+	idempotencyBudget int
+	idempotency       *idempotencyCache // See idempotency.go
+	now               func() time.Time  // Clock for expiring idempotency keys
+}
 
+func (api *TodoAPI) AddList(w http.ResponseWriter, r *http.Request) {
 	// Parse the JSON and add the list.
 	body := model.TodoList{}
-	if json.NewDecoder(r.Body).Decode(&body) == nil {
-		status = model.AddList(body)
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, badRequest("malformed list: %v", err))
+		return
+	}
+	response, err := api.store.AddList(body)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
+	// Encode the result, which tells the client any IDs we generated.
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(status)
+	w.Header().Set("Location", listPath(response.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
 }
 
-func AddTask(w http.ResponseWriter, r *http.Request) {
-	status := http.StatusBadRequest
-
+func (api *TodoAPI) AddTask(w http.ResponseWriter, r *http.Request) {
 	// Get the list ID.
-	id, ok := mux.Vars(r)["id"]
-	if ok {
-		// Parse the JSON and add the task.
-		body := model.Task{}
-		if json.NewDecoder(r.Body).Decode(&body) == nil {
-			status = model.AddTask(id, body)
-		}
+	id := mux.Vars(r)["id"]
+
+	// Parse the JSON and add the task.
+	body := model.Task{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, badRequest("malformed task: %v", err))
+		return
+	}
+	response, err := api.conditional(r).AddTask(id, body)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
+	// Encode the result, which tells the client any ID we generated.
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(status)
+	w.Header().Set("Location", taskPath(id, response.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
 }
 
-func GetList(w http.ResponseWriter, r *http.Request) {
+func (api *TodoAPI) PatchList(w http.ResponseWriter, r *http.Request) {
 	// Get the list ID.
-	id, ok := mux.Vars(r)["id"]
-	if !ok {
-		w.WriteHeader(http.StatusBadRequest)
+	id := mux.Vars(r)["id"]
+
+	// Parse the merge patch and apply it.
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, badRequest("unreadable patch: %v", err))
 		return
 	}
+	patch, err := model.DecodeTodoListPatch(data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response, err := api.conditional(r).UpdateList(id, patch)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Encode the result.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Get the list.
-	response, status := model.GetList(id)
+func (api *TodoAPI) PatchTask(w http.ResponseWriter, r *http.Request) {
+	// Get the list and task IDs.
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+
+	// Parse the merge patch and apply it.
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, badRequest("unreadable patch: %v", err))
+		return
+	}
+	patch, err := model.DecodeTaskPatch(data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response, err := api.conditional(r).UpdateTask(id, taskID, patch)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
+	// Encode the result.
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(status)
-	if status == http.StatusOK {
-		// Encode the result.
-		json.NewEncoder(w).Encode(response)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *TodoAPI) MoveTask(w http.ResponseWriter, r *http.Request) {
+	// Get the list and task IDs.
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+
+	// Parse the JSON and move the task.
+	body := model.TaskMove{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, badRequest("malformed move: %v", err))
+		return
+	}
+	response, err := api.conditional(r).MoveTask(id, taskID, body)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
+
+	// Encode the result.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
 }
 
-func PutTask(w http.ResponseWriter, r *http.Request) {
-	status := http.StatusBadRequest
+func (api *TodoAPI) DeleteList(w http.ResponseWriter, r *http.Request) {
+	// Get the list ID.
+	id := mux.Vars(r)["id"]
+
+	// Remove the list.
+	if err := api.conditional(r).DeleteList(id); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
 
+func (api *TodoAPI) DeleteTask(w http.ResponseWriter, r *http.Request) {
 	// Get the list and task IDs.
-	id, ok := mux.Vars(r)["id"]
-	if ok {
-		taskID, ok := mux.Vars(r)["taskId"]
-		if ok {
-			// Parse the JSON and set the completed flag.
-			body := model.CompletedTask{}
-			if json.NewDecoder(r.Body).Decode(&body) == nil {
-				status = model.SetCompleted(id, taskID, body)
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+
+	// Remove the task.
+	if err := api.conditional(r).DeleteTask(id, taskID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *TodoAPI) GetList(w http.ResponseWriter, r *http.Request) {
+	// Get the list ID.
+	id := mux.Vars(r)["id"]
+
+	// Default parameters if not passed.
+	options := model.ListOptions{Stats: true}
+	limit := 0
+	cursor := ""
+
+	var err error
+	for k, v := range r.URL.Query() {
+		if len(v) != 1 {
+			// We won't accept two copies of a parameter.
+			writeError(w, badRequest("%s: must be given once", k))
+			return
+		}
+
+		// Parse parameters.  We will check their values in the lower-level API.
+		switch k {
+		case "limit":
+			limit, err = strconv.Atoi(v[0])
+			if err != nil {
+				writeError(w, badRequest("%s: not an integer", k))
+				return
 			}
+		case "cursor":
+			cursor = v[0]
+		case "sort":
+			options.Sort = v[0]
+		case "view":
+			switch v[0] {
+			case "flat":
+				options.Tree = false
+			case "tree":
+				options.Tree = true
+			default:
+				writeError(w, badRequest("%s: must be \"flat\" or \"tree\"", k))
+				return
+			}
+		case "include":
+			for _, part := range strings.Split(v[0], ",") {
+				switch part {
+				case "comments":
+					options.Comments = true
+				default:
+					writeError(w, badRequest("%s: unknown value %q", k, part))
+					return
+				}
+			}
+		default:
+			if err := parseTaskFilter(&options.Filter, k, v[0]); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+	}
+
+	// Get the list's version, before the list; see etag.go.
+	info, err := api.store.GetListVersion(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Get the list, or a page of its tasks.
+	var after *model.TaskCursor
+	if cursor != "" {
+		if after, err = api.decodeTaskCursor(cursor, listPath(id), r.URL.Query()); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	response, next, err := api.store.GetListPage(id, after, limit, options)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Tell the client if it already has the list, or otherwise where the
+	// next page is.
+	if notModified(w, r, listTag("", info), info.Modified) {
+		return
+	}
+	if next != nil {
+		query := r.URL.Query()
+		query.Set("cursor", api.encodeTaskCursor(*next, listPath(id), query))
+		w.Header().Set("Link", nextLink(listPath(id), query))
+	}
+
+	// Encode the result.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *TodoAPI) GetTasks(w http.ResponseWriter, r *http.Request) {
+	// Default parameters if not passed.
+	filter := model.TaskFilter{}
+
+	for k, v := range r.URL.Query() {
+		if len(v) != 1 {
+			// We won't accept two copies of a parameter.
+			writeError(w, badRequest("%s: must be given once", k))
+			return
+		}
+		if err := parseTaskFilter(&filter, k, v[0]); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	// Get the tasks.
+	response, err := api.store.GetTasks(filter)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Encode the result.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *TodoAPI) GetTags(w http.ResponseWriter, r *http.Request) {
+	// There are no parameters.
+	for k := range r.URL.Query() {
+		writeError(w, badRequest("%s: unknown parameter", k))
+		return
+	}
+
+	// Get the tags.
+	response, err := api.store.GetTags()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Encode the result.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseTaskFilter parses a query parameter which filters tasks.  Anything
+// else is an unknown parameter.
+func parseTaskFilter(filter *model.TaskFilter, k string, v string) error {
+	var err error
+	switch k {
+	case "completed":
+		var completed bool
+		completed, err = strconv.ParseBool(v)
+		if err != nil {
+			return badRequest("%s: not a boolean", k)
+		}
+		filter.Completed = &completed
+	case "dueBefore":
+		filter.DueBefore, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return badRequest("%s: not an RFC 3339 time", k)
 		}
+	case "dueAfter":
+		filter.DueAfter, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return badRequest("%s: not an RFC 3339 time", k)
+		}
+	case "priority":
+		filter.Priorities = strings.Split(v, ",")
+	case "tag":
+		filter.Tags = strings.Split(v, ",")
+	case "name":
+		filter.Name = v
+	default:
+		return badRequest("%s: unknown parameter", k)
+	}
+	return nil
+}
+
+func (api *TodoAPI) PutTask(w http.ResponseWriter, r *http.Request) {
+	// Get the list and task IDs.
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+
+	// Parse the JSON and set the completed flag.
+	body := model.CompletedTask{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, badRequest("malformed completion state: %v", err))
+		return
+	}
+	if err := api.conditional(r).SetCompleted(id, taskID, body); err != nil {
+		writeError(w, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(status)
+	w.WriteHeader(http.StatusCreated)
 }
 
-func SearchLists(w http.ResponseWriter, r *http.Request) {
+func (api *TodoAPI) SearchLists(w http.ResponseWriter, r *http.Request) {
 	// Default parameters if not passed.
 	searchString := ""
 	skip := 0
+	offset := false // Page with cursors unless skip is given
 	limit := 0
+	cursor := ""
+	options := model.ListOptions{Stats: true}
 
 	var err error
 	for k, v := range r.URL.Query() {
 		if len(v) != 1 {
 			// We won't accept two copies of a parameter.
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, badRequest("%s: must be given once", k))
 			return
 		}
 
@@ -110,28 +403,184 @@ func SearchLists(w http.ResponseWriter, r *http.Request) {
 		case "skip":
 			skip, err = strconv.Atoi(v[0])
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, badRequest("%s: not an integer", k))
 				return
 			}
+			offset = true
+		case "cursor":
+			cursor = v[0]
 		case "limit":
 			limit, err = strconv.Atoi(v[0])
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				writeError(w, badRequest("%s: not an integer", k))
 				return
 			}
+		case "sort":
+			options.ListSort = v[0]
+		case "summary":
+			if v[0] != "only" {
+				writeError(w, badRequest("%s: must be \"only\"", k))
+				return
+			}
+			options.Summary = true
+		case "taskSort":
+			options.Sort = v[0]
+		case "include":
+			for _, part := range strings.Split(v[0], ",") {
+				switch part {
+				case "comments":
+					options.Comments = true
+				case "matches":
+					options.Matches = true
+				default:
+					writeError(w, badRequest("%s: unknown value %q", k, part))
+					return
+				}
+			}
 		default:
-			w.WriteHeader(http.StatusBadRequest)
+			if err := parseTaskFilter(&options.Filter, k, v[0]); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+	}
+
+	// Get the store's version, before the lists; see etag.go.
+	info, err := api.store.GetListsVersion()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Get the lists, by offset if asked, and otherwise by cursor.
+	var response []model.TodoList
+	var next *model.ListCursor
+	if offset {
+		if cursor != "" {
+			writeError(w, badRequest("cursor: can't be combined with skip"))
 			return
 		}
+		response, err = api.store.GetLists(searchString, skip, limit, options)
+	} else {
+		var after *model.ListCursor
+		if cursor != "" {
+			if after, err = api.decodeCursor(cursor, searchPath(), r.URL.Query()); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		response, next, err = api.store.GetListsAfter(searchString, after, limit, options)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
-	// Get the lists.
-	response, status := model.GetLists(searchString, skip, limit)
+	// Tell the client if it already has the lists, or otherwise where the
+	// next page is.
+	if notModified(w, r, listTag("s", info), info.Modified) {
+		return
+	}
+	if next != nil {
+		query := r.URL.Query()
+		query.Set("cursor", api.encodeCursor(*next, searchPath(), query))
+		w.Header().Set("Link", nextLink(searchPath(), query))
+	}
 
+	// Encode the result.
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(status)
-	if status == http.StatusOK {
-		// Encode the result.
-		json.NewEncoder(w).Encode(response)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *TodoAPI) AddComment(w http.ResponseWriter, r *http.Request) {
+	// Get the list and task IDs.
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+
+	// Parse the JSON and add the comment.
+	body := model.Comment{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, badRequest("malformed comment: %v", err))
+		return
+	}
+	response, err := api.conditional(r).AddComment(id, taskID, body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Encode the result, which tells the client the ID and time.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Location", commentPath(id, taskID, response.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *TodoAPI) GetComments(w http.ResponseWriter, r *http.Request) {
+	// Get the list and task IDs.
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+
+	// There are no parameters.
+	for k := range r.URL.Query() {
+		writeError(w, badRequest("%s: unknown parameter", k))
+		return
 	}
+
+	// Get the comments.
+	response, err := api.store.GetComments(id, taskID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Encode the result.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *TodoAPI) PatchComment(w http.ResponseWriter, r *http.Request) {
+	// Get the list, task and comment IDs.
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+	commentID := mux.Vars(r)["commentId"]
+
+	// Parse the merge patch and apply it.
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, badRequest("unreadable patch: %v", err))
+		return
+	}
+	patch, err := model.DecodeCommentPatch(data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	response, err := api.conditional(r).UpdateComment(id, taskID, commentID, patch)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Encode the result.
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (api *TodoAPI) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	// Get the list, task and comment IDs.
+	id := mux.Vars(r)["id"]
+	taskID := mux.Vars(r)["taskId"]
+	commentID := mux.Vars(r)["commentId"]
+
+	// Remove the comment.
+	if err := api.conditional(r).DeleteComment(id, taskID, commentID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -2,30 +2,81 @@ package swagger
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/marvold/todo/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// Drops the stats from lists, which change with the clock, for comparing
+// them with the lists sent.
+func dropStats(lists []model.TodoList) []model.TodoList {
+	for i := range lists {
+		lists[i].Stats = nil
+	}
+	return lists
+}
+
+// Clears the versions in a model, which count the changes made before it, so
+// that it compares equal to one written out by hand.  Slices are copied rather
+// than changed in place.
+func unversioned(model interface{}) interface{} {
+	v := reflect.New(reflect.TypeOf(model)).Elem()
+	v.Set(reflect.ValueOf(model))
+	clearVersions(v)
+	return v.Interface()
+}
+
+func clearVersions(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			switch {
+			case !field.IsExported():
+			case field.Name == "Version":
+				v.Field(i).SetUint(0)
+			default:
+				clearVersions(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		v.Set(c)
+		for i := 0; i < c.Len(); i++ {
+			clearVersions(c.Index(i))
+		}
+	}
+}
+
 func TestAPI(t *testing.T) {
 	// This is a more limited set of tests than the full tests at the model
 	// layer to avoid a lot of redundancy.  As a next step, we could work to
 	// mock out the underlying database here to focus on testing routing code.
 	// For now, we just don't worry about generating all possible database
 	// errors, instead focusing on routing/parsing.
-	router := NewRouter()
+	router := NewRouter(model.NewMemoryStore())
 
 	// Dummy list.
 	newlist := model.TodoList{
-		"d290f1ee-6c54-4b01-90e6-d701748f0851",
-		"Home",
-		"The list of things that need to be done at home\n",
-		[]model.Task{},
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []model.Task{},
 	}
 
 	// Add a list, succeeds.
@@ -51,7 +102,7 @@ func TestAPI(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 
 	// Dummy task.
-	newtask := model.Task{"0e2ac84f-f723-4f24-878b-44e63e7ae580", "mow the yard", false}
+	newtask := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}
 
 	// Add a task, succeeds.
 	body, _ = json.Marshal(newtask)
@@ -76,7 +127,7 @@ func TestAPI(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 
 	// Completed state.
-	completed := model.CompletedTask{true}
+	completed := model.CompletedTask{Completed: true}
 
 	// Complete a task, succeeds.
 	body, _ = json.Marshal(completed)
@@ -113,7 +164,14 @@ func TestAPI(t *testing.T) {
 	resultlist := model.TodoList{}
 	err := json.NewDecoder(resp.Body).Decode(&resultlist)
 	assert.Nil(t, err)
-	assert.Equal(t, newlist, resultlist)
+	assert.Equal(t, &model.TaskCounts{Total: 1, Filtered: 1}, resultlist.Counts)
+	require.NotNil(t, resultlist.Stats)
+	assert.Equal(t, 1, resultlist.Stats.Completed)
+	assert.Equal(t, 100, resultlist.Stats.PercentDone)
+	assert.NotNil(t, resultlist.Stats.LastActivity)
+	resultlist.Counts = nil
+	resultlist.Stats = nil
+	assert.Equal(t, newlist, unversioned(resultlist))
 
 	// Get lists, succeeds.
 	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", nil)
@@ -127,7 +185,7 @@ func TestAPI(t *testing.T) {
 	resultlists := []model.TodoList{}
 	err = json.NewDecoder(resp.Body).Decode(&resultlists)
 	assert.Nil(t, err)
-	assert.Equal(t, newlists, resultlists)
+	assert.Equal(t, newlists, unversioned(dropStats(resultlists)))
 
 	// Get lists w/parameters, succeeds.
 	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?searchString=Home&skip=0&limit=1", nil)
@@ -140,7 +198,7 @@ func TestAPI(t *testing.T) {
 	resultlists = []model.TodoList{}
 	err = json.NewDecoder(resp.Body).Decode(&resultlists)
 	assert.Nil(t, err)
-	assert.Equal(t, newlists, resultlists)
+	assert.Equal(t, newlists, unversioned(dropStats(resultlists)))
 
 	// Use duplicate params incorrectly.
 	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?searchString=Home&searchString=work", nil)
@@ -162,4 +220,1726 @@ func TestAPI(t *testing.T) {
 	router.ServeHTTP(rec, req)
 	resp = rec.Result()
 	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Use a negative skip; fails, naming the parameter.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?skip=-1", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorresponse := ErrorResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&errorresponse)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.FieldError{{Field: "skip", Message: "must not be negative"}}, errorresponse.Fields)
+
+	// Add a task to a non-existent list; fails.
+	body, _ = json.Marshal(newtask)
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0852/tasks", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Complete a non-existent task; fails.
+	body, _ = json.Marshal(completed)
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581/complete", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDelete(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Dummy list.
+	newlist := model.TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []model.Task{model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}},
+	}
+
+	// Add a list, succeeds.
+	body, _ := json.Marshal(newlist)
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Delete a task, succeeds.
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Delete it again, fails.
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Delete the list, succeeds.
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Delete it again, fails.
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Delete a list with an invalid ID, fails.
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/nonsense", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestPatch(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Dummy list.
+	newlist := model.TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []model.Task{model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}},
+	}
+
+	// Add a list, succeeds.
+	body, _ := json.Marshal(newlist)
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Patch the list, succeeds.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", strings.NewReader(`{"name": "House", "description": null}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	newlist.Name = "House"
+	newlist.Description = ""
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(resultlist))
+
+	// Patch the task, succeeds.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", strings.NewReader(`{"name": "mow the lawn"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	newlist.Tasks[0].Name = "mow the lawn"
+	resulttask := model.Task{}
+	err = json.NewDecoder(resp.Body).Decode(&resulttask)
+	assert.Nil(t, err)
+	assert.Equal(t, newlist.Tasks[0], unversioned(resulttask))
+
+	// Patch the list with bad fields, fails and reports each of them.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0852", "name": 5}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorresponse := ErrorResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&errorresponse)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.FieldError{{Field: "id", Message: "is read-only"}, {Field: "name", Message: "must be a string"}}, errorresponse.Fields)
+
+	// Patch a task w/a malformed payload, fails.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", strings.NewReader("This isn't right"))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Patch a non-existent task, fails.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581", strings.NewReader(`{"completed": true}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGeneratedIDs(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list without an ID, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"name": "Home"}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// The new ID is in the body and the Location header.
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, resultlist.ID)
+	assert.Equal(t, "Home", resultlist.Name)
+	location := resp.Header.Get("Location")
+	assert.Equal(t, "/aweiker/ToDo/1.0.0/list/"+resultlist.ID, location)
+
+	// Add a task without an ID, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080"+location+"/tasks", strings.NewReader(`{"name": "mow the yard"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// The new ID is in the body and the Location header.
+	resulttask := model.Task{}
+	err = json.NewDecoder(resp.Body).Decode(&resulttask)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, resulttask.ID)
+	assert.Equal(t, location+"/task/"+resulttask.ID, resp.Header.Get("Location"))
+
+	// The list location can be fetched.
+	req = httptest.NewRequest("GET", "http://localhost:8080"+location, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resultlist.Tasks = []model.Task{resulttask}
+	resultlist.Counts = &model.TaskCounts{Total: 1, Filtered: 1}
+	actuallist := model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&actuallist)
+	assert.Nil(t, err)
+	actuallist.Stats = nil
+	assert.Equal(t, unversioned(resultlist), unversioned(actuallist))
+}
+
+func TestMove(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Dummy list.
+	newlist := model.TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks: []model.Task{
+			model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false},
+			model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the fence", Completed: false},
+		},
+	}
+
+	// Add a list, succeeds.
+	body, _ := json.Marshal(newlist)
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Move the second task to the top, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581/move", strings.NewReader(`{"before": "0e2ac84f-f723-4f24-878b-44e63e7ae580"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resulttask := model.Task{}
+	err := json.NewDecoder(resp.Body).Decode(&resulttask)
+	assert.Nil(t, err)
+	assert.Equal(t, newlist.Tasks[1], unversioned(resulttask))
+
+	// Get the list in position order, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?sort=position", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resultlist := model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Task{newlist.Tasks[1], newlist.Tasks[0]}, unversioned(resultlist.Tasks))
+
+	// Get the list in an unknown order, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?sort=color", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Move a task without saying where, fails.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581/move", strings.NewReader(`{}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Move a non-existent task, fails.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae582/move", strings.NewReader(`{"after": "0e2ac84f-f723-4f24-878b-44e63e7ae580"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// Add a second list holding a task with the same ID, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0852", "name": "Work", "tasks": [{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae581", "name": "paint the office"}]}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Move the clashing task to the second list, fails.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581/move", strings.NewReader(`{"list": "d290f1ee-6c54-4b01-90e6-d701748f0852"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// Move the other task to the second list, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/move", strings.NewReader(`{"list": "d290f1ee-6c54-4b01-90e6-d701748f0852", "before": "0e2ac84f-f723-4f24-878b-44e63e7ae581"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// It's now at the top of the second list.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0852?sort=position", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist = model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Task{newlist.Tasks[0], model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the office", Completed: false}}, unversioned(resultlist.Tasks))
+}
+
+func TestDates(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with dated tasks, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "mow the yard", "due": "2026-11-03T17:00:00+01:00"},
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae581", "name": "paint the fence", "due": "2026-11-06T12:00:00Z", "timeZone": "Europe/Oslo"},
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae582", "name": "wash the car", "due": "2026-11-01T12:00:00Z", "completed": true}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// The times keep their offsets, or move into the task's zone.
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, "2026-11-03T17:00:00+01:00", resultlist.Tasks[0].Due.Format(time.RFC3339))
+	assert.Equal(t, "2026-11-06T13:00:00+01:00", resultlist.Tasks[1].Due.Format(time.RFC3339))
+
+	// Get the overdue tasks on Wednesday, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/tasks?dueBefore=2026-11-04T00:00:00Z&completed=false", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resulttasks := []model.ListTask{}
+	err = json.NewDecoder(resp.Body).Decode(&resulttasks)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.ListTask{{List: "d290f1ee-6c54-4b01-90e6-d701748f0851", Task: resultlist.Tasks[0]}}, resulttasks)
+
+	// Filter the list the same way, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?dueAfter=2026-11-03T16:00:00%2B00:00", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	filteredlist := model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&filteredlist)
+	assert.Nil(t, err)
+	assert.Equal(t, resultlist.Tasks[:2], filteredlist.Tasks)
+
+	// Filter by a malformed time, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/tasks?dueBefore=tomorrow", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Filter by an unknown parameter, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/tasks?overdue=true", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Add a task which starts after it's due, fails.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/tasks", strings.NewReader(`{"name": "weed the garden", "start": "2026-11-05T00:00:00Z", "due": "2026-11-04T00:00:00Z"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestPriority(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with prioritized tasks, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "mow the yard", "priority": "low"},
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae581", "name": "paint the fence", "priority": "urgent"},
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae582", "name": "wash the car", "priority": "none"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	yard := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Priority: "low"}
+	fence := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the fence", Priority: "urgent"}
+	car := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "wash the car"}
+
+	// Get the list by priority, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?sort=priority", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Task{fence, yard, car}, unversioned(resultlist.Tasks))
+
+	// Search for lists with low or urgent tasks, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?priority=low,urgent&taskSort=priority", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resultlists := []model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resultlists))
+	assert.Equal(t, []model.Task{fence, yard}, unversioned(resultlists[0].Tasks))
+
+	// Search by an unknown priority, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?priority=whenever", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Patch a task to an unknown priority, fails.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", strings.NewReader(`{"priority": "whenever"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTags(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add lists with tags, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "mow the yard", "tags": ["Weekend"]},
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae581", "name": "wash the car"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0852", "name": "Work", "tags": ["release-blocker"]}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Tag the car, succeeds.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581", strings.NewReader(`{"tags": ["Release-Blocker"]}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	car := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "wash the car", Tags: []string{"release-blocker"}}
+	resulttask := model.Task{}
+	err := json.NewDecoder(resp.Body).Decode(&resulttask)
+	assert.Nil(t, err)
+	assert.Equal(t, car, unversioned(resulttask))
+
+	// Count the tags, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/tags", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resulttags := []model.TagCount{}
+	err = json.NewDecoder(resp.Body).Decode(&resulttags)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TagCount{{Tag: "release-blocker", Lists: 1, Tasks: 1}, {Tag: "weekend", Lists: 0, Tasks: 1}}, resulttags)
+
+	// Search for release blockers, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?tag=release-blocker", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resultlists := []model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.TodoList{
+		{ID: "d290f1ee-6c54-4b01-90e6-d701748f0851", Name: "Home", Tasks: []model.Task{car}},
+		{ID: "d290f1ee-6c54-4b01-90e6-d701748f0852", Name: "Work", Tags: []string{"release-blocker"}},
+	}, unversioned(dropStats(resultlists)))
+
+	// Filter a list by tag, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?tag=WEEKEND", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	resultlist := model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Task{{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Tags: []string{"weekend"}}}, unversioned(resultlist.Tasks))
+
+	// Patch a list with malformed tags, fails.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", strings.NewReader(`{"tags": "weekend"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestSubtasks(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with subtasks, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "paint the fence"},
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae581", "name": "buy paint", "parent": "0e2ac84f-f723-4f24-878b-44e63e7ae580"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Complete the fence and its subtasks, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/complete", strings.NewReader(`{"completed": true, "cascade": true}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Get the list as a tree, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?view=tree", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results.
+	paint := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "buy paint", Completed: true, Parent: "0e2ac84f-f723-4f24-878b-44e63e7ae580"}
+	fence := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "paint the fence", Completed: true, Subtasks: []model.Task{paint}}
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Task{fence}, unversioned(resultlist.Tasks))
+
+	// Get the list in an unknown view, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?view=forest", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Make the fence a subtask of its own subtask, fails.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", strings.NewReader(`{"parent": "0e2ac84f-f723-4f24-878b-44e63e7ae581"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Delete the fence, succeeds, taking its subtask along.
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581", strings.NewReader(`{"completed": false}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestDependencies(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list where one task blocks another, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "paint the fence", "blockedBy": [{"task": "0e2ac84f-f723-4f24-878b-44e63e7ae581"}]},
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae581", "name": "sand the fence"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Get the list, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results; the painting is blocked.
+	paint := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "paint the fence", BlockedBy: []model.TaskRef{{List: "d290f1ee-6c54-4b01-90e6-d701748f0851", Task: "0e2ac84f-f723-4f24-878b-44e63e7ae581"}}, Blocked: true}
+	sand := model.Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "sand the fence"}
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Task{paint, sand}, unversioned(resultlist.Tasks))
+
+	// Complete the painting, fails.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/complete", strings.NewReader(`{"completed": true}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	// Force it, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/complete", strings.NewReader(`{"completed": true, "force": true}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Make the sanding wait for the painting, fails.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae581", strings.NewReader(`{"blockedBy": [{"task": "0e2ac84f-f723-4f24-878b-44e63e7ae580"}]}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Drop the painting's blockers, succeeds.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", strings.NewReader(`{"blockedBy": null}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resulttask := model.Task{}
+	err = json.NewDecoder(resp.Body).Decode(&resulttask)
+	assert.Nil(t, err)
+	assert.Equal(t, model.Task{ID: paint.ID, Name: paint.Name, Completed: true}, unversioned(resulttask))
+}
+
+func TestRecurrence(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with a monthly task, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "pay the rent", "due": "2026-01-31T12:00:00Z", "recurrence": "FREQ=MONTHLY"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Complete it, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/complete", strings.NewReader(`{"completed": true}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Get the open tasks, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?completed=false", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results; February has no 31st, so the next one is in March.
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlist.Tasks))
+	assert.Equal(t, "2026-03-31T12:00:00Z", resultlist.Tasks[0].Due.Format(time.RFC3339))
+	assert.Equal(t, "FREQ=MONTHLY", resultlist.Tasks[0].Recurrence)
+
+	// Patch in a bad rule, fails.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/"+resultlist.Tasks[0].ID, strings.NewReader(`{"recurrence": "FREQ=MONTHLY;BYDAY=MONDAYS"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Stop it recurring, succeeds.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/"+resultlist.Tasks[0].ID, strings.NewReader(`{"recurrence": null}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resulttask := model.Task{}
+	err = json.NewDecoder(resp.Body).Decode(&resulttask)
+	assert.Nil(t, err)
+	assert.Equal(t, "", resulttask.Recurrence)
+}
+
+func TestComments(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with a task, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "paint the fence"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Add a comment, succeeds.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comments", strings.NewReader(`{"author": "ada", "text": "white or green?"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resultcomment := model.Comment{}
+	err := json.NewDecoder(resp.Body).Decode(&resultcomment)
+	assert.Nil(t, err)
+	assert.Equal(t, "/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comment/"+resultcomment.ID, resp.Header.Get("Location"))
+	assert.Equal(t, "ada", resultcomment.Author)
+	assert.False(t, resultcomment.Created.IsZero())
+
+	// Add a comment w/o an author, fails.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comments", strings.NewReader(`{"text": "green"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Edit it, succeeds.
+	req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comment/"+resultcomment.ID, strings.NewReader(`{"text": "green, after all"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&resultcomment)
+	assert.Nil(t, err)
+	assert.Equal(t, "green, after all", resultcomment.Text)
+	assert.NotNil(t, resultcomment.Updated)
+
+	// Get the comments, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comments", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultcomments := []model.Comment{}
+	err = json.NewDecoder(resp.Body).Decode(&resultcomments)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Comment{resultcomment}, resultcomments)
+
+	// Get the list; the comments are left out unless asked for.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist := model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlist.Tasks))
+	assert.Nil(t, resultlist.Tasks[0].Comments)
+
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?include=comments", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist = model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlist.Tasks))
+	assert.Equal(t, []model.Comment{resultcomment}, resultlist.Tasks[0].Comments)
+
+	// Include something unknown, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?include=history", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Delete it, succeeds; a second time, fails.
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comment/"+resultcomment.ID, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comment/"+resultcomment.ID, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSearch(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add two lists, succeeds.
+	for _, body := range []string{
+		`{"name": "Garden", "description": "Weeding and such"}`,
+		`{"name": "Shopping", "tasks": [{"name": "buy gardening gloves"}]}`,
+	} {
+		req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		resp := rec.Result()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	// Search by prefix, with the matching fields, succeeds.
+	req := httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?searchString=gard&include=matches", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Compare results; the name match ranks first.
+	resultlists := []model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 2, len(resultlists))
+	assert.Equal(t, "Garden", resultlists[0].Name)
+	assert.Equal(t, []string{"name"}, resultlists[0].Matches)
+	assert.Equal(t, "Shopping", resultlists[1].Name)
+	assert.Equal(t, []string{"tasks"}, resultlists[1].Matches)
+
+	// Include something unknown, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?searchString=gard&include=scores", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestSearchQuery(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"name": "Groceries", "tags": ["home"], "tasks": [
+		{"name": "buy milk", "due": "2026-10-30T17:00:00Z"},
+		{"name": "buy eggs", "completed": true}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Search with a query, succeeds.
+	query := url.Values{"searchString": {`name:groceries tag:home completed:false due:<2026-11-01 "buy milk" -excluded`}}
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?"+query.Encode(), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlists := []model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlists))
+	require.Equal(t, 1, len(resultlists[0].Tasks))
+	assert.Equal(t, "buy milk", resultlists[0].Tasks[0].Name)
+
+	// Search with a bad query, fails, saying where.
+	query = url.Values{"searchString": {`name:groceries due:soon`}}
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?"+query.Encode(), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorresponse := ErrorResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&errorresponse)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(errorresponse.Fields))
+	assert.Equal(t, "searchString", errorresponse.Fields[0].Field)
+	assert.Equal(t, 20, errorresponse.Fields[0].Position)
+}
+
+func TestCursor(t *testing.T) {
+	store := model.NewMemoryStore()
+	router := NewRouter(store, WithCursorKey([]byte("secret")))
+
+	// Add some lists, succeeds.
+	for _, name := range []string{"Chores", "Errands", "House", "Work"} {
+		req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"name": "`+name+`"}`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	}
+
+	// Get the first page, succeeds, with a link to the next.
+	req := httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?limit=3", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlists := []model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 3, len(resultlists))
+	assert.Equal(t, "House", resultlists[2].Name)
+	link := resp.Header.Get("Link")
+	require.True(t, strings.HasPrefix(link, "</aweiker/ToDo/1.0.0/lists?"))
+	require.True(t, strings.HasSuffix(link, `>; rel="next"`))
+	next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+	nextURL, err := url.Parse(next)
+	require.Nil(t, err)
+	cursor := nextURL.Query().Get("cursor")
+	assert.NotEqual(t, "", cursor)
+
+	// Add a list before the cursor, then follow the link, succeeds, and the
+	// page doesn't shift.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"name": "Birthdays"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	req = httptest.NewRequest("GET", "http://localhost:8080"+next, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlists))
+	assert.Equal(t, "Work", resultlists[0].Name)
+	assert.Equal(t, "", resp.Header.Get("Link"))
+
+	// A router with the same key takes the cursor, as after a restart.
+	req = httptest.NewRequest("GET", "http://localhost:8080"+next, nil)
+	rec = httptest.NewRecorder()
+	NewRouter(store, WithCursorKey([]byte("secret"))).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+
+	// One with a different key doesn't.
+	req = httptest.NewRequest("GET", "http://localhost:8080"+next, nil)
+	rec = httptest.NewRecorder()
+	NewRouter(store).ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode)
+
+	// Tamper with the cursor, fails.
+	payload, signature, _ := strings.Cut(cursor, ".")
+	tampered := url.Values{"limit": {"3"}, "cursor": {payload + "x." + signature}}
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?"+tampered.Encode(), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	errorresponse := ErrorResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&errorresponse)
+	assert.Nil(t, err)
+	assert.Equal(t, "cursor: not a valid cursor", errorresponse.Message)
+
+	// Use the cursor with another search, fails.
+	other := url.Values{"limit": {"3"}, "searchString": {"house"}, "cursor": {cursor}}
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?"+other.Encode(), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&errorresponse)
+	assert.Nil(t, err)
+	assert.Equal(t, "cursor: belongs to a different search", errorresponse.Message)
+
+	// Use the cursor with skip, fails.
+	skip := url.Values{"limit": {"3"}, "skip": {"1"}, "cursor": {cursor}}
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?"+skip.Encode(), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode)
+
+	// Page by offset, succeeds, without a link.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?skip=1&limit=3", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 3, len(resultlists))
+	assert.Equal(t, "Chores", resultlists[0].Name)
+	assert.Equal(t, "", resp.Header.Get("Link"))
+}
+
+func TestSort(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add some lists, with some of their tasks completed, succeeds.
+	for _, body := range []string{
+		`{"name": "Groceries", "tasks": [{"name": "buy milk", "completed": true}, {"name": "buy eggs"}, {"name": "buy bread"}]}`,
+		`{"name": "Chores", "tasks": [{"name": "sweep", "completed": true}, {"name": "dust", "completed": true}, {"name": "mop"}]}`,
+		`{"name": "Errands"}`,
+	} {
+		req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	}
+
+	// Sort the lists, and their tasks, succeeds.
+	req := httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?sort=-completed,name&taskSort=-completed,name", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlists := []model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 3, len(resultlists))
+	assert.Equal(t, "Chores", resultlists[0].Name)
+	assert.Equal(t, "Groceries", resultlists[1].Name)
+	assert.Equal(t, "Errands", resultlists[2].Name)
+	require.Equal(t, 3, len(resultlists[1].Tasks))
+	assert.Equal(t, "buy milk", resultlists[1].Tasks[0].Name)
+	assert.Equal(t, "buy bread", resultlists[1].Tasks[1].Name)
+
+	// Sort the tasks of one list, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/"+resultlists[1].ID+"?sort=-completed,-name", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist := model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 3, len(resultlist.Tasks))
+	assert.Equal(t, "buy milk", resultlist.Tasks[0].Name)
+	assert.Equal(t, "buy eggs", resultlist.Tasks[1].Name)
+
+	// Page through the lists in the same order, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?sort=-completed,name&limit=2", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	link := resp.Header.Get("Link")
+	next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+	req = httptest.NewRequest("GET", "http://localhost:8080"+next, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlists))
+	assert.Equal(t, "Errands", resultlists[0].Name)
+
+	// The cursor doesn't work for another order, fails.
+	nextURL, err := url.Parse(next)
+	require.Nil(t, err)
+	query := nextURL.Query()
+	query.Set("sort", "name")
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?"+query.Encode(), nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode)
+
+	// Sort by unknown keys, fails.
+	for _, path := range []string{
+		"/aweiker/ToDo/1.0.0/lists?sort=size",
+		"/aweiker/ToDo/1.0.0/lists?taskSort=tasks",
+		"/aweiker/ToDo/1.0.0/list/" + resultlist.ID + "?sort=relevance",
+	} {
+		req = httptest.NewRequest("GET", "http://localhost:8080"+path, nil)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		resp = rec.Result()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, path)
+		errorresponse := ErrorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&errorresponse)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(errorresponse.Fields), path)
+	}
+}
+
+func TestListPage(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with some tasks, and another list, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"name": "Clean the gutters", "tags": ["outside"]},
+		{"name": "Clean the oven", "completed": true},
+		{"name": "Mow the yard", "tags": ["outside"]},
+		{"name": "Paint the fence", "tags": ["outside"]},
+		{"name": "Wash the car", "completed": true, "tags": ["outside"]}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0852", "name": "Work"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+
+	// Get the first page of the incomplete tasks outside, succeeds, with the
+	// counts and a link to the next page.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?completed=false&tag=outside&limit=2", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 2, len(resultlist.Tasks))
+	assert.Equal(t, "Clean the gutters", resultlist.Tasks[0].Name)
+	assert.Equal(t, "Mow the yard", resultlist.Tasks[1].Name)
+	assert.Equal(t, &model.TaskCounts{Total: 5, Filtered: 3}, resultlist.Counts)
+	link := resp.Header.Get("Link")
+	require.True(t, strings.HasPrefix(link, "</aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?"))
+	next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+
+	// Follow the link, succeeds, and that's all.
+	req = httptest.NewRequest("GET", "http://localhost:8080"+next, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist = model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlist.Tasks))
+	assert.Equal(t, "Paint the fence", resultlist.Tasks[0].Name)
+	assert.Equal(t, "", resp.Header.Get("Link"))
+
+	// Filter by name, succeeds.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?name=clean", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist = model.TodoList{}
+	err = json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(resultlist.Tasks))
+	assert.Equal(t, &model.TaskCounts{Total: 5, Filtered: 2}, resultlist.Counts)
+
+	// Use the cursor with another list or filter, fails.
+	nextURL, err := url.Parse(next)
+	require.Nil(t, err)
+	for _, path := range []string{
+		"/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0852?" + nextURL.RawQuery,
+		"/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?completed=true&tag=outside&limit=2&cursor=" + url.QueryEscape(nextURL.Query().Get("cursor")),
+	} {
+		req = httptest.NewRequest("GET", "http://localhost:8080"+path, nil)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		resp = rec.Result()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, path)
+		errorresponse := ErrorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&errorresponse)
+		assert.Nil(t, err)
+		assert.Equal(t, "cursor: belongs to a different search", errorresponse.Message)
+	}
+
+	// Bad pagination parameters, fail.
+	for _, query := range []string{"limit=two", "limit=-1", "limit=2&view=tree", "cursor=nonsense"} {
+		req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?"+query, nil)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode, query)
+	}
+}
+
+func TestStats(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with some tasks, one of them overdue, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"name": "Clean the gutters", "due": "2001-01-01T00:00:00Z"},
+		{"name": "Clean the oven", "completed": true},
+		{"name": "Mow the yard"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+
+	// Get the list, succeeds, with its stats.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?completed=true", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(resultlist.Tasks))
+	require.NotNil(t, resultlist.Stats)
+	assert.Equal(t, 3, resultlist.Stats.Total)
+	assert.Equal(t, 1, resultlist.Stats.Completed)
+	assert.Equal(t, 33, resultlist.Stats.PercentDone)
+	assert.Equal(t, 1, resultlist.Stats.Overdue)
+	assert.NotNil(t, resultlist.Stats.LastActivity)
+
+	// Search for a summary only, succeeds, without the tasks.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?summary=only", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(body), `"tasks"`)
+	resultlists := []model.TodoList{}
+	err = json.Unmarshal(body, &resultlists)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlists))
+	assert.Equal(t, "Home", resultlists[0].Name)
+	assert.Equal(t, 33, resultlists[0].Stats.PercentDone)
+
+	// Search for some other summary, fails.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/lists?summary=full", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode)
+}
+
+func TestVersions(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with a task, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "Mow the yard"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+
+	// Get the list, succeeds, with its version as the ETag and the task's in
+	// the task.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"1.0"`, resp.Header.Get("ETag"))
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(resp.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlist.Tasks))
+	assert.Equal(t, uint64(1), resultlist.Tasks[0].Version)
+
+	// Two clients complete the task at the version they read; the first
+	// succeeds and the second fails.
+	for _, status := range []int{http.StatusCreated, http.StatusPreconditionFailed} {
+		req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/complete", strings.NewReader(`{"completed": true}`))
+		req.Header.Set("If-Match", `"1"`)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Result().StatusCode)
+	}
+
+	// Add a task if the list is at a version it has moved on from, fails, and
+	// with a weak tag, which never matches, fails.
+	for _, tag := range []string{`"1"`, `W/"2"`} {
+		req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/tasks", strings.NewReader(`{"name": "Wash the car"}`))
+		req.Header.Set("If-Match", tag)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Result().StatusCode)
+	}
+
+	// If any of the tags match, or any version will do, succeeds.
+	for _, tag := range []string{`"1", "2"`, `*`} {
+		req = httptest.NewRequest("PATCH", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", strings.NewReader(`{"description": "Chores"}`))
+		req.Header.Set("If-Match", tag)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+	}
+
+	// Comments on the task check its version too, which they don't change.
+	comments := "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comments"
+	comment := "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/comment/5b6c3a0e-9f1d-4e2a-8c7b-1d2e3f4a5b6c"
+	for _, tc := range []struct {
+		method string
+		url    string
+		body   string
+		tag    string
+		status int
+	}{
+		{"POST", comments, `{"id": "5b6c3a0e-9f1d-4e2a-8c7b-1d2e3f4a5b6c", "author": "ada", "text": "done"}`, `"1"`, http.StatusPreconditionFailed},
+		{"POST", comments, `{"id": "5b6c3a0e-9f1d-4e2a-8c7b-1d2e3f4a5b6c", "author": "ada", "text": "done"}`, `"2"`, http.StatusCreated},
+		{"PATCH", comment, `{"text": "all done"}`, `"1"`, http.StatusPreconditionFailed},
+		{"DELETE", comment, "", `"1"`, http.StatusPreconditionFailed},
+		{"DELETE", comment, "", `"2"`, http.StatusNoContent},
+	} {
+		req = httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		req.Header.Set("If-Match", tc.tag)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Result().StatusCode, tc.method+" "+tc.tag)
+	}
+
+	// A batch can't be conditional, and says so rather than ignore the tag.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/batch", strings.NewReader(`[{"op": "deleteList", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851"}]`))
+	req.Header.Set("If-Match", `"4"`)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode)
+
+	// Delete the task at an old version, fails; at its own, succeeds.
+	for _, tc := range []struct {
+		tag    string
+		status int
+	}{{`"1"`, http.StatusPreconditionFailed}, {`"2"`, http.StatusNoContent}} {
+		req = httptest.NewRequest("DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580", nil)
+		req.Header.Set("If-Match", tc.tag)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Result().StatusCode)
+	}
+
+	// The list has moved on with every change.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, `"7.0"`, rec.Result().Header.Get("ETag"))
+}
+
+func TestNotModified(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+
+	// Add a list with an overdue task, succeeds.
+	req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home", "tasks": [
+		{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "Pay the rent", "due": "2001-01-01T00:00:00Z"}
+	]}`))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+
+	// Get the list and search for it, succeeds, with validators.
+	listurl := "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851?completed=false"
+	searchurl := "http://localhost:8080/aweiker/ToDo/1.0.0/lists?summary=only"
+	req = httptest.NewRequest("GET", listurl, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp := rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	listtag := resp.Header.Get("ETag")
+	assert.Equal(t, `"1.1"`, listtag)
+	modified := resp.Header.Get("Last-Modified")
+	assert.NotEqual(t, "", modified)
+	req = httptest.NewRequest("GET", searchurl, nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resp = rec.Result()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	searchtag := resp.Header.Get("ETag")
+	assert.Equal(t, `"s1.1"`, searchtag)
+
+	// Get them again with the tags or the time, or a weak form of the tag,
+	// succeeds, with nothing but the validators.
+	for _, tc := range []struct {
+		url    string
+		header string
+		value  string
+		tag    string
+	}{
+		{listurl, "If-None-Match", listtag, listtag},
+		{listurl, "If-None-Match", `"0.0", W/` + listtag, listtag},
+		{listurl, "If-None-Match", "*", listtag},
+		{listurl, "If-Modified-Since", modified, listtag},
+		{searchurl, "If-None-Match", searchtag, searchtag},
+		{searchurl, "If-Modified-Since", modified, searchtag},
+	} {
+		req = httptest.NewRequest("GET", tc.url, nil)
+		req.Header.Set(tc.header, tc.value)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		resp = rec.Result()
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode, tc.header+": "+tc.value)
+		assert.Equal(t, tc.tag, resp.Header.Get("ETag"))
+		assert.Equal(t, modified, resp.Header.Get("Last-Modified"))
+		assert.Equal(t, 0, rec.Body.Len())
+	}
+
+	// With another tag, succeeds, in full, even if the time matches.
+	req = httptest.NewRequest("GET", listurl, nil)
+	req.Header.Set("If-None-Match", `"0.0"`)
+	req.Header.Set("If-Modified-Since", modified)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+	assert.NotEqual(t, 0, rec.Body.Len())
+
+	// Add another list; the search has changed, but not the first list.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0852", "name": "Work"}`))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	req = httptest.NewRequest("GET", listurl, nil)
+	req.Header.Set("If-None-Match", listtag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotModified, rec.Result().StatusCode)
+	req = httptest.NewRequest("GET", searchurl, nil)
+	req.Header.Set("If-None-Match", searchtag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+	assert.Equal(t, `"s2.1"`, rec.Result().Header.Get("ETag"))
+
+	// Complete the task; the list has changed.  The list's tag is good for
+	// If-Match.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/0e2ac84f-f723-4f24-878b-44e63e7ae580/complete", strings.NewReader(`{"completed": true}`))
+	req.Header.Set("If-Match", listtag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	req = httptest.NewRequest("GET", listurl, nil)
+	req.Header.Set("If-None-Match", listtag)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+	assert.Equal(t, `"3.0"`, rec.Result().Header.Get("ETag"))
+
+	// Add a list with a task blocked by one in another list.  Completing the
+	// blocker changes the list, and so does deleting the blocker's list.
+	for _, body := range []string{
+		`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0853", "name": "Shed", "tasks": [{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae581", "name": "Oil the mower"}]}`,
+		`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0854", "name": "Garden", "tasks": [{"name": "Mow the lawn", "blockedBy": [{"list": "d290f1ee-6c54-4b01-90e6-d701748f0853", "task": "0e2ac84f-f723-4f24-878b-44e63e7ae581"}]}]}`,
+	} {
+		req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(body))
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusCreated, rec.Result().StatusCode)
+	}
+	gardenurl := "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0854"
+	for _, tc := range []struct {
+		method string
+		url    string
+		body   string
+		tag    string
+		next   string
+	}{
+		{"POST", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0853/task/0e2ac84f-f723-4f24-878b-44e63e7ae581/complete", `{"completed": true}`, `"5.0"`, `"6.0"`},
+		{"DELETE", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0853", "", `"6.0"`, `"7.0"`},
+	} {
+		req = httptest.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Less(t, rec.Result().StatusCode, 300, tc.method)
+		req = httptest.NewRequest("GET", gardenurl, nil)
+		req.Header.Set("If-None-Match", tc.tag)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Result().StatusCode, tc.method)
+		assert.Equal(t, tc.next, rec.Result().Header.Get("ETag"), tc.method)
+	}
+
+	// A bad request still fails, even if the client has the lists.
+	for _, url := range []string{
+		listurl + "&sort=colour",
+		listurl + "&cursor=junk",
+		searchurl + "&searchString=due:%3Ctomorrow",
+		searchurl + "&cursor=junk",
+	} {
+		req = httptest.NewRequest("GET", url, nil)
+		req.Header.Set("If-None-Match", "*")
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Result().StatusCode, url)
+	}
+
+	// A missing list is still not found.
+	req = httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0859", nil)
+	req.Header.Set("If-None-Match", "*")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Result().StatusCode)
+}
+
+func TestIdempotency(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	clock := func(api *TodoAPI) {
+		api.now = func() time.Time { return now }
+	}
+	router := NewRouter(model.NewMemoryStore(), WithIdempotencyTTL(time.Hour), clock)
+	post := func(path string, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0"+path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Add a list with a key, and retry, succeeds both times with the same
+	// response, the second one replayed.
+	list := `{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home"}`
+	first := post("/lists", "a6e7c4d2", list)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, "", first.Header().Get("Idempotent-Replayed"))
+	retry := post("/lists", "a6e7c4d2", list)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Header().Get("Location"), retry.Header().Get("Location"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+
+	// Without the key, fails, as the list exists.
+	assert.Equal(t, http.StatusConflict, post("/lists", "", list).Code)
+
+	// Add a task with a generated ID, and retry; there's only one task.
+	first = post("/list/d290f1ee-6c54-4b01-90e6-d701748f0851/tasks", "b1", `{"name": "Mow the yard"}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	retry = post("/list/d290f1ee-6c54-4b01-90e6-d701748f0851/tasks", "b1", `{"name": "Mow the yard"}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	req := httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/d290f1ee-6c54-4b01-90e6-d701748f0851", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	resultlist := model.TodoList{}
+	err := json.NewDecoder(rec.Body).Decode(&resultlist)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(resultlist.Tasks))
+
+	// Complete it with a key, and retry, succeeds.
+	complete := "/list/d290f1ee-6c54-4b01-90e6-d701748f0851/task/" + resultlist.Tasks[0].ID + "/complete"
+	assert.Equal(t, http.StatusCreated, post(complete, "c1", `{"completed": true}`).Code)
+	assert.Equal(t, http.StatusCreated, post(complete, "c1", `{"completed": true}`).Code)
+
+	// Reuse a key with another body, or another path, fails.
+	assert.Equal(t, http.StatusUnprocessableEntity, post(complete, "c1", `{"completed": false}`).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, post("/lists", "c1", `{"completed": true}`).Code)
+
+	// So does reusing it with another precondition.
+	req = httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0"+complete, strings.NewReader(`{"completed": true}`))
+	req.Header.Set("Idempotency-Key", "c1")
+	req.Header.Set("If-Match", `"1"`)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// Errors are replayed too.
+	missing := "/list/d290f1ee-6c54-4b01-90e6-d701748f0859/tasks"
+	assert.Equal(t, http.StatusNotFound, post(missing, "d1", `{"name": "Wash the car"}`).Code)
+	retry = post(missing, "d1", `{"name": "Wash the car"}`)
+	assert.Equal(t, http.StatusNotFound, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+
+	// A key that's too long fails, and so does a body that's too large.
+	assert.Equal(t, http.StatusBadRequest, post("/lists", strings.Repeat("k", 256), list).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/lists", "e2", `{"name": "`+strings.Repeat("x", maxBodySize)+`"}`).Code)
+
+	// Once the time is up, the key is forgotten, and the request is made
+	// again.
+	now = now.Add(time.Hour)
+	retry = post("/lists", "a6e7c4d2", list)
+	assert.Equal(t, http.StatusConflict, retry.Code)
+	assert.Equal(t, "", retry.Header().Get("Idempotent-Replayed"))
+
+	// A retry while the first request is still going fails; once it's done,
+	// the retry gets its response, unless it failed on our side.
+	for _, status := range []int{http.StatusCreated, http.StatusInternalServerError} {
+		api := &TodoAPI{idempotency: newIdempotencyCache(time.Hour, DefaultIdempotencyBudget, time.Now)}
+		started, release := make(chan bool), make(chan bool)
+		handler := api.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- true
+			<-release
+			w.WriteHeader(status)
+		}))
+		serve := func() int {
+			req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/lists", strings.NewReader(list))
+			req.Header.Set("Idempotency-Key", "e1")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+		done := make(chan int)
+		go func() {
+			done <- serve()
+		}()
+		<-started
+		assert.Equal(t, http.StatusConflict, serve())
+		release <- true
+		assert.Equal(t, status, <-done)
+		if status == http.StatusCreated {
+			assert.Equal(t, status, serve())
+		} else {
+			go func() {
+				<-started
+				release <- true
+			}()
+			assert.Equal(t, status, serve())
+		}
+	}
+
+	// Once the responses outgrow the budget, the oldest are forgotten,
+	// before they expire.  One which doesn't fit at all isn't kept.
+	cache := newIdempotencyCache(time.Hour, 2600, time.Now)
+	keep := func(key string, size int) {
+		e, fresh := cache.start(key, [sha256.Size]byte{})
+		require.True(t, fresh, key)
+		rec := &recorder{status: http.StatusCreated}
+		rec.body.WriteString(strings.Repeat("x", size))
+		cache.finish(e, rec)
+	}
+	kept := func(key string) bool {
+		e, ok := cache.entries[key]
+		return ok && e.done
+	}
+	keep("f1", 1000)
+	keep("f2", 1000)
+	assert.True(t, kept("f1"))
+	assert.True(t, kept("f2"))
+	keep("f3", 1000)
+	assert.False(t, kept("f1"))
+	assert.True(t, kept("f2"))
+	assert.True(t, kept("f3"))
+	keep("f4", 3000)
+	assert.False(t, kept("f4"))
+	assert.LessOrEqual(t, cache.size, 2600)
+}
+
+func TestBatch(t *testing.T) {
+	router := NewRouter(model.NewMemoryStore())
+	batch := func(body string) (*httptest.ResponseRecorder, []batchResult) {
+		req := httptest.NewRequest("POST", "http://localhost:8080/aweiker/ToDo/1.0.0/batch", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var response struct {
+			Results []batchResult `json:"results"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec, response.Results
+	}
+	getList := func(id string) (int, model.TodoList) {
+		req := httptest.NewRequest("GET", "http://localhost:8080/aweiker/ToDo/1.0.0/list/"+id, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		resultlist := model.TodoList{}
+		json.NewDecoder(rec.Body).Decode(&resultlist)
+		return rec.Code, resultlist
+	}
+
+	// Add a list with a task, complete the task, rename the list and comment
+	// on the task, all in one batch.  Each result is what the request would
+	// have returned on its own.
+	rec, results := batch(`[
+		{"op": "addList", "body": {"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": "Home"}},
+		{"op": "addTask", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "body": {"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "name": "mow the yard"}},
+		{"op": "setCompleted", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "task": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "body": {"completed": true}},
+		{"op": "updateList", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "body": {"name": "House"}},
+		{"op": "addComment", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "task": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "body": {"author": "ada", "text": "done"}}
+	]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, 5, len(results))
+	statuses := []int{}
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusCreated}, statuses)
+	assert.Equal(t, "mow the yard", results[1].Body.(map[string]interface{})["name"])
+	assert.Nil(t, results[2].Body)
+	assert.Equal(t, "House", results[3].Body.(map[string]interface{})["name"])
+	assert.Equal(t, "done", results[4].Body.(map[string]interface{})["text"])
+	code, resultlist := getList("d290f1ee-6c54-4b01-90e6-d701748f0851")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "House", resultlist.Name)
+	require.Equal(t, 1, len(resultlist.Tasks))
+	assert.True(t, resultlist.Tasks[0].Completed)
+	assert.Equal(t, uint64(1), resultlist.Version)
+
+	// Delete the task and add another list, and then fail on a missing task.
+	// The response has the failure's status, and the results say which
+	// operation failed and that the others weren't made.
+	rec, results = batch(`[
+		{"op": "deleteTask", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "task": "0e2ac84f-f723-4f24-878b-44e63e7ae580"},
+		{"op": "addList", "body": {"id": "d290f1ee-6c54-4b01-90e6-d701748f0852", "name": "Work"}},
+		{"op": "updateTask", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "task": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "body": {"name": "mow the lawn"}},
+		{"op": "deleteList", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851"}
+	]`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, 4, len(results))
+	assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+	assert.Equal(t, http.StatusFailedDependency, results[1].Status)
+	assert.Equal(t, http.StatusNotFound, results[2].Status)
+	assert.Contains(t, results[2].Body.(map[string]interface{})["message"], "not found")
+	assert.Equal(t, http.StatusFailedDependency, results[3].Status)
+	assert.Nil(t, results[3].Body)
+
+	// None of the changes were made.
+	code, resultlist = getList("d290f1ee-6c54-4b01-90e6-d701748f0851")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, len(resultlist.Tasks))
+	assert.Equal(t, uint64(1), resultlist.Version)
+	code, _ = getList("d290f1ee-6c54-4b01-90e6-d701748f0852")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Deletions have no body.
+	rec, results = batch(`[{"op": "deleteTask", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "task": "0e2ac84f-f723-4f24-878b-44e63e7ae580"}]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []batchResult{{Status: http.StatusNoContent}}, results)
+
+	// A malformed body, an invalid patch or an unknown operation fails its
+	// operation with 400.
+	for _, op := range []string{
+		`{"op": "addTask", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "body": {"name": 7}}`,
+		`{"op": "addTask", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851"}`,
+		`{"op": "updateList", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851", "body": {"id": "other"}}`,
+		`{"op": "renameList", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851"}`,
+	} {
+		rec, results = batch(`[{"op": "deleteList", "list": "d290f1ee-6c54-4b01-90e6-d701748f0851"}, ` + op + `]`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, op)
+		require.Equal(t, 2, len(results))
+		assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+		assert.Equal(t, http.StatusBadRequest, results[1].Status)
+	}
+	code, _ = getList("d290f1ee-6c54-4b01-90e6-d701748f0851")
+	assert.Equal(t, http.StatusOK, code)
+
+	// So does a malformed or overlong batch, as a whole.
+	rec, _ = batch(`{"op": "deleteList"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = batch("[" + strings.Repeat(`{"op": "deleteList"}, `, maxBatch) + `{"op": "deleteList"}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// An empty batch succeeds, doing nothing.
+	rec, results = batch(`[]`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []batchResult{}, results)
 }
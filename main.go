@@ -11,16 +11,53 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"time"
+	_ "time/tzdata" // Tasks name IANA time zones; don't depend on the host having them
 
 	sw "github.com/marvold/todo/go"
+	"github.com/marvold/todo/model"
 )
 
 func main() {
+	data := flag.String("data", "", "directory to keep the lists in; if empty, lists are kept in memory only")
+	flag.Parse()
+
+	// Choose the store.  Without a data directory everything is lost on
+	// restart, which is fine for trying the service out.  The file store syncs
+	// every mutation before acknowledging it, so there's nothing to flush on
+	// the way out.
+	var store model.Store = model.NewMemoryStore()
+	if *data != "" {
+		fs, err := model.OpenFileStore(*data, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store = fs
+	}
+
 	log.Printf("Server started")
 
-	router := sw.NewRouter()
+	// Paging cursors are signed, with a key which may be given so that they
+	// survive a restart.
+	options := []sw.Option{}
+	if key := os.Getenv("TODO_CURSOR_KEY"); key != "" {
+		options = append(options, sw.WithCursorKey([]byte(key)))
+	}
+
+	// Responses to requests with idempotency keys are kept for a day, unless
+	// told otherwise.
+	if ttl := os.Getenv("TODO_IDEMPOTENCY_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			log.Fatalf("TODO_IDEMPOTENCY_TTL: must be a positive duration, such as 1h: %q", ttl)
+		}
+		options = append(options, sw.WithIdempotencyTTL(d))
+	}
+	router := sw.NewRouter(store, options...)
 
 	log.Fatal(http.ListenAndServe(":8080", router))
 }
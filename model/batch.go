@@ -0,0 +1,298 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A batch makes a series of changes all or nothing.  The changes are made one
+// after another under a single hold of the write lock, each seeing the ones
+// before it, by the same code as the Store methods, but their records are
+// applied without being journaled.  If a change fails, the lists the batch
+// has changed so far are put back as they were; once they have all been made,
+// their records are journaled together as one batch record, which replays
+// them in order.  The whole batch has one sequence number and time, so
+// everything it changes ends up at the same version.
+//
+// Putting the lists back takes copies of them from before the batch changed
+// them, which are made as each record is applied: of the lists the record
+// names, and of any with tasks blocked by the tasks it deletes, moves,
+// completes or reopens, since those lists' versions change with them; see
+// dependencies.go.
+
+// Operation is a change to make in a batch: the name of the Store method
+// which makes it, such as "addTask", and the method's arguments.  The fields
+// the method doesn't take are ignored.
+type Operation struct {
+	Op           string
+	ID           string // The list's
+	TaskID       string
+	CommentID    string
+	List         TodoList      // For addList
+	Task         Task          // For addTask
+	Completed    CompletedTask // For setCompleted
+	ListPatch    TodoListPatch // For updateList
+	TaskPatch    TaskPatch     // For updateTask
+	Move         TaskMove      // For moveTask
+	Comment      Comment       // For addComment
+	CommentPatch CommentPatch  // For updateComment
+}
+
+// OperationResult is what an operation in a batch returned, if anything.
+type OperationResult struct {
+	List    *TodoList
+	Task    *Task
+	Comment *Comment
+}
+
+// BatchError reports the operation which failed a batch, and why.  None of
+// the batch's changes are made.
+type BatchError struct {
+	Index int // Of the operation, from zero
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// txn is what a change is part of: a condition on versions to check, a
+// batch to add to, or neither.  A nil txn is a change on its own.
+type txn struct {
+	cond  *condition
+	batch *batch
+}
+
+func (tx *txn) checkList(listid uuid.UUID, l list) error {
+	if tx == nil {
+		return nil
+	}
+	return tx.cond.checkList(listid, l)
+}
+
+func (tx *txn) checkTask(taskid uuid.UUID, t task) error {
+	if tx == nil {
+		return nil
+	}
+	return tx.cond.checkTask(taskid, t)
+}
+
+// lockFor takes the write lock for a change and returns the function to
+// release it, unless the change is part of a batch, which already holds it.
+func (s *MemoryStore) lockFor(tx *txn) func() {
+	if tx != nil && tx.batch != nil {
+		return func() {}
+	}
+	s.lock.Lock()
+	return s.lock.Unlock
+}
+
+// batch collects the records of a batch as they are applied.
+type batch struct {
+	seq     uint64
+	time    time.Time
+	changed time.Time // The store's before the batch
+	records []record
+	saved   map[uuid.UUID]*list // The lists changed, as they were; nil for those added
+}
+
+// Batch makes the changes described by a series of operations, all or
+// nothing, and returns what each returned.
+func (s *MemoryStore) Batch(ops []Operation) ([]OperationResult, error) {
+	// Lock the database for writing, for the whole batch.
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Make the changes.
+	b := &batch{seq: s.seq + 1, time: s.clock(), changed: s.changed, saved: make(map[uuid.UUID]*list)}
+	tx := &txn{batch: b}
+	results := make([]OperationResult, len(ops))
+	for i, op := range ops {
+		var err error
+		results[i], err = s.operate(op, tx)
+		if err != nil {
+			s.rollback(b)
+			return nil, &BatchError{i, err}
+		}
+	}
+	if len(b.records) == 0 {
+		return results, nil
+	}
+
+	// Journal them.
+	if s.journal != nil {
+		r := record{Seq: b.seq, Op: opBatch, Time: timePointer(b.time), Batch: b.records}
+		if err := s.journal.append(&r); err != nil {
+			s.rollback(b)
+			return nil, err
+		}
+		s.journal.applied()
+	}
+	return results, nil
+}
+
+// operate makes the change an operation describes as part of a transaction.
+func (s *MemoryStore) operate(op Operation, tx *txn) (OperationResult, error) {
+	var result OperationResult
+	var err error
+	switch op.Op {
+	case opAddList:
+		var l TodoList
+		l, err = s.addList(op.List, tx)
+		result.List = &l
+	case opAddTask:
+		var t Task
+		t, err = s.addTask(op.ID, op.Task, tx)
+		result.Task = &t
+	case opSetCompleted:
+		err = s.setCompleted(op.ID, op.TaskID, op.Completed, tx)
+	case opUpdateList:
+		var l TodoList
+		l, err = s.updateList(op.ID, op.ListPatch, tx)
+		result.List = &l
+	case opUpdateTask:
+		var t Task
+		t, err = s.updateTask(op.ID, op.TaskID, op.TaskPatch, tx)
+		result.Task = &t
+	case opMoveTask:
+		var t Task
+		t, err = s.moveTask(op.ID, op.TaskID, op.Move, tx)
+		result.Task = &t
+	case opDeleteList:
+		err = s.deleteList(op.ID, tx)
+	case opDeleteTask:
+		err = s.deleteTask(op.ID, op.TaskID, tx)
+	case opAddComment:
+		var c Comment
+		c, err = s.addComment(op.ID, op.TaskID, op.Comment, tx)
+		result.Comment = &c
+	case opUpdateComment:
+		var c Comment
+		c, err = s.updateComment(op.ID, op.TaskID, op.CommentID, op.CommentPatch, tx)
+		result.Comment = &c
+	case opDeleteComment:
+		err = s.deleteComment(op.ID, op.TaskID, op.CommentID, tx)
+	default:
+		err = invalid("op", "unknown operation %q", op.Op)
+	}
+	if err != nil {
+		return OperationResult{}, err
+	}
+	return result, nil
+}
+
+// apply applies a record in a batch, saving the lists it changes first.  The
+// caller must hold the write lock.
+func (b *batch) apply(s *MemoryStore, r record) {
+	r.Seq = b.seq
+	r.Time = timePointer(b.time)
+
+	// Find the tasks whose dependents the record may change: those going
+	// away from where they are, and those it may complete or reopen.
+	tasks := s.lists[r.List].tasks
+	destid := r.destination()
+	var blockers []uuid.UUID
+	switch r.Op {
+	case opDeleteList:
+		for taskid := range tasks {
+			blockers = append(blockers, taskid)
+		}
+	case opDeleteTask:
+		blockers = subtree(tasks, r.TaskID)
+	case opMoveTask:
+		if destid != uuid.Nil && destid != r.List {
+			blockers = subtree(tasks, r.TaskID)
+		}
+	case opSetCompleted:
+		blockers = []uuid.UUID{r.TaskID}
+		if r.Cascade {
+			blockers = subtree(tasks, r.TaskID)
+		}
+	case opUpdateTask:
+		for _, t := range r.Tasks {
+			blockers = append(blockers, t.ID)
+		}
+	}
+
+	// Save the lists.
+	b.save(s, r.List)
+	if destid != uuid.Nil {
+		b.save(s, destid)
+	}
+	for _, taskid := range blockers {
+		for key := range s.dependencies[taskKey{r.List, taskid}] {
+			b.save(s, key.list)
+		}
+	}
+
+	s.apply(r)
+	b.records = append(b.records, r)
+}
+
+// save copies a list the first time the batch changes it.  The tasks' slices
+// are never changed in place, so copying the tasks will do.
+func (b *batch) save(s *MemoryStore, listid uuid.UUID) {
+	if _, ok := b.saved[listid]; ok {
+		return
+	}
+	l, ok := s.lists[listid]
+	if !ok {
+		b.saved[listid] = nil
+		return
+	}
+	l.tasks = make(taskmap, len(l.tasks))
+	for taskid, t := range s.lists[listid].tasks {
+		l.tasks[taskid] = t
+	}
+	b.saved[listid] = &l
+}
+
+// rollback puts back the lists a batch has changed.  Only their own entries
+// in the indexes are taken out and put back; the rest of the store is as it
+// was.  The caller must hold the write lock.
+func (s *MemoryStore) rollback(b *batch) {
+	for listid := range b.saved {
+		if l, ok := s.lists[listid]; ok {
+			s.unindex(listid, l)
+			delete(s.lists, listid)
+		}
+	}
+	for listid, l := range b.saved {
+		if l != nil {
+			s.lists[listid] = *l
+			s.index(listid, *l)
+		}
+	}
+	s.seq = b.seq - 1
+	s.changed = b.changed
+}
+
+// index adds a list's entries to the indexes.  The caller must hold the write
+// lock.
+func (s *MemoryStore) index(listid uuid.UUID, l list) {
+	s.tags.addList(listid, l.tags)
+	s.search.addList(listid, l)
+	for taskid, t := range l.tasks {
+		s.tags.addTask(taskKey{listid, taskid}, t.tags)
+		s.dependencies.add(taskKey{listid, taskid}, t.blockers)
+		s.dues.add(t)
+	}
+}
+
+// unindex removes a list's entries from the indexes.  The caller must hold
+// the write lock.
+func (s *MemoryStore) unindex(listid uuid.UUID, l list) {
+	s.tags.removeList(listid, l.tags)
+	s.search.removeList(listid, l)
+	for taskid, t := range l.tasks {
+		s.tags.removeTask(taskKey{listid, taskid}, t.tags)
+		s.dependencies.remove(taskKey{listid, taskid}, t.blockers)
+		s.dues.remove(t)
+	}
+}
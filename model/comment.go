@@ -0,0 +1,23 @@
+/*
+ * Simple ToDo API
+ *
+ * This is a simple API for managing a TODO List
+ *
+ * API version: 1.0.0
+ * Contact: recruiting@dfsco.com
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package model
+
+import (
+	"time"
+)
+
+type Comment struct {
+	ID      string     `json:"id"`
+	Author  string     `json:"author"`
+	Text    string     `json:"text"`
+	Created time.Time  `json:"created"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
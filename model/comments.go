@@ -0,0 +1,323 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A task may carry a thread of comments, each with its author and the times
+// it was written and last edited.  Comments belong to their task, so they go
+// wherever it does and are deleted with it, and they are kept in the order
+// they were added.  They are left out of GetList unless asked for, since a
+// long thread would swamp the list.
+//
+// Comments are journaled with records of their own rather than as part of
+// the task, so that editing a task doesn't rewrite its whole thread; only
+// snapshots carry them with the task.  There is no notion of users yet, so
+// the author is whatever the client says, and anyone may edit or delete a
+// comment.
+
+const maxCommentLength = 10000
+
+type comment struct {
+	id      uuid.UUID
+	author  string
+	text    string
+	created time.Time
+	updated time.Time // Zero if never edited
+}
+
+// commentRecord is the persistent form of a comment.
+type commentRecord struct {
+	ID      uuid.UUID  `json:"id"`
+	Author  string     `json:"author"`
+	Text    string     `json:"text"`
+	Created time.Time  `json:"created"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+func newCommentRecord(c comment) commentRecord {
+	return commentRecord{c.id, c.author, c.text, c.created, timePointer(c.updated)}
+}
+
+func (r commentRecord) comment() comment {
+	return comment{r.ID, r.Author, r.Text, r.Created, timeValue(r.Updated)}
+}
+
+// Produces the output model for a comment.
+func commentModel(c comment) Comment {
+	return Comment{
+		ID:      c.id.String(),
+		Author:  c.author,
+		Text:    c.text,
+		Created: c.created,
+		Updated: timePointer(c.updated),
+	}
+}
+
+// Produces the output model for a thread of comments, or nil if there are
+// none.
+func commentModels(comments []comment) []Comment {
+	if len(comments) == 0 {
+		return nil
+	}
+	response := make([]Comment, 0, len(comments))
+	for _, c := range comments {
+		response = append(response, commentModel(c))
+	}
+	return response
+}
+
+func checkCommentText(text string) error {
+	switch {
+	case strings.TrimSpace(text) == "":
+		return invalid("text", "must not be empty")
+	case len(text) > maxCommentLength:
+		return invalid("text", "must not be longer than %d bytes", maxCommentLength)
+	}
+	return nil
+}
+
+// clock returns the current time for a timestamp.  The store's clock may be
+// replaced in tests.
+func (s *MemoryStore) clock() time.Time {
+	return s.now().UTC()
+}
+
+// findTask finds a task for one of the comment operations.  The caller must
+// hold the lock.
+func (s *MemoryStore) findTask(listid uuid.UUID, taskid uuid.UUID) (task, error) {
+	list, ok := s.lists[listid]
+	if !ok {
+		return task{}, listNotFound(listid)
+	}
+	t, ok := list.tasks[taskid]
+	if !ok {
+		return task{}, taskNotFound(taskid)
+	}
+	return t, nil
+}
+
+// findComment returns the index of a comment in a task's thread, or -1.
+func findComment(t task, commentid uuid.UUID) int {
+	for i, c := range t.comments {
+		if c.id == commentid {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddComment adds a comment to the end of a task's thread and returns it as
+// added, with its ID and creation time.
+func (s *MemoryStore) AddComment(id string, taskID string, model Comment) (Comment, error) {
+	return s.addComment(id, taskID, model, nil)
+}
+
+// addComment is AddComment as part of a transaction.
+func (s *MemoryStore) addComment(id string, taskID string, model Comment, tx *txn) (Comment, error) {
+	// Parse the IDs.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return Comment{}, invalidID(id)
+	}
+	taskid, err := uuid.Parse(taskID)
+	if err != nil {
+		return Comment{}, invalidID(taskID)
+	}
+	commentid, err := newID(model.ID)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	// Check the comment.  The times are the server's to set.
+	if strings.TrimSpace(model.Author) == "" {
+		return Comment{}, invalid("author", "must not be empty")
+	}
+	if err := checkCommentText(model.Text); err != nil {
+		return Comment{}, err
+	}
+	if !model.Created.IsZero() {
+		return Comment{}, invalid("created", "is read-only")
+	}
+	if model.Updated != nil {
+		return Comment{}, invalid("updated", "is read-only")
+	}
+
+	// Lock the database for writing.
+	defer s.lockFor(tx)()
+
+	// Find the task and check for a conflict.
+	t, err := s.findTask(listid, taskid)
+	if err != nil {
+		return Comment{}, err
+	}
+	if err := tx.checkTask(taskid, t); err != nil {
+		return Comment{}, err
+	}
+	if findComment(t, commentid) >= 0 {
+		return Comment{}, commentConflict(commentid)
+	}
+
+	// Modify the actual database.
+	c := comment{id: commentid, author: model.Author, text: model.Text, created: s.clock()}
+	r := newCommentRecord(c)
+	if err := s.commit(tx, record{Op: opAddComment, List: listid, TaskID: taskid, Comment: &r}); err != nil {
+		return Comment{}, err
+	}
+	return commentModel(c), nil
+}
+
+// GetComments returns a task's comments, oldest first.
+func (s *MemoryStore) GetComments(id string, taskID string) ([]Comment, error) {
+	response := []Comment{}
+
+	// Parse the IDs.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return response, invalidID(id)
+	}
+	taskid, err := uuid.Parse(taskID)
+	if err != nil {
+		return response, invalidID(taskID)
+	}
+
+	// Lock the database for reading.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// Find the task.
+	t, err := s.findTask(listid, taskid)
+	if err != nil {
+		return response, err
+	}
+
+	// Produce the output model.
+	for _, c := range t.comments {
+		response = append(response, commentModel(c))
+	}
+	return response, nil
+}
+
+// UpdateComment applies a patch to a comment and returns the updated comment.
+func (s *MemoryStore) UpdateComment(id string, taskID string, commentID string, patch CommentPatch) (Comment, error) {
+	return s.updateComment(id, taskID, commentID, patch, nil)
+}
+
+// updateComment is UpdateComment as part of a transaction.
+func (s *MemoryStore) updateComment(id string, taskID string, commentID string, patch CommentPatch, tx *txn) (Comment, error) {
+	// Parse the IDs.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return Comment{}, invalidID(id)
+	}
+	taskid, err := uuid.Parse(taskID)
+	if err != nil {
+		return Comment{}, invalidID(taskID)
+	}
+	commentid, err := uuid.Parse(commentID)
+	if err != nil {
+		return Comment{}, invalidID(commentID)
+	}
+
+	// Lock the database for writing.
+	defer s.lockFor(tx)()
+
+	// Find the comment to modify.
+	t, err := s.findTask(listid, taskid)
+	if err != nil {
+		return Comment{}, err
+	}
+	if err := tx.checkTask(taskid, t); err != nil {
+		return Comment{}, err
+	}
+	i := findComment(t, commentid)
+	if i < 0 {
+		return Comment{}, commentNotFound(commentid)
+	}
+	c := t.comments[i]
+
+	// Record the patched comment.  An empty patch leaves it unedited.
+	if patch.Text == nil {
+		return commentModel(c), nil
+	}
+	if err := checkCommentText(*patch.Text); err != nil {
+		return Comment{}, err
+	}
+	c.text = *patch.Text
+	c.updated = s.clock()
+
+	// Modify the actual database.
+	r := newCommentRecord(c)
+	if err := s.commit(tx, record{Op: opUpdateComment, List: listid, TaskID: taskid, Comment: &r}); err != nil {
+		return Comment{}, err
+	}
+	return commentModel(c), nil
+}
+
+// DeleteComment removes a comment from a task's thread.
+func (s *MemoryStore) DeleteComment(id string, taskID string, commentID string) error {
+	return s.deleteComment(id, taskID, commentID, nil)
+}
+
+// deleteComment is DeleteComment as part of a transaction.
+func (s *MemoryStore) deleteComment(id string, taskID string, commentID string, tx *txn) error {
+	// Parse the IDs.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return invalidID(id)
+	}
+	taskid, err := uuid.Parse(taskID)
+	if err != nil {
+		return invalidID(taskID)
+	}
+	commentid, err := uuid.Parse(commentID)
+	if err != nil {
+		return invalidID(commentID)
+	}
+
+	// Lock the database for writing.
+	defer s.lockFor(tx)()
+
+	// Find the comment to remove.
+	t, err := s.findTask(listid, taskid)
+	if err != nil {
+		return err
+	}
+	if err := tx.checkTask(taskid, t); err != nil {
+		return err
+	}
+	if findComment(t, commentid) < 0 {
+		return commentNotFound(commentid)
+	}
+
+	// Modify the actual database.
+	return s.commit(tx, record{Op: opDeleteComment, List: listid, TaskID: taskid, Comment: &commentRecord{ID: commentid}})
+}
+
+// applyComment modifies a task's thread according to a record.  The thread
+// is copied rather than changed in place, since copies of the task may share
+// it.  The caller must hold the write lock.
+func (s *MemoryStore) applyComment(r record) {
+	tasks := s.lists[r.List].tasks
+	t := tasks[r.TaskID]
+	comments := make([]comment, 0, len(t.comments)+1)
+	for _, c := range t.comments {
+		switch {
+		case c.id != r.Comment.ID:
+			comments = append(comments, c)
+		case r.Op == opUpdateComment:
+			comments = append(comments, r.Comment.comment())
+		}
+	}
+	if r.Op == opAddComment {
+		comments = append(comments, r.Comment.comment())
+	}
+	if len(comments) == 0 {
+		comments = nil
+	}
+	t.comments = comments
+	tasks[r.TaskID] = t
+}
@@ -12,4 +12,6 @@ package model
 
 type CompletedTask struct {
 	Completed bool `json:"completed"`
+	Cascade   bool `json:"cascade,omitempty"`
+	Force     bool `json:"force,omitempty"`
 }
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"time"
+)
+
+// Tasks may have a start and a due time.  Both are instants, given in RFC 3339
+// with whatever offset the client likes, and are compared as instants.  A task
+// may also name an IANA time zone, such as "Europe/Oslo"; its times are then
+// reported in that zone rather than with the offsets they came in with.  The
+// zone matters for anything computed from a wall clock, such as "the same time
+// next week", where a fixed offset would drift across daylight saving changes.
+//
+// Internally a missing time is the zero time.
+
+func timePointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeValue(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// location returns the task's time zone, or nil if it has none.
+func (t task) location() (*time.Location, error) {
+	if t.timeZone == "" {
+		return nil, nil
+	}
+
+	// LoadLocation also accepts "Local", which means whatever the server
+	// happens to be configured for, and "", which means UTC.  Neither is a
+	// useful thing to store.
+	loc, err := time.LoadLocation(t.timeZone)
+	if err != nil || t.timeZone == "Local" {
+		return nil, invalid("timeZone", "not a known IANA time zone")
+	}
+	return loc, nil
+}
+
+// normalize expresses a task's times in its time zone, if it has one.
+func (t *task) normalize() error {
+	loc, err := t.location()
+	if err != nil || loc == nil {
+		return err
+	}
+	if !t.start.IsZero() {
+		t.start = t.start.In(loc)
+	}
+	if !t.due.IsZero() {
+		t.due = t.due.In(loc)
+	}
+	if !t.anchor.IsZero() {
+		t.anchor = t.anchor.In(loc)
+	}
+	return nil
+}
+
+// checkDates normalizes a task's times and checks that they make sense
+// together.
+func (t *task) checkDates() error {
+	if err := t.normalize(); err != nil {
+		return err
+	}
+	if !t.start.IsZero() && !t.due.IsZero() && t.start.After(t.due) {
+		return invalid("start", "must not be after due")
+	}
+	return nil
+}
+
+// compareDue orders tasks by due time, earliest first, with tasks that have
+// no due time last.
+func compareDue(a task, b task) int {
+	switch {
+	case a.due.Equal(b.due):
+		return 0
+	case b.due.IsZero() || (!a.due.IsZero() && a.due.Before(b.due)):
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,221 @@
+package model
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A task may be blocked by other tasks, in its own list or in others.  The
+// blockers are kept on the dependent task, so a task refers to its blockers
+// by list and task ID; the store also keeps the reverse index, from each
+// blocker to the tasks it blocks, so that deleting or moving a blocker can
+// find the references to it without scanning every list.  Deleting a blocker
+// unblocks its dependents, and moving it to another list updates their
+// references.
+//
+// The dependencies must never form a cycle, or the tasks in it could only be
+// completed by force.  A task is blocked while any of its blockers is still
+// open; this is worked out when the task is read rather than stored, so that
+// completing a blocker needn't change its dependents.  It does change what
+// their lists look like, though, so it stamps those with a new version, as
+// does deleting or moving a blocker, which also changes the dependents
+// themselves.
+
+// compareKeys orders task keys by list and then task ID.
+func compareKeys(a taskKey, b taskKey) int {
+	if c := bytes.Compare(a.list[:], b.list[:]); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.task[:], b.task[:])
+}
+
+// parseBlockers parses the blockers of a task in the given list, where a
+// reference with no list means the task's own.  The result is sorted and
+// without duplicates, or nil if there are none.
+func parseBlockers(listid uuid.UUID, refs []TaskRef) ([]taskKey, error) {
+	seen := make(map[taskKey]bool)
+	result := []taskKey{}
+	for _, ref := range refs {
+		key := taskKey{listid, uuid.Nil}
+		if ref.List != "" {
+			id, err := uuid.Parse(ref.List)
+			if err != nil {
+				return nil, invalid("blockedBy", "not a valid list ID: %q", ref.List)
+			}
+			key.list = id
+		}
+		id, err := uuid.Parse(ref.Task)
+		if err != nil {
+			return nil, invalid("blockedBy", "not a valid task ID: %q", ref.Task)
+		}
+		key.task = id
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return compareKeys(result[i], result[j]) < 0
+	})
+	return result, nil
+}
+
+// blockerRefs returns the output model for a task's blockers.
+func blockerRefs(blockers []taskKey) []TaskRef {
+	if len(blockers) == 0 {
+		return nil
+	}
+	result := make([]TaskRef, 0, len(blockers))
+	for _, key := range blockers {
+		result = append(result, TaskRef{key.list.String(), key.task.String()})
+	}
+	return result
+}
+
+// taskLookup finds a task by its key.
+type taskLookup func(key taskKey) (task, bool)
+
+// lookup finds a task in the store.
+func (s *MemoryStore) lookup(key taskKey) (task, bool) {
+	t, ok := s.lists[key.list].tasks[key.task]
+	return t, ok
+}
+
+// checkBlockers checks that a task may be blocked by the given tasks: that
+// they exist and that none of them depends on the task, directly or not.
+// The task needn't exist yet.
+func checkBlockers(lookup taskLookup, key taskKey, blockers []taskKey) error {
+	// Walk the dependencies out from the new blockers.  If we meet the task,
+	// it would depend on itself.
+	seen := make(map[taskKey]bool)
+	pending := append([]taskKey(nil), blockers...)
+	for len(pending) > 0 {
+		next := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if next == key {
+			return invalid("blockedBy", "would make the task depend on itself")
+		}
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		t, ok := lookup(next)
+		if !ok {
+			return invalid("blockedBy", "no such task %s in list %s", next.task, next.list)
+		}
+		pending = append(pending, t.blockers...)
+	}
+	return nil
+}
+
+// blocked reports whether any of a task's blockers is still open.
+func (s *MemoryStore) blocked(t task) bool {
+	for _, key := range t.blockers {
+		if blocker, ok := s.lookup(key); ok && !blocker.completed {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCompletable checks that a set of tasks in a list may be completed
+// together: that every blocker of each of them is either done or one of the
+// set.
+func (s *MemoryStore) checkCompletable(listid uuid.UUID, tasks taskmap, taskids []uuid.UUID) error {
+	completing := make(map[taskKey]bool, len(taskids))
+	for _, taskid := range taskids {
+		completing[taskKey{listid, taskid}] = true
+	}
+	for _, taskid := range taskids {
+		for _, key := range tasks[taskid].blockers {
+			if blocker, ok := s.lookup(key); ok && !blocker.completed && !completing[key] {
+				return taskBlocked(taskKey{listid, taskid})
+			}
+		}
+	}
+	return nil
+}
+
+// dependencyIndex maps each blocker to the tasks it blocks.
+type dependencyIndex map[taskKey]map[taskKey]bool
+
+func (index dependencyIndex) add(key taskKey, blockers []taskKey) {
+	for _, blocker := range blockers {
+		dependents, ok := index[blocker]
+		if !ok {
+			dependents = make(map[taskKey]bool)
+			index[blocker] = dependents
+		}
+		dependents[key] = true
+	}
+}
+
+func (index dependencyIndex) remove(key taskKey, blockers []taskKey) {
+	for _, blocker := range blockers {
+		delete(index[blocker], key)
+		if len(index[blocker]) == 0 {
+			delete(index, blocker)
+		}
+	}
+}
+
+// touchDependents stamps the lists of the tasks a task blocks with a record's
+// version and time.  The caller must hold the write lock.
+func (s *MemoryStore) touchDependents(key taskKey, now time.Time, seq uint64) {
+	for dependent := range s.dependencies[key] {
+		s.touch(dependent.list, now, seq)
+	}
+}
+
+// replaceBlocker changes or drops a blocker of every task it blocks, stamps
+// them and their lists with a record's version and time, and updates the index
+// to match.  A nil replacement drops it.  The caller must hold the write lock.
+func (s *MemoryStore) replaceBlocker(old taskKey, replacement *taskKey, now time.Time, seq uint64) {
+	for key := range s.dependencies[old] {
+		tasks := s.lists[key.list].tasks
+		t := tasks[key.task]
+		blockers := make([]taskKey, 0, len(t.blockers))
+		for _, blocker := range t.blockers {
+			if blocker != old {
+				blockers = append(blockers, blocker)
+			}
+		}
+		if replacement != nil {
+			blockers = append(blockers, *replacement)
+			sort.Slice(blockers, func(i, j int) bool {
+				return compareKeys(blockers[i], blockers[j]) < 0
+			})
+			s.dependencies.add(key, []taskKey{*replacement})
+		}
+		if len(blockers) == 0 {
+			blockers = nil
+		}
+		t.blockers = blockers
+		t.updated = now
+		t.version = seq
+		tasks[key.task] = t
+		s.touch(key.list, now, seq)
+	}
+	delete(s.dependencies, old)
+}
+
+// dropTask removes a task which is going away from the index, unblocking its
+// dependents.  The caller must hold the write lock.
+func (s *MemoryStore) dropTask(key taskKey, t task, now time.Time, seq uint64) {
+	s.dependencies.remove(key, t.blockers)
+	s.replaceBlocker(key, nil, now, seq)
+}
+
+// rekeyTask updates the index for a task which has moved to another list.
+// The caller must hold the write lock.
+func (s *MemoryStore) rekeyTask(old taskKey, key taskKey, t task, now time.Time, seq uint64) {
+	s.dependencies.remove(old, t.blockers)
+	s.dependencies.add(key, t.blockers)
+	s.replaceBlocker(old, &key, now, seq)
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// The store keeps count of its overdue tasks as time passes, so that
+// GetListsVersion needn't look at every task of every list.  The index holds
+// the due times of the open tasks which weren't yet overdue when it last
+// counted, soonest first; counting again takes off the top those which have
+// fallen due since.  A task which goes away, is completed or changes its due
+// time before it falls due leaves its old time in the heap, to be skipped when
+// it comes to the top, and the heap is cleared of them once they make up half
+// of it.
+//
+// The modified time is the latest due time the index has counted.  That may
+// be a task's which has since been completed or deleted, but then the record
+// which did so came after it, and the store's changed time is later still.
+
+// dueIndex counts the overdue tasks of a store.  Changes to it are made under
+// the store's write lock, but counting moves it on under the read lock, so it
+// has its own lock as well.
+type dueIndex struct {
+	lock     sync.Mutex
+	asOf     time.Time // When it last counted
+	overdue  int       // Of the tasks due before asOf
+	latest   time.Time // The latest due time counted
+	upcoming dueHeap
+	skipped  map[time.Time]int // Due times in the heap of tasks gone, by the time in UTC
+	nskipped int
+}
+
+func newDueIndex() *dueIndex {
+	return &dueIndex{skipped: make(map[time.Time]int)}
+}
+
+// add adds a task to the index, if it's open and has a due time.
+func (d *dueIndex) add(t task) {
+	if t.completed || t.due.IsZero() {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if t.due.Before(d.asOf) {
+		d.overdue++
+		if t.due.After(d.latest) {
+			d.latest = t.due
+		}
+		return
+	}
+	key := dueKey(t.due)
+	if d.skipped[key] > 0 {
+		d.unskip(key)
+		return
+	}
+	heap.Push(&d.upcoming, t.due)
+}
+
+// remove takes a task out of the index.
+func (d *dueIndex) remove(t task) {
+	if t.completed || t.due.IsZero() {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if t.due.Before(d.asOf) {
+		d.overdue--
+		return
+	}
+	d.skipped[dueKey(t.due)]++
+	d.nskipped++
+	if d.nskipped > len(d.upcoming.times)/2 {
+		d.compact()
+	}
+}
+
+// count returns how many tasks are overdue at a given time, and the latest
+// due time of any task which has fallen due.  It fails if the time is before
+// the last it counted at, since the tasks which have fallen due since then
+// are no longer in the heap.
+func (d *dueIndex) count(now time.Time) (int, time.Time, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if now.Before(d.asOf) {
+		return 0, time.Time{}, false
+	}
+	for d.upcoming.Len() > 0 && d.upcoming.times[0].Before(now) {
+		due := heap.Pop(&d.upcoming).(time.Time)
+		if key := dueKey(due); d.skipped[key] > 0 {
+			d.unskip(key)
+			continue
+		}
+		d.overdue++
+		if due.After(d.latest) {
+			d.latest = due
+		}
+	}
+	d.asOf = now
+	return d.overdue, d.latest, true
+}
+
+// unskip takes off a due time to be skipped, once it has been.
+func (d *dueIndex) unskip(key time.Time) {
+	d.skipped[key]--
+	if d.skipped[key] == 0 {
+		delete(d.skipped, key)
+	}
+	d.nskipped--
+}
+
+// compact clears the heap of the due times to be skipped.
+func (d *dueIndex) compact() {
+	times := d.upcoming.times[:0]
+	for _, due := range d.upcoming.times {
+		if key := dueKey(due); d.skipped[key] > 0 {
+			d.unskip(key)
+			continue
+		}
+		times = append(times, due)
+	}
+	d.upcoming.times = times
+	heap.Init(&d.upcoming)
+}
+
+// dueKey returns a due time in a form which compares equal to any other for
+// the same instant.
+func dueKey(due time.Time) time.Time {
+	return due.UTC().Round(0)
+}
+
+// dueHeap keeps due times with the soonest on top.
+type dueHeap struct {
+	times []time.Time
+}
+
+func (h *dueHeap) Len() int {
+	return len(h.times)
+}
+
+func (h *dueHeap) Less(i, j int) bool {
+	return h.times[i].Before(h.times[j])
+}
+
+func (h *dueHeap) Swap(i, j int) {
+	h.times[i], h.times[j] = h.times[j], h.times[i]
+}
+
+func (h *dueHeap) Push(x interface{}) {
+	h.times = append(h.times, x.(time.Time))
+}
+
+func (h *dueHeap) Pop() interface{} {
+	last := h.times[len(h.times)-1]
+	h.times = h.times[:len(h.times)-1]
+	return last
+}
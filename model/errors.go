@@ -0,0 +1,107 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The store reports failures with these errors rather than with protocol
+// specific codes, so the model can sit behind any kind of transport.  Errors
+// may be wrapped with more detail; test for them with errors.Is.
+var (
+	// ErrNotFound means a list, task or comment with the given ID doesn't
+	// exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrConflict means a list, task or comment with the given ID already
+	// exists.
+	ErrConflict = errors.New("already exists")
+
+	// ErrInvalidID means an ID isn't a valid UUID.
+	ErrInvalidID = errors.New("invalid ID")
+
+	// ErrVersionMismatch means a change made conditional on the version of a
+	// list or task found it at another version.
+	ErrVersionMismatch = errors.New("version mismatch")
+
+	// ErrBlocked means a task can't be completed because some of its blockers
+	// are still open.
+	ErrBlocked = errors.New("blocked by open tasks")
+
+	// ErrCorrupt is returned when a data file is damaged somewhere other than
+	// at the end of the log, where a torn write is expected after a crash.
+	ErrCorrupt = errors.New("corrupt data file")
+)
+
+// FieldError describes a problem with a single field of the input.
+type FieldError struct {
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+	Position int    `json:"position,omitempty"` // Of the problem within the value, counting from 1
+}
+
+// ValidationError is returned when the input is well formed but has invalid
+// values.  It carries one entry per offending field.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		message := field.Field + ": " + field.Message
+		if field.Position > 0 {
+			message += fmt.Sprintf(" (at %d)", field.Position)
+		}
+		messages = append(messages, message)
+	}
+	return "invalid input: " + strings.Join(messages, "; ")
+}
+
+// invalid returns a validation error for a single field.
+func invalid(field string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{[]FieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// Wrappers to add the offending ID to the sentinel errors.
+
+func invalidID(id string) error {
+	return fmt.Errorf("%w: %q", ErrInvalidID, id)
+}
+
+func listNotFound(id fmt.Stringer) error {
+	return fmt.Errorf("list %s %w", id, ErrNotFound)
+}
+
+func listConflict(id fmt.Stringer) error {
+	return fmt.Errorf("list %s %w", id, ErrConflict)
+}
+
+func taskNotFound(id fmt.Stringer) error {
+	return fmt.Errorf("task %s %w", id, ErrNotFound)
+}
+
+func taskConflict(id fmt.Stringer) error {
+	return fmt.Errorf("task %s %w", id, ErrConflict)
+}
+
+func listMismatch(id fmt.Stringer, version uint64) error {
+	return fmt.Errorf("list %s is at version %d: %w", id, version, ErrVersionMismatch)
+}
+
+func taskMismatch(id fmt.Stringer, version uint64) error {
+	return fmt.Errorf("task %s is at version %d: %w", id, version, ErrVersionMismatch)
+}
+
+func commentNotFound(id fmt.Stringer) error {
+	return fmt.Errorf("comment %s %w", id, ErrNotFound)
+}
+
+func commentConflict(id fmt.Stringer) error {
+	return fmt.Errorf("comment %s %w", id, ErrConflict)
+}
+
+func taskBlocked(key taskKey) error {
+	return fmt.Errorf("task %s is %w", key.task, ErrBlocked)
+}
@@ -0,0 +1,365 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// FileStore is a Store which keeps its data in a directory on disk.  The
+// lists are held in memory exactly as in a MemoryStore, but each mutation is
+// first appended to a write-ahead log and synced to disk.  Every so often the
+// whole database is written out as a compacted snapshot and the log is
+// truncated, so that startup doesn't have to replay the full history.
+//
+// Both files are a sequence of frames: a four byte big-endian length, a four
+// byte CRC-32C of the payload, and the payload itself, which is a record
+// encoded as JSON.  The snapshot starts with a header record holding the
+// sequence number it was taken at, followed by one record per list.  Records
+// in the log at or below that sequence number were already folded into the
+// snapshot; this happens when we crash after writing a snapshot but before
+// truncating the log.
+type FileStore struct {
+	*MemoryStore
+
+	dir              string
+	log              *os.File
+	size             int64 // Size of the log after the last good record
+	records          int   // Records appended since the last snapshot
+	snapshotInterval int
+	err              error // Sticky error which stops further appends
+}
+
+// DefaultSnapshotInterval is the number of records appended to the log
+// between snapshots if no other interval is given.
+const DefaultSnapshotInterval = 1000
+
+const (
+	logName      = "todo.log"
+	snapshotName = "todo.snapshot"
+	opSnapshot   = "snapshot"
+	frameHeader  = 8
+	maxFrame     = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// OpenFileStore opens the store kept in the given directory, creating it if
+// needed, and replays the snapshot and log.  A snapshot is written every
+// snapshotInterval records; zero selects DefaultSnapshotInterval.
+func OpenFileStore(dir string, snapshotInterval int) (*FileStore, error) {
+	if snapshotInterval <= 0 {
+		snapshotInterval = DefaultSnapshotInterval
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{
+		MemoryStore:      NewMemoryStore(),
+		dir:              dir,
+		snapshotInterval: snapshotInterval,
+	}
+	if err := fs.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := fs.replayLog(); err != nil {
+		return nil, err
+	}
+
+	// Only start journaling once we've caught up.
+	fs.journal = fs
+	return fs, nil
+}
+
+// loadSnapshot applies the snapshot, if there is one.
+func (fs *FileStore) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(fs.dir, snapshotName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The snapshot is renamed into place only once complete, so any damage at
+	// all is an error.
+	reader := bufio.NewReader(f)
+	header, _, err := readFrame(reader)
+	if err != nil || header.Op != opSnapshot {
+		return fmt.Errorf("%w: %s: bad header", ErrCorrupt, snapshotName)
+	}
+	for {
+		r, _, err := readFrame(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrCorrupt, snapshotName, err)
+		}
+		fs.apply(r)
+	}
+	fs.seq = header.Seq
+	fs.changed = timeValue(header.Time)
+	return nil
+}
+
+// replayLog applies the records in the log which are newer than the snapshot
+// and leaves the log open for appending.  A torn record at the end of the log
+// is cut off.
+func (fs *FileStore) replayLog() error {
+	f, err := os.OpenFile(filepath.Join(fs.dir, logName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		r, n, err := readFrame(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Only the last record can be torn.  If a complete frame fails its
+			// checksum and there is more data after it, or the frame is intact
+			// but can't be decoded, something else went wrong and we had better
+			// not throw the rest away.
+			torn := err == io.ErrUnexpectedEOF || (err == errChecksum && offset+int64(n) == info.Size())
+			if !torn {
+				f.Close()
+				return fmt.Errorf("%w: %s: bad record at offset %d: %v", ErrCorrupt, logName, offset, err)
+			}
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return err
+			}
+			break
+		}
+		if r.Seq > fs.seq {
+			fs.apply(r)
+		}
+		offset += int64(n)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	fs.log = f
+	fs.size = offset
+	return nil
+}
+
+var (
+	errChecksum  = errors.New("checksum mismatch")
+	errFrameSize = errors.New("frame too large")
+)
+
+// readFrame reads one record, returning the number of bytes consumed.  A
+// clean end of input returns io.EOF; anything short of a full frame returns
+// io.ErrUnexpectedEOF.
+func readFrame(reader io.Reader) (record, int, error) {
+	r := record{}
+
+	header := make([]byte, frameHeader)
+	n, err := io.ReadFull(reader, header)
+	if err != nil {
+		return r, n, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxFrame {
+		return r, n, errFrameSize
+	}
+
+	payload := make([]byte, length)
+	m, err := io.ReadFull(reader, payload)
+	n += m
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return r, n, err
+	}
+	if crc32.Checksum(payload, crcTable) != sum {
+		return r, n, errChecksum
+	}
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return r, n, err
+	}
+	return r, n, nil
+}
+
+// frame encodes a record for writing.
+func frame(r *record) ([]byte, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, frameHeader, frameHeader+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	return append(buf, payload...), nil
+}
+
+// append writes a record to the log and syncs it.  Called with the write
+// lock held.
+func (fs *FileStore) append(r *record) error {
+	if fs.err != nil {
+		return fs.err
+	}
+
+	buf, err := frame(r)
+	if err != nil {
+		return err
+	}
+	_, err = fs.log.Write(buf)
+	if err == nil {
+		err = fs.log.Sync()
+	}
+	if err != nil {
+		// Cut off whatever part of the record made it out, or the next record
+		// would follow a torn one and be lost on replay.  If even that fails,
+		// give up on the log entirely.
+		if terr := fs.log.Truncate(fs.size); terr != nil {
+			fs.err = terr
+		} else if _, serr := fs.log.Seek(fs.size, io.SeekStart); serr != nil {
+			fs.err = serr
+		}
+		return err
+	}
+
+	fs.size += int64(len(buf))
+	fs.records++
+	return nil
+}
+
+// applied writes a snapshot once enough records have accumulated.  If the
+// snapshot fails we keep appending to the log, which remains authoritative,
+// and try again after another interval.
+func (fs *FileStore) applied() {
+	if fs.records >= fs.snapshotInterval {
+		if fs.snapshot() != nil {
+			fs.records = 0
+		}
+	}
+}
+
+// Snapshot writes a compacted snapshot of the store and truncates the log.
+func (fs *FileStore) Snapshot() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return fs.snapshot()
+}
+
+func (fs *FileStore) snapshot() error {
+	if fs.err != nil {
+		return fs.err
+	}
+
+	// Write the snapshot to a temporary file and rename it into place, so
+	// that there is always one complete snapshot on disk.
+	path := filepath.Join(fs.dir, snapshotName)
+	tmp := path + ".tmp"
+	if err := fs.writeSnapshot(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(fs.dir); err != nil {
+		return err
+	}
+
+	// The log is now redundant.  If we crash before truncating it, replay
+	// skips the records by sequence number.
+	if err := fs.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := fs.log.Seek(0, io.SeekStart); err != nil {
+		fs.err = err
+		return err
+	}
+	fs.size = 0
+	fs.records = 0
+	return nil
+}
+
+func (fs *FileStore) writeSnapshot(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	writer := bufio.NewWriter(f)
+
+	// Write the lists in a fixed order; it makes no difference to replay,
+	// but identical databases produce identical files.
+	ids := make([]uuid.UUID, 0, len(fs.lists))
+	for listid := range fs.lists {
+		ids = append(ids, listid)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+
+	records := []record{{Seq: fs.seq, Op: opSnapshot, Time: timePointer(fs.changed)}}
+	for _, listid := range ids {
+		records = append(records, fs.listRecord(listid))
+	}
+	for i := range records {
+		buf, err := frame(&records[i])
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Close syncs and closes the log.  The store must not be used afterwards.
+func (fs *FileStore) Close() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if fs.err == nil {
+		fs.err = errors.New("model: store is closed")
+	}
+	return fs.log.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,397 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Populates a store with a list of two tasks, one of them completed.
+func populate(t *testing.T, store Store) TodoList {
+	newlist := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}},
+	}
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
+
+	newtask := Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "wash the car", Completed: false}
+	_, err = store.AddTask(newlist.ID, newtask)
+	assert.Nil(t, err)
+
+	err = store.SetCompleted(newlist.ID, newtask.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+
+	newtask.Completed = true
+	newlist.Tasks = append(newlist.Tasks, newtask)
+	return newlist
+}
+
+// Collects the times the store keeps for sorting, by list and task ID.
+func storeTimes(store *MemoryStore) map[uuid.UUID][2]time.Time {
+	times := make(map[uuid.UUID][2]time.Time)
+	for listid, l := range store.lists {
+		times[listid] = [2]time.Time{l.created, l.updated}
+		for taskid, task := range l.tasks {
+			times[taskid] = [2]time.Time{task.created, task.updated}
+		}
+	}
+	return times
+}
+
+// Collects the versions of the lists and tasks, by ID.
+func storeVersions(store *MemoryStore) map[uuid.UUID]uint64 {
+	versions := make(map[uuid.UUID]uint64)
+	for listid, l := range store.lists {
+		versions[listid] = l.version
+		for taskid, task := range l.tasks {
+			versions[taskid] = task.version
+		}
+	}
+	return versions
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	// Populate a new store.
+	store, err := OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist := populate(t, store)
+	times := storeTimes(store.MemoryStore)
+	versions := storeVersions(store.MemoryStore)
+	listsinfo, err := store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	// Only moves between lists record a destination.
+	data, err := os.ReadFile(filepath.Join(dir, logName))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "destination")
+
+	// Reopen it; everything is still there, down to the times and versions.
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	actuallist, err := store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+	assert.Equal(t, times, storeTimes(store.MemoryStore))
+	assert.Equal(t, versions, storeVersions(store.MemoryStore))
+	actualinfo, err := store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, listsinfo, actualinfo)
+
+	// Conflicts are still detected against replayed data.
+	_, err = store.AddList(newlist)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// Updates and deletions are replayed too.
+	name := "House"
+	_, err = store.UpdateList(newlist.ID, TodoListPatch{Name: &name})
+	assert.Nil(t, err)
+	name = "wash the van"
+	_, err = store.UpdateTask(newlist.ID, newlist.Tasks[1].ID, TaskPatch{Name: &name})
+	assert.Nil(t, err)
+	_, err = store.MoveTask(newlist.ID, newlist.Tasks[1].ID, TaskMove{Before: newlist.Tasks[0].ID})
+	assert.Nil(t, err)
+	err = store.DeleteTask(newlist.ID, newlist.Tasks[0].ID)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist.Name = "House"
+	newlist.Tasks[1].Name = "wash the van"
+	newlist.Tasks = newlist.Tasks[1:]
+	actuallist, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// The move was replayed: a new task goes after the moved one, which is
+	// now the last.
+	newtask, err := store.AddTask(newlist.ID, Task{ID: "", Name: "paint the fence", Completed: false})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(newlist.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, unversioned([]Task{newlist.Tasks[0], newtask}), unversioned(actuallist.Tasks))
+
+	// So are moves to another list.
+	worklist, err := store.AddList(TodoList{Name: "Work"})
+	assert.Nil(t, err)
+	_, err = store.MoveTask(newlist.ID, newtask.ID, TaskMove{List: worklist.ID})
+	assert.Nil(t, err)
+
+	// And dates, which come back in the task's time zone, priorities, tags,
+	// subtasks and dependencies.
+	due := time.Date(2026, 11, 6, 12, 0, 0, 0, time.UTC)
+	flights, err := store.AddTask(worklist.ID, Task{Name: "book the flights", Due: &due, TimeZone: "Europe/Oslo", Priority: PriorityHigh, Tags: []string{"travel"}})
+	assert.Nil(t, err)
+	passport, err := store.AddTask(worklist.ID, Task{Name: "renew the passport", Parent: flights.ID, BlockedBy: []TaskRef{{newlist.ID, newlist.Tasks[0].ID}}})
+	assert.Nil(t, err)
+
+	// And comments, edited and deleted.
+	window, err := store.AddComment(worklist.ID, flights.ID, Comment{Author: "ada", Text: "a window seat"})
+	assert.Nil(t, err)
+	aisle, err := store.AddComment(worklist.ID, flights.ID, Comment{Author: "bob", Text: "no, the aisle"})
+	assert.Nil(t, err)
+	text := "a window seat, please"
+	window, err = store.UpdateComment(worklist.ID, flights.ID, window.ID, CommentPatch{Text: &text})
+	assert.Nil(t, err)
+	err = store.DeleteComment(worklist.ID, flights.ID, aisle.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	actuallist, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+	worklist.Tasks = []Task{flights, newtask, passport}
+	actuallist, err = store.GetList(worklist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, unversioned(worklist), unversioned(actuallist))
+	tags, err := store.GetTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []TagCount{{"travel", 0, 1}}, tags)
+	comments, err := store.GetComments(worklist.ID, flights.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{window}, comments)
+
+	err = store.DeleteList(newlist.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	_, err = store.GetList(newlist.ID, ListOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
+	passport.BlockedBy = nil
+	worklist.Tasks = []Task{flights, newtask, passport}
+	actuallist, err = store.GetList(worklist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, unversioned(worklist), unversioned(actuallist))
+
+	// The next occurrence of a recurring task comes back just as it was
+	// made.
+	timesheet, err := store.AddTask(worklist.ID, Task{Name: "submit the timesheet", Due: &due, TimeZone: "Europe/Oslo", Recurrence: "FREQ=MONTHLY;BYDAY=-1FR"})
+	assert.Nil(t, err)
+	err = store.SetCompleted(worklist.ID, timesheet.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	worklist, err = store.GetList(worklist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(worklist.Tasks))
+	assert.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	actuallist, err = store.GetList(worklist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, worklist, actuallist)
+	assert.Nil(t, store.Close())
+
+	// A closed store refuses further changes.
+	_, err = store.AddTask(newlist.ID, Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "paint the fence", Completed: false})
+	assert.NotNil(t, err)
+}
+
+func TestFileStoreBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	// Populate a new store, and make some changes in a batch.
+	store, err := OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist := populate(t, store)
+	name := "House"
+	results, err := store.Batch([]Operation{
+		{Op: "addList", List: TodoList{Name: "Work", Tasks: []Task{{Name: "book the flights"}}}},
+		{Op: "moveTask", ID: newlist.ID, TaskID: newlist.Tasks[0].ID, Move: TaskMove{After: newlist.Tasks[1].ID}},
+		{Op: "updateList", ID: newlist.ID, ListPatch: TodoListPatch{Name: &name}},
+		{Op: "deleteTask", ID: newlist.ID, TaskID: newlist.Tasks[1].ID},
+	})
+	require.Nil(t, err)
+	worklist := *results[0].List
+
+	// A failed batch leaves nothing behind to replay.
+	_, err = store.Batch([]Operation{
+		{Op: "deleteList", ID: newlist.ID},
+		{Op: "deleteList", ID: newlist.ID},
+	})
+	assert.ErrorIs(t, err, ErrNotFound)
+	times := storeTimes(store.MemoryStore)
+	versions := storeVersions(store.MemoryStore)
+	assert.Nil(t, store.Close())
+
+	// Reopen it; the batch is replayed as it was made.
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist.Name = "House"
+	newlist.Tasks = newlist.Tasks[:1]
+	actuallist, err := store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+	assert.Equal(t, uint64(4), actuallist.Version)
+	actuallist, err = store.GetList(worklist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, worklist, actuallist)
+	assert.Equal(t, times, storeTimes(store.MemoryStore))
+	assert.Equal(t, versions, storeVersions(store.MemoryStore))
+
+	// The next change follows on from the batch.
+	_, err = store.AddTask(newlist.ID, Task{Name: "paint the fence"})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), actuallist.Version)
+	assert.Nil(t, store.Close())
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+
+	// Populate a store which snapshots after every other record.
+	store, err := OpenFileStore(dir, 2)
+	require.Nil(t, err)
+	newlist := populate(t, store)
+
+	// The snapshot absorbed the first two records; the log holds the third.
+	_, err = os.Stat(filepath.Join(dir, snapshotName))
+	assert.Nil(t, err)
+	info, err := os.Stat(filepath.Join(dir, logName))
+	require.Nil(t, err)
+	assert.NotZero(t, info.Size())
+
+	// An explicit snapshot empties the log, and takes the comments with it.
+	newcomment, err := store.AddComment(newlist.ID, newlist.Tasks[1].ID, Comment{Author: "ada", Text: "use the wax"})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Snapshot())
+	info, err = os.Stat(filepath.Join(dir, logName))
+	require.Nil(t, err)
+	assert.Zero(t, info.Size())
+	times := storeTimes(store.MemoryStore)
+	versions := storeVersions(store.MemoryStore)
+	listsinfo, err := store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	// Reopen it; everything is still there, down to the times and versions.
+	store, err = OpenFileStore(dir, 2)
+	require.Nil(t, err)
+	actuallist, err := store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+	assert.Equal(t, times, storeTimes(store.MemoryStore))
+	assert.Equal(t, versions, storeVersions(store.MemoryStore))
+	actualinfo, err := store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, listsinfo, actualinfo)
+	comments, err := store.GetComments(newlist.ID, newlist.Tasks[1].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{newcomment}, comments)
+	assert.Nil(t, store.Close())
+}
+
+func TestFileStoreStaleLog(t *testing.T) {
+	dir := t.TempDir()
+
+	// Populate a store and keep a copy of its log.
+	store, err := OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist := populate(t, store)
+	stale, err := os.ReadFile(filepath.Join(dir, logName))
+	require.Nil(t, err)
+
+	// Change the list, snapshot, then put the old log back as if we crashed
+	// before truncating it.  Replaying the old records on top of the snapshot
+	// would recreate the list as it was at first.
+	err = store.SetCompleted(newlist.ID, newlist.Tasks[0].ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	newlist.Tasks[0].Completed = true
+	assert.Nil(t, store.Snapshot())
+	assert.Nil(t, store.Close())
+	require.Nil(t, os.WriteFile(filepath.Join(dir, logName), stale, 0644))
+
+	// Reopen it; the old records are skipped.
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	actuallist, err := store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// New records follow the old ones and are replayed in turn.
+	err = store.SetCompleted(newlist.ID, newlist.Tasks[1].ID, CompletedTask{Completed: false})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist.Tasks[1].Completed = false
+	actuallist, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+	assert.Nil(t, store.Close())
+}
+
+func TestFileStoreTornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	// Populate a store.
+	store, err := OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist := populate(t, store)
+	assert.Nil(t, store.Close())
+
+	// Chop the last record in half.
+	path := filepath.Join(dir, logName)
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Nil(t, os.Truncate(path, info.Size()-10))
+
+	// Reopen it; the torn completion is lost, the rest survives.
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist.Tasks[1].Completed = false
+	actuallist, err := store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// The torn bytes were cut off, so new records replay cleanly.
+	err = store.SetCompleted(newlist.ID, newlist.Tasks[0].ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	store, err = OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	newlist.Tasks[0].Completed = true
+	actuallist, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+	assert.Nil(t, store.Close())
+}
+
+func TestFileStoreCorruption(t *testing.T) {
+	dir := t.TempDir()
+
+	// Populate a store.
+	store, err := OpenFileStore(dir, 0)
+	require.Nil(t, err)
+	populate(t, store)
+	assert.Nil(t, store.Close())
+
+	// Damage the first record, which is followed by others.
+	path := filepath.Join(dir, logName)
+	data, err := os.ReadFile(path)
+	require.Nil(t, err)
+	data[frameHeader+1] ^= 0xff
+	require.Nil(t, os.WriteFile(path, data, 0644))
+
+	// Opening the store fails rather than silently losing data.
+	_, err = OpenFileStore(dir, 0)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
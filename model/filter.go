@@ -0,0 +1,94 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// TaskFilter selects tasks.  The zero value selects every task; each field
+// set narrows the selection further.  Tasks without a due time never match a
+// filter on due times.
+type TaskFilter struct {
+	Completed  *bool     // Only tasks in this state
+	DueBefore  time.Time // Only tasks due strictly before this
+	DueAfter   time.Time // Only tasks due at or after this
+	Priorities []string  // Only tasks with one of these priorities
+	Tags       []string  // Only tasks carrying all these tags, or in a list that does
+	Name       string    // Only tasks with names containing this, without regard to case
+
+	terms []queryTerm // Only tasks matching these terms of a search query
+}
+
+// validate checks the filter, putting its tags in canonical form.
+func (f *TaskFilter) validate() error {
+	for _, priority := range f.Priorities {
+		if _, err := parsePriority("priority", priority); err != nil {
+			return err
+		}
+	}
+	tags, err := normalizeTags("tag", f.Tags)
+	if err != nil {
+		return err
+	}
+	f.Tags = tags
+	f.Name = strings.ToLower(f.Name)
+	return nil
+}
+
+// onlyTags reports whether the filter looks at nothing but tags.
+func (f TaskFilter) onlyTags() bool {
+	return f.Completed == nil && f.DueBefore.IsZero() && f.DueAfter.IsZero() && len(f.Priorities) == 0 && f.Name == "" && len(f.terms) == 0
+}
+
+// selects reports whether the filter selects a list as a search result: if it
+// selects any of its tasks, or if it only asks for tags which the list has.
+// The latter means a search for a tag finds lists carrying it even if they're
+// empty.
+func (f TaskFilter) selects(l list) bool {
+	if f.onlyTags() && hasTags(f.Tags, l.tags, nil) {
+		return true
+	}
+	for _, task := range l.tasks {
+		if f.match(task, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the filter selects a task in the given list.  The
+// filter must have been validated.
+func (f TaskFilter) match(t task, l list) bool {
+	if f.Completed != nil && t.completed != *f.Completed {
+		return false
+	}
+	if !f.DueBefore.IsZero() && (t.due.IsZero() || !t.due.Before(f.DueBefore)) {
+		return false
+	}
+	if !f.DueAfter.IsZero() && (t.due.IsZero() || t.due.Before(f.DueAfter)) {
+		return false
+	}
+	if len(f.Priorities) > 0 {
+		found := false
+		for _, priority := range f.Priorities {
+			if rank, _ := parsePriority("priority", priority); rank == t.priority {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !hasTags(f.Tags, t.tags, l.tags) {
+		return false
+	}
+	if f.Name != "" && !strings.Contains(strings.ToLower(t.name), f.Name) {
+		return false
+	}
+	for _, term := range f.terms {
+		if term.matchTask(t, l) == term.negate {
+			return false
+		}
+	}
+	return true
+}
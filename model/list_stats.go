@@ -0,0 +1,23 @@
+/*
+ * Simple ToDo API
+ *
+ * This is a simple API for managing a TODO List
+ *
+ * API version: 1.0.0
+ * Contact: recruiting@dfsco.com
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package model
+
+import (
+	"time"
+)
+
+type ListStats struct {
+	Total        int        `json:"total"`
+	Completed    int        `json:"completed"`
+	PercentDone  int        `json:"percentDone"`
+	Overdue      int        `json:"overdue"`
+	LastActivity *time.Time `json:"lastActivity,omitempty"`
+}
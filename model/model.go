@@ -2,10 +2,9 @@ package model
 
 import (
 	"bytes"
-	"net/http"
-	"regexp"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -20,14 +19,28 @@ import (
 // data structures and/or change the API; note the sorting below when
 // retrieving sets of lists and tasks.  For tasks, in particular, do duplicate
 // task names in the same list make sense?  Would it be better to store the
-// tasks in a tree sorted by name and forget the unique ID?  And shouldn't the
-// unique IDs be provided by the backend, not the frontend, rather than
-// forcing clients to generate their own IDs?  A longer conversation
+// tasks in a tree sorted by name and forget the unique ID?  (The unique IDs
+// are generated by the backend unless the client supplies its own, which it
+// may want to do for items created while offline.)  A longer conversation
 // could/should be had here.
 
 type task struct {
-	name      string
-	completed bool
+	name       string
+	completed  bool
+	position   string    // Key for ordering the tasks by hand; see position.go
+	start      time.Time // Zero if none; see dates.go
+	due        time.Time // Zero if none
+	timeZone   string    // IANA name, or empty to keep the offsets given
+	priority   int       // Rank; see priority.go
+	recurrence string    // Canonical RRULE, or empty; see recurrence.go
+	anchor     time.Time // Due time the recurrence is anchored at
+	tags       []string  // Canonical form; see tags.go
+	parent     uuid.UUID // Nil if none; see subtasks.go
+	blockers   []taskKey // Sorted; see dependencies.go
+	comments   []comment // In the order added; see comments.go
+	created    time.Time // Zero if unknown; see sort.go
+	updated    time.Time
+	version    uint64 // See version.go
 }
 
 type taskmap map[uuid.UUID]task
@@ -35,242 +48,1018 @@ type taskmap map[uuid.UUID]task
 type list struct {
 	name        string
 	description string
+	tags        []string
 	tasks       taskmap
+	created     time.Time // Zero if unknown; see sort.go
+	updated     time.Time
+	version     uint64    // See version.go
+	changed     time.Time // When the version was set
 }
 
 type listmap map[uuid.UUID]list
 
-// The internal database is a map of UUIDs to lists protected by a reader/
-// writer lock.  It is safe for multiple readers to access a Go map at once,
-// but we need to stop reading before anyone can write.
-var lists = make(listmap)
-var lock = sync.RWMutex{}
+// MemoryStore is a Store kept entirely in memory.  The internal database is a
+// map of UUIDs to lists protected by a reader/writer lock.  It is safe for
+// multiple readers to access a Go map at once, but we need to stop reading
+// before anyone can write.
+type MemoryStore struct {
+	lock         sync.RWMutex
+	lists        listmap
+	tags         tagIndex
+	dependencies dependencyIndex
+	search       *searchIndex
+	dues         *dueIndex
+	now          func() time.Time // Clock for timestamps
+	seq          uint64           // Sequence number of the last record applied
+	changed      time.Time        // Time of the last record applied
+	journal      journal          // Persists records before they are applied; may be nil
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{lists: make(listmap), tags: make(tagIndex), dependencies: make(dependencyIndex), search: newSearchIndex(), dues: newDueIndex(), now: time.Now}
+}
 
-// These functions all return HTTP status codes; this should ideally be
-// be changed to not rely on the HTTP protocol definitions and the API should
-// convert to HTTP codes, but I have left this out for this sample service.
+// Internal ID helper.  Parses a client-supplied ID, or generates a new one if
+// the client left it out.  Generated IDs are time-ordered, so that items
+// created around the same time sit near each other in any index.
+func newID(id string) (uuid.UUID, error) {
+	if id == "" {
+		return uuid.NewV7()
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return parsed, invalidID(id)
+	}
+	return parsed, nil
+}
 
-// Internal task adding helper.  Doesn't lock; the caller must lock before
+// Internal task validation helper.  Parses or generates the task ID and
+// checks for a conflict with the existing tasks of the list.  The new task
+// goes after the given position.  Its parent and blockers aren't checked,
+// since they may be yet to come.  Doesn't lock; the caller must lock before
 // obtaining the taskmap if necessary.
-func addTaskHelper(tasks taskmap, model Task) int {
+func newTaskHelper(listid uuid.UUID, tasks taskmap, model Task, after string) (taskRecord, error) {
 	// Parse the task ID.
-	taskid, err := uuid.Parse(model.ID)
+	taskid, err := newID(model.ID)
 	if err != nil {
-		return http.StatusBadRequest
+		return taskRecord{}, err
 	}
 
 	// Check for a conflict.
 	if _, ok := tasks[taskid]; ok {
-		return http.StatusConflict
+		return taskRecord{}, taskConflict(taskid)
+	}
+
+	// Check its priority, tags and dates.
+	priority, err := parsePriority("priority", model.Priority)
+	if err != nil {
+		return taskRecord{}, err
+	}
+	tags, err := normalizeTags("tags", model.Tags)
+	if err != nil {
+		return taskRecord{}, err
+	}
+	parent, err := parseParent(model.Parent)
+	if err != nil {
+		return taskRecord{}, err
+	}
+	if len(model.Subtasks) > 0 {
+		return taskRecord{}, invalid("subtasks", "is read-only; give the subtasks a parent instead")
+	}
+	if len(model.Comments) > 0 {
+		return taskRecord{}, invalid("comments", "must be added through the comment endpoints")
+	}
+	blockers, err := parseBlockers(listid, model.BlockedBy)
+	if err != nil {
+		return taskRecord{}, err
+	}
+	t := task{
+		name:       model.Name,
+		completed:  model.Completed,
+		start:      timeValue(model.Start),
+		due:        timeValue(model.Due),
+		timeZone:   model.TimeZone,
+		priority:   priority,
+		recurrence: model.Recurrence,
+		tags:       tags,
+		parent:     parent,
+		blockers:   blockers,
+	}
+	if err := t.checkDates(); err != nil {
+		return taskRecord{}, err
+	}
+	if err := t.checkRecurrence(); err != nil {
+		return taskRecord{}, err
+	}
+
+	// Place it.
+	t.position, err = positionBetween(after, "")
+	if err != nil {
+		return taskRecord{}, err
 	}
 
-	// Add the task.
-	tasks[taskid] = task{model.Name, model.Completed}
-	return http.StatusCreated
+	return newTaskRecord(taskid, t), nil
+}
+
+// Internal helper to find the position of the last task in a list, or the
+// empty string if there are no tasks.
+func lastPosition(tasks taskmap) string {
+	last := ""
+	for _, task := range tasks {
+		if task.position > last {
+			last = task.position
+		}
+	}
+	return last
 }
 
 // AddList takes a model for a list and adds it to the internal data
-// structures.
-func AddList(model TodoList) int {
+// structures.  It returns the list as added, including any generated IDs.
+func (s *MemoryStore) AddList(model TodoList) (TodoList, error) {
+	return s.addList(model, nil)
+}
+
+// addList is AddList as part of a transaction.
+func (s *MemoryStore) addList(model TodoList, tx *txn) (TodoList, error) {
 	// Parse the list ID.
-	listid, err := uuid.Parse(model.ID)
+	listid, err := newID(model.ID)
 	if err != nil {
-		return http.StatusBadRequest
+		return TodoList{}, err
 	}
 
-	// Create a new list and add tasks to it.  Doesn't lock yet; we're not
+	// Build the record for the new list, checking its tasks as we go.  The
+	// tasks start out in the order given.  Doesn't lock yet; we're not
 	// modifying the database.
-	newlist := list{model.Name, model.Description, make(taskmap)}
+	r := record{Op: opAddList, List: listid, Name: model.Name, Description: model.Description}
+	r.Tags, err = normalizeTags("tags", model.Tags)
+	if err != nil {
+		return TodoList{}, err
+	}
+	newtasks := make(taskmap)
+	after := ""
 	for _, newtask := range model.Tasks {
-		status := addTaskHelper(newlist.tasks, newtask)
-		if status != http.StatusCreated {
-			return status
+		t, err := newTaskHelper(listid, newtasks, newtask, after)
+		if err != nil {
+			return TodoList{}, err
+		}
+		newtasks[t.ID] = t.task()
+		r.Tasks = append(r.Tasks, t)
+		after = t.Position
+	}
+	for taskid, task := range newtasks {
+		if err := checkParent(newtasks, taskid, task.parent); err != nil {
+			return TodoList{}, err
 		}
 	}
 
 	// Lock the database for writing.
-	lock.Lock()
-	defer lock.Unlock()
+	defer s.lockFor(tx)()
 
 	// Check for a conflict before committing.  We could check earlier as well
 	// if building the list was difficult, but to avoid a race we must check
 	// once we obtain the lock.
-	if _, ok := lists[listid]; ok {
-		return http.StatusConflict
+	if _, ok := s.lists[listid]; ok {
+		return TodoList{}, listConflict(listid)
+	}
+
+	// The tasks may be blocked by each other as well as by tasks already in
+	// the store.
+	lookup := func(key taskKey) (task, bool) {
+		if key.list == listid {
+			t, ok := newtasks[key.task]
+			return t, ok
+		}
+		return s.lookup(key)
+	}
+	for taskid, task := range newtasks {
+		if err := checkBlockers(lookup, taskKey{listid, taskid}, task.blockers); err != nil {
+			return TodoList{}, err
+		}
 	}
 
 	// Modify the actual database.
-	lists[listid] = newlist
-	return http.StatusCreated
+	if err := s.commit(tx, r); err != nil {
+		return TodoList{}, err
+	}
+	return s.listModel(listid, s.lists[listid], ListOptions{Sort: SortPosition}), nil
 }
 
 // AddTask takes a model for a task and adds it to the internal data
-// structures.
-func AddTask(id string, model Task) int {
+// structures.  It returns the task as added, including any generated ID.
+func (s *MemoryStore) AddTask(id string, model Task) (Task, error) {
+	return s.addTask(id, model, nil)
+}
+
+// addTask is AddTask as part of a transaction.
+func (s *MemoryStore) addTask(id string, model Task, tx *txn) (Task, error) {
 	// Parse the list ID.
 	listid, err := uuid.Parse(id)
 	if err != nil {
-		return http.StatusBadRequest
+		return Task{}, invalidID(id)
 	}
 
 	// Lock the database for writing.
-	lock.Lock()
-	defer lock.Unlock()
+	defer s.lockFor(tx)()
 
 	// Find the list to modify.
-	list, ok := lists[listid]
+	list, ok := s.lists[listid]
 	if !ok {
-		return http.StatusBadRequest
+		return Task{}, listNotFound(listid)
+	}
+	if err := tx.checkList(listid, list); err != nil {
+		return Task{}, err
+	}
+	t, err := newTaskHelper(listid, list.tasks, model, lastPosition(list.tasks))
+	if err != nil {
+		return Task{}, err
+	}
+	if err := checkParent(list.tasks, t.ID, parentValue(t.Parent)); err != nil {
+		return Task{}, err
+	}
+	if err := checkBlockers(s.lookup, taskKey{listid, t.ID}, t.task().blockers); err != nil {
+		return Task{}, err
 	}
 
 	// Modify the actual database.
-	return addTaskHelper(list.tasks, model)
+	if err := s.commit(tx, record{Op: opAddTask, List: listid, Tasks: []taskRecord{t}}); err != nil {
+		return Task{}, err
+	}
+	return s.taskModel(t.ID, list.tasks[t.ID]), nil
 }
 
 // SetCompleted takes a model for task completion and modifies the internal
-// data structures.
-func SetCompleted(id string, taskID string, model CompletedTask) int {
+// data structures.  A task can't be completed while any of its blockers is
+// open, unless forced; with a cascade, this applies to each of its
+// descendants too, though they may block each other.  Completing an open
+// recurring task adds its next occurrence just after it; see recurrence.go.
+func (s *MemoryStore) SetCompleted(id string, taskID string, model CompletedTask) error {
+	return s.setCompleted(id, taskID, model, nil)
+}
+
+// setCompleted is SetCompleted as part of a transaction.
+func (s *MemoryStore) setCompleted(id string, taskID string, model CompletedTask, tx *txn) error {
 	// Parse the IDs.
 	listid, err := uuid.Parse(id)
 	if err != nil {
-		return http.StatusBadRequest
+		return invalidID(id)
 	}
 	taskid, err := uuid.Parse(taskID)
 	if err != nil {
-		return http.StatusBadRequest
+		return invalidID(taskID)
 	}
 
 	// Lock the database for writing.
-	lock.Lock()
-	defer lock.Unlock()
+	defer s.lockFor(tx)()
 
 	// Find the task to modify.
-	list, ok := lists[listid]
+	list, ok := s.lists[listid]
 	if !ok {
-		return http.StatusBadRequest
+		return listNotFound(listid)
 	}
 	task, ok := list.tasks[taskid]
 	if !ok {
-		return http.StatusBadRequest
+		return taskNotFound(taskid)
+	}
+	if err := tx.checkTask(taskid, task); err != nil {
+		return err
+	}
+
+	// Check the blockers.
+	if model.Completed && !model.Force {
+		affected := []uuid.UUID{taskid}
+		if model.Cascade {
+			affected = subtree(list.tasks, taskid)
+		}
+		if err := s.checkCompletable(listid, list.tasks, affected); err != nil {
+			return err
+		}
+	}
+
+	// Work out the next occurrence of a recurring task.  Cascading doesn't
+	// advance the subtasks, which don't recur along with it.
+	r := record{Op: opSetCompleted, List: listid, TaskID: taskid, Completed: model.Completed, Cascade: model.Cascade}
+	if model.Completed && !task.completed {
+		r.Tasks, err = advance(list.tasks, task)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Modify the actual database.
-	task.completed = model.Completed
-	list.tasks[taskid] = task
-	return http.StatusCreated
+	return s.commit(tx, r)
 }
 
-// GetList returns a model for a list.
-func GetList(id string) (TodoList, int) {
-	response := TodoList{}
+// DeleteList removes a list and all of its tasks.
+func (s *MemoryStore) DeleteList(id string) error {
+	return s.deleteList(id, nil)
+}
 
+// deleteList is DeleteList as part of a transaction.
+func (s *MemoryStore) deleteList(id string, tx *txn) error {
 	// Parse the list ID.
 	listid, err := uuid.Parse(id)
 	if err != nil {
-		return response, http.StatusBadRequest
+		return invalidID(id)
 	}
-	response.ID = listid.String() // Use the canonical form
 
-	// Lock the database for reading.
-	lock.RLock()
-	defer lock.RUnlock()
+	// Lock the database for writing.
+	defer s.lockFor(tx)()
 
-	// Find the list.
-	list, ok := lists[listid]
+	// Find the list to remove.
+	list, ok := s.lists[listid]
 	if !ok {
-		return response, http.StatusNotFound
+		return listNotFound(listid)
+	}
+	if err := tx.checkList(listid, list); err != nil {
+		return err
 	}
 
-	// Produce the output model.
+	// Modify the actual database.
+	return s.commit(tx, record{Op: opDeleteList, List: listid})
+}
+
+// DeleteTask removes a task from a list.
+func (s *MemoryStore) DeleteTask(id string, taskID string) error {
+	return s.deleteTask(id, taskID, nil)
+}
+
+// deleteTask is DeleteTask as part of a transaction.
+func (s *MemoryStore) deleteTask(id string, taskID string, tx *txn) error {
+	// Parse the IDs.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return invalidID(id)
+	}
+	taskid, err := uuid.Parse(taskID)
+	if err != nil {
+		return invalidID(taskID)
+	}
+
+	// Lock the database for writing.
+	defer s.lockFor(tx)()
+
+	// Find the task to remove.
+	list, ok := s.lists[listid]
+	if !ok {
+		return listNotFound(listid)
+	}
+	task, ok := list.tasks[taskid]
+	if !ok {
+		return taskNotFound(taskid)
+	}
+	if err := tx.checkTask(taskid, task); err != nil {
+		return err
+	}
+
+	// Modify the actual database.
+	return s.commit(tx, record{Op: opDeleteTask, List: listid, TaskID: taskid})
+}
+
+// UpdateList applies a patch to a list's metadata and returns the updated
+// list.
+func (s *MemoryStore) UpdateList(id string, patch TodoListPatch) (TodoList, error) {
+	return s.updateList(id, patch, nil)
+}
+
+// updateList is UpdateList as part of a transaction.
+func (s *MemoryStore) updateList(id string, patch TodoListPatch, tx *txn) (TodoList, error) {
+	// Parse the list ID.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return TodoList{}, invalidID(id)
+	}
+
+	// Lock the database for writing.
+	defer s.lockFor(tx)()
+
+	// Find the list to modify.
+	list, ok := s.lists[listid]
+	if !ok {
+		return TodoList{}, listNotFound(listid)
+	}
+	if err := tx.checkList(listid, list); err != nil {
+		return TodoList{}, err
+	}
+
+	// Record the patched values; anything not in the patch stays as it is.
+	r := record{Op: opUpdateList, List: listid, Name: list.name, Description: list.description, Tags: list.tags}
+	if patch.Name != nil {
+		r.Name = *patch.Name
+	}
+	if patch.Description != nil {
+		r.Description = *patch.Description
+	}
+	if patch.Tags != nil {
+		r.Tags, err = normalizeTags("tags", *patch.Tags)
+		if err != nil {
+			return TodoList{}, err
+		}
+	}
+
+	// Modify the actual database.
+	if err := s.commit(tx, r); err != nil {
+		return TodoList{}, err
+	}
+	return s.listModel(listid, s.lists[listid], ListOptions{}), nil
+}
+
+// UpdateTask applies a patch to a task and returns the updated task.
+func (s *MemoryStore) UpdateTask(id string, taskID string, patch TaskPatch) (Task, error) {
+	return s.updateTask(id, taskID, patch, nil)
+}
+
+// updateTask is UpdateTask as part of a transaction.
+func (s *MemoryStore) updateTask(id string, taskID string, patch TaskPatch, tx *txn) (Task, error) {
+	// Parse the IDs.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return Task{}, invalidID(id)
+	}
+	taskid, err := uuid.Parse(taskID)
+	if err != nil {
+		return Task{}, invalidID(taskID)
+	}
+
+	// Lock the database for writing.
+	defer s.lockFor(tx)()
+
+	// Find the task to modify.
+	list, ok := s.lists[listid]
+	if !ok {
+		return Task{}, listNotFound(listid)
+	}
+	task, ok := list.tasks[taskid]
+	if !ok {
+		return Task{}, taskNotFound(taskid)
+	}
+	if err := tx.checkTask(taskid, task); err != nil {
+		return Task{}, err
+	}
+
+	// Record the patched task; anything not in the patch stays as it is.
+	if patch.Name != nil {
+		task.name = *patch.Name
+	}
+	if patch.Completed != nil {
+		// Only SetCompleted may force a blocked task to completion.
+		if *patch.Completed && !task.completed {
+			if err := s.checkCompletable(listid, list.tasks, []uuid.UUID{taskid}); err != nil {
+				return Task{}, err
+			}
+		}
+		task.completed = *patch.Completed
+	}
+	if patch.Start != nil {
+		task.start = *patch.Start
+	}
+	if patch.Due != nil {
+		task.due = *patch.Due
+	}
+	if patch.TimeZone != nil {
+		task.timeZone = *patch.TimeZone
+	}
+	if patch.Priority != nil {
+		task.priority, err = parsePriority("priority", *patch.Priority)
+		if err != nil {
+			return Task{}, err
+		}
+	}
+	if patch.Recurrence != nil {
+		task.recurrence = *patch.Recurrence
+	}
+	if patch.Due != nil || patch.TimeZone != nil || patch.Recurrence != nil {
+		task.anchor = time.Time{}
+	}
+	if patch.Tags != nil {
+		task.tags, err = normalizeTags("tags", *patch.Tags)
+		if err != nil {
+			return Task{}, err
+		}
+	}
+	if patch.Parent != nil {
+		task.parent, err = parseParent(*patch.Parent)
+		if err != nil {
+			return Task{}, err
+		}
+		if err := checkParent(list.tasks, taskid, task.parent); err != nil {
+			return Task{}, err
+		}
+	}
+	if patch.BlockedBy != nil {
+		task.blockers, err = parseBlockers(listid, *patch.BlockedBy)
+		if err != nil {
+			return Task{}, err
+		}
+		if err := checkBlockers(s.lookup, taskKey{listid, taskid}, task.blockers); err != nil {
+			return Task{}, err
+		}
+	}
+	if err := task.checkDates(); err != nil {
+		return Task{}, err
+	}
+	if err := task.checkRecurrence(); err != nil {
+		return Task{}, err
+	}
+
+	// Completing a recurring task adds its next occurrence, as in
+	// SetCompleted, and takes the completed one off the series.
+	var next []taskRecord
+	if task.completed && !list.tasks[taskid].completed {
+		next, err = advance(list.tasks, task)
+		if err != nil {
+			return Task{}, err
+		}
+		if next != nil {
+			task.recurrence = ""
+			task.anchor = time.Time{}
+		}
+	}
+
+	// Modify the actual database.
+	if err := s.commit(tx, record{Op: opUpdateTask, List: listid, Tasks: append([]taskRecord{newTaskRecord(taskid, task)}, next...)}); err != nil {
+		return Task{}, err
+	}
+	return s.taskModel(taskid, list.tasks[taskid]), nil
+}
+
+// MoveTask moves a task immediately before or after another task, and
+// returns the moved task.  If a destination list is given, the task moves
+// there with its subtasks, keeping their IDs and state; without a neighbour
+// it goes to the end.  It leaves its parent behind.
+func (s *MemoryStore) MoveTask(id string, taskID string, model TaskMove) (Task, error) {
+	return s.moveTask(id, taskID, model, nil)
+}
+
+// moveTask is MoveTask as part of a transaction.
+func (s *MemoryStore) moveTask(id string, taskID string, model TaskMove, tx *txn) (Task, error) {
+	// Parse the IDs.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return Task{}, invalidID(id)
+	}
+	taskid, err := uuid.Parse(taskID)
+	if err != nil {
+		return Task{}, invalidID(taskID)
+	}
+	destid := listid
+	if model.List != "" {
+		destid, err = uuid.Parse(model.List)
+		if err != nil {
+			return Task{}, invalid("list", "not a valid ID")
+		}
+	}
+
+	// At most one of the neighbours may be given.  Within a list, one must
+	// be, or the move would do nothing.
+	if model.Before != "" && model.After != "" {
+		return Task{}, invalid("before", "only one of before and after may be given")
+	}
+	if model.Before == "" && model.After == "" && destid == listid {
+		return Task{}, invalid("before", "one of before and after must be given")
+	}
+	field, neighbour := "before", model.Before
+	if model.After != "" {
+		field, neighbour = "after", model.After
+	}
+	neighbourid := uuid.Nil
+	if neighbour != "" {
+		neighbourid, err = uuid.Parse(neighbour)
+		if err != nil {
+			return Task{}, invalid(field, "not a valid ID")
+		}
+		if neighbourid == taskid {
+			return Task{}, invalid(field, "must not be the task being moved")
+		}
+	}
+
+	// Lock the database for writing.  Everything from here on, including
+	// the removal from the old list, happens as one record under the lock, so
+	// concurrent changes to the task can't be lost.
+	defer s.lockFor(tx)()
+
+	// Find the task to move and the list it's going to.
+	list, ok := s.lists[listid]
+	if !ok {
+		return Task{}, listNotFound(listid)
+	}
+	task, ok := list.tasks[taskid]
+	if !ok {
+		return Task{}, taskNotFound(taskid)
+	}
+	if err := tx.checkTask(taskid, task); err != nil {
+		return Task{}, err
+	}
+	dest, ok := s.lists[destid]
+	if !ok {
+		return Task{}, listNotFound(destid)
+	}
+	if destid != listid {
+		// The task's subtree goes with it.
+		for _, movedid := range subtree(list.tasks, taskid) {
+			if _, ok := dest.tasks[movedid]; ok {
+				return Task{}, taskConflict(movedid)
+			}
+		}
+	}
+
+	// Place the task.
+	position := ""
+	if neighbour == "" {
+		position, err = positionBetween(lastPosition(dest.tasks), "")
+	} else {
+		target, ok := dest.tasks[neighbourid]
+		if !ok {
+			return Task{}, invalid(field, "no such task in this list")
+		}
+		position, err = positionNear(dest.tasks, taskid, target.position, field == "before")
+	}
+	if err != nil {
+		return Task{}, err
+	}
+
+	// Modify the actual database.
+	r := record{Op: opMoveTask, List: listid, TaskID: taskid, Position: position}
+	if destid != listid {
+		r.Destination = &destid
+	}
+	if err := s.commit(tx, r); err != nil {
+		return Task{}, err
+	}
+	return s.taskModel(taskid, s.lists[destid].tasks[taskid]), nil
+}
+
+// Internal helper to find a position immediately before or after the given
+// one, ignoring the task being moved.
+func positionNear(tasks taskmap, taskid uuid.UUID, target string, before bool) (string, error) {
+	// Find the keys on either side of the gap we're moving into.
+	lower, upper := "", ""
+	if before {
+		upper = target
+		for otherid, other := range tasks {
+			if otherid != taskid && other.position < upper && other.position > lower {
+				lower = other.position
+			}
+		}
+	} else {
+		lower = target
+		for otherid, other := range tasks {
+			if otherid != taskid && other.position > lower && (upper == "" || other.position < upper) {
+				upper = other.position
+			}
+		}
+	}
+	return positionBetween(lower, upper)
+}
+
+// Produces the output model for a task.
+func (s *MemoryStore) taskModel(taskid uuid.UUID, task task) Task {
+	return Task{
+		ID:         taskid.String(),
+		Name:       task.name,
+		Completed:  task.completed,
+		Start:      timePointer(task.start),
+		Due:        timePointer(task.due),
+		TimeZone:   task.timeZone,
+		Priority:   priorityName(task.priority),
+		Recurrence: task.recurrence,
+		Tags:       copyTags(task.tags),
+		Parent:     parentString(task.parent),
+		BlockedBy:  blockerRefs(task.blockers),
+		Blocked:    s.blocked(task),
+		Version:    task.version,
+	}
+}
+
+// Produces the output model for a list, with its tasks filtered and sorted as
+// given.
+func (s *MemoryStore) listModel(listid uuid.UUID, list list, options ListOptions) TodoList {
+	response, _, _ := s.listPage(listid, list, options, nil, 0)
+	return response
+}
+
+// taskResult is a task selected for the output model.
+type taskResult struct {
+	id   uuid.UUID
+	task task
+}
+
+// listPage produces the output model for a list with a page of its tasks,
+// those after a starting point, if given, and up to a limit, if not zero.  It
+// also returns the last task of the page if there are more, and the number of
+// tasks the filter selected.  Pages must be flat.
+func (s *MemoryStore) listPage(listid uuid.UUID, list list, options ListOptions, after *taskResult, limit int) (TodoList, *taskResult, int) {
+	response := TodoList{}
+	response.ID = listid.String() // Use the canonical form
 	response.Name = list.name
 	response.Description = list.description
-	response.Tasks = make([]Task, 0, len(list.tasks))
+	response.Tags = copyTags(list.tags)
+	response.Version = list.version
+	if options.Stats {
+		response.Stats = s.listStats(list)
+	}
+	if options.Summary {
+		return response, nil, 0
+	}
+
+	// Produce the output model for a task, with its comments if asked.
+	present := func(taskid uuid.UUID, task task) Task {
+		model := s.taskModel(taskid, task)
+		if options.Comments {
+			model.Comments = commentModels(task.comments)
+		}
+		return model
+	}
+
+	// The order was checked with the options, if it came from the user.
+	order, _ := parseSort("sort", options.Sort, taskSortKeys, defaultTaskSort)
+
+	results := make([]taskResult, 0, len(list.tasks))
+	included := make(map[uuid.UUID]bool) // Only kept for a tree
+	filtered := 0
 	for taskid, task := range list.tasks {
-		response.Tasks = append(response.Tasks, Task{taskid.String(), task.name, task.completed})
+		if !options.Filter.match(task, list) {
+			continue
+		}
+		filtered++
+		if after == nil || compareTasks(order, after.id, after.task, taskid, task) < 0 {
+			results = append(results, taskResult{taskid, task})
+			if options.Tree {
+				included[taskid] = true
+			}
+		}
 	}
 
-	// Sort the tasks by name.  We could provide some different sort options
-	// in the API, but for now, this seems like the most reasonable default.  We
-	// could provide them unsorted, but this is bad for testing and probably not
-	// what users will expect, either.
-	sort.Slice(response.Tasks, func(i, j int) bool {
-		if response.Tasks[i].Name < response.Tasks[j].Name {
-			return true
+	// A tree needs the ancestors of the tasks we found, for them to hang
+	// from.
+	if options.Tree {
+		for _, result := range results {
+			for parentid := result.task.parent; parentid != uuid.Nil && !included[parentid]; {
+				parent := list.tasks[parentid]
+				results = append(results, taskResult{parentid, parent})
+				included[parentid] = true
+				parentid = parent.parent
+			}
 		}
+	}
 
-		// Break ties by ID to provide a stable sort.
-		if response.Tasks[i].Name == response.Tasks[j].Name && response.Tasks[i].ID < response.Tasks[j].ID {
-			return true
+	// Sort the tasks; see sort.go.  We could provide them unsorted, but this
+	// is bad for testing and probably not what users will expect, either.
+	// For a page, only the tasks on it, and the one after to tell if there
+	// are more, need sorting, which matters for long lists.
+	var next *taskResult
+	if limit > 0 {
+		results = firstTasks(results, limit+1, order)
+		if len(results) > limit {
+			results = results[:limit]
+			next = &results[limit-1]
 		}
+	} else {
+		sort.Slice(results, func(i, j int) bool {
+			a, b := results[i], results[j]
+			return compareTasks(order, a.id, a.task, b.id, b.task) < 0
+		})
+	}
 
-		return false
-	})
+	if !options.Tree {
+		response.Tasks = make([]Task, 0, len(results))
+		for _, result := range results {
+			response.Tasks = append(response.Tasks, present(result.id, result.task))
+		}
+		return response, next, filtered
+	}
 
-	return response, http.StatusOK
+	// Build the tree, keeping the order of the sort at each level.
+	kids := make(map[uuid.UUID][]uuid.UUID)
+	models := make(map[uuid.UUID]Task, len(results))
+	roots := []uuid.UUID{}
+	for _, result := range results {
+		models[result.id] = present(result.id, result.task)
+		if result.task.parent == uuid.Nil {
+			roots = append(roots, result.id)
+		} else {
+			kids[result.task.parent] = append(kids[result.task.parent], result.id)
+		}
+	}
+	var build func(ids []uuid.UUID) []Task
+	build = func(ids []uuid.UUID) []Task {
+		tasks := make([]Task, 0, len(ids))
+		for _, taskid := range ids {
+			model := models[taskid]
+			if len(kids[taskid]) > 0 {
+				model.Subtasks = build(kids[taskid])
+			}
+			tasks = append(tasks, model)
+		}
+		return tasks
+	}
+	response.Tasks = build(roots)
+	return response, next, filtered
 }
 
-// GetLists returns a model for a range of lists, potentially limited by a
-// search term and/or using pagination.  A limit of zero is treated as no
-// limit.
-func GetLists(searchString string, skip int, limit int) ([]TodoList, int) {
-	response := []TodoList{}
+// ListOptions control how GetList presents a list.
+type ListOptions struct {
+	// Sort is the order of the tasks, as a series of the keys for tasks
+	// separated by commas; see sort.go.  If empty, they are sorted by name.
+	Sort string
+
+	// ListSort is the order of the lists in the same form, with the keys for
+	// lists.  If empty, they are sorted by relevance and then name.  It only
+	// applies to GetLists.
+	ListSort string
+
+	// Filter selects the tasks to include.
+	Filter TaskFilter
+
+	// Tree nests subtasks under their parents, rather than listing every
+	// task at the top level.  Parents of the tasks selected are included.
+	Tree bool
+
+	// Comments includes each task's comments.
+	Comments bool
+
+	// Matches reports which fields of each list matched the search string.
+	// It only applies to GetLists.
+	Matches bool
+
+	// Stats includes a summary of each list's progress; see stats.go.
+	Stats bool
 
-	// Check the pagination parameters.
-	if skip < 0 || limit < 0 {
-		return response, http.StatusBadRequest
+	// Summary leaves out the tasks, giving only the lists themselves.  The
+	// filter still selects the lists.  It only applies to GetLists.
+	Summary bool
+}
+
+func (options *ListOptions) validate(field string) error {
+	if _, err := parseSort(field, options.Sort, taskSortKeys, defaultTaskSort); err != nil {
+		return err
+	}
+	if _, err := parseSort("sort", options.ListSort, listSortKeys, defaultListSort); err != nil {
+		return err
 	}
+	return options.Filter.validate()
+}
+
+// GetList returns a model for a list.
+func (s *MemoryStore) GetList(id string, options ListOptions) (TodoList, error) {
+	response := TodoList{}
 
-	// We'll perform a case-insensitive search across the list names.  This
-	// could be written to take into account descriptions, tasks, etc.; a matter
-	// for further discussion, as well as whether words should be searched
-	// individually, how to treat special characters, etc.  For the present
-	// example, this will suffice.  Compile a regular expression to use.
-	re, err := regexp.Compile("(?i)" + regexp.QuoteMeta(searchString))
+	// Parse the list ID and check the options.
+	listid, err := uuid.Parse(id)
 	if err != nil {
-		return response, http.StatusBadRequest
+		return response, invalidID(id)
+	}
+	if err := options.validate("sort"); err != nil {
+		return response, err
 	}
 
-	// Lock the database.
-	lock.RLock()
-	defer lock.RUnlock()
+	// Lock the database for reading.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 
-	type searchresult struct {
-		name string
-		id   uuid.UUID
+	// Find the list.
+	list, ok := s.lists[listid]
+	if !ok {
+		return response, listNotFound(listid)
 	}
 
-	// Pagination is an interesting topic.  In most databases, using a limit and
-	// offset repeats the work of the search every time; after all, the 51st
-	// through 100th items might have changed since a previous query, so from a
-	// correctness perspective the work should be repeated.  This is potentially
-	// inefficient over a large search space, however, and is also susceptible
-	// to drift if new items are inserted/removed between requests.
-	// Nevertheless, we will follow this model here while pointing out that the
-	// API could be changed to avoid these traps (by providing a record we left
-	// off at previously, say)--which would require additional work on the
-	// backend, of course.
-	results := []searchresult{}
-	for listid, list := range lists {
-		if re.MatchString(list.name) {
-			results = append(results, searchresult{list.name, listid})
+	// Produce the output model.
+	return s.listModel(listid, list, options), nil
+}
+
+// TaskCursor marks a place in the tasks of a list: the sort key of the last
+// task on a page.  Only the fields in the sort order matter, with the name
+// and ID, which break ties.
+type TaskCursor struct {
+	Name      string
+	Position  string
+	Priority  string
+	Due       time.Time
+	Created   time.Time
+	Updated   time.Time
+	Completed bool
+	ID        string
+}
+
+// GetListPage is like GetList, but returns a page of the list's tasks, those
+// after a cursor, or from the start if it is nil, and up to a limit, with the
+// cursor for the next page, or nil if there are no more tasks.  A limit of
+// zero is treated as no limit.  The list's task counts are included: the
+// number of tasks in the list, and the number the filter selects across all
+// of the pages.
+func (s *MemoryStore) GetListPage(id string, after *TaskCursor, limit int, options ListOptions) (TodoList, *TaskCursor, error) {
+	response := TodoList{}
+
+	// Parse the list ID, and check the pagination parameters and options.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return response, nil, invalidID(id)
+	}
+	var start *taskResult
+	if after != nil {
+		taskid, err := uuid.Parse(after.ID)
+		if err != nil {
+			return response, nil, invalid("cursor", "must hold a task ID")
+		}
+		priority, err := parsePriority("cursor", after.Priority)
+		if err != nil {
+			return response, nil, invalid("cursor", "must hold a valid priority")
 		}
+		start = &taskResult{taskid, task{
+			name:      after.Name,
+			position:  after.Position,
+			priority:  priority,
+			due:       after.Due,
+			created:   after.Created,
+			updated:   after.Updated,
+			completed: after.Completed,
+		}}
+	}
+	if limit < 0 {
+		return response, nil, invalid("limit", "must not be negative")
+	}
+	if err := options.validate("sort"); err != nil {
+		return response, nil, err
+	}
+	if options.Tree && (start != nil || limit > 0) {
+		return response, nil, invalid("view", "must be flat to page through the tasks")
 	}
 
-	// Sort the results by name.  We could provide some different sort options
-	// in the API, but for now, this seems like the most reasonable default.  We
-	// could provide them unsorted, but this makes a mockery of pagination.
-	sort.Slice(results, func(i, j int) bool {
-		if results[i].name < results[j].name {
-			return true
-		}
+	// Lock the database for reading.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 
-		// Break ties by ID to provide a stable sort.
-		if results[i].name == results[j].name && bytes.Compare(results[i].id[:], results[j].id[:]) < 0 {
-			return true
+	// Find the list.
+	list, ok := s.lists[listid]
+	if !ok {
+		return response, nil, listNotFound(listid)
+	}
+
+	// Produce the output model, and the cursor for the next page if there is
+	// more.
+	response, last, filtered := s.listPage(listid, list, options, start, limit)
+	response.Counts = &TaskCounts{Total: len(list.tasks), Filtered: filtered}
+	var next *TaskCursor
+	if last != nil {
+		next = &TaskCursor{
+			Name:      last.task.name,
+			Position:  last.task.position,
+			Priority:  priorityName(last.task.priority),
+			Due:       last.task.due,
+			Created:   last.task.created,
+			Updated:   last.task.updated,
+			Completed: last.task.completed,
+			ID:        last.id.String(),
 		}
+	}
+	return response, next, nil
+}
 
-		return false
-	})
+// ListCursor marks a place in the results of GetLists: the sort key of the
+// last list on a page.  Only the fields in the sort order matter, with the
+// name and ID, which break ties.
+type ListCursor struct {
+	Score     int // How well the list matched the search string
+	Name      string
+	Created   time.Time
+	Updated   time.Time
+	Tasks     int // The number of tasks in the list
+	Completed int // The number of those completed
+	ID        string
+}
+
+// GetLists returns a model for a range of lists, potentially limited by a
+// search term and/or using pagination.  A limit of zero is treated as no
+// limit.  The options apply to each list's tasks; if they filter the tasks,
+// only lists with matching tasks are included.
+func (s *MemoryStore) GetLists(searchString string, skip int, limit int, options ListOptions) ([]TodoList, error) {
+	response := []TodoList{}
+
+	// Check the pagination parameters and options.
+	if skip < 0 {
+		return response, invalid("skip", "must not be negative")
+	}
+	if limit < 0 {
+		return response, invalid("limit", "must not be negative")
+	}
+	q, err := prepareSearch(searchString, &options)
+	if err != nil {
+		return response, err
+	}
+
+	// Lock the database.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// Pagination is an interesting topic.  In most databases, using a limit and
+	// offset repeats the work of the search every time; after all, the 51st
+	// through 100th items might have changed since a previous query, so from a
+	// correctness perspective the work should be repeated.  This is potentially
+	// inefficient over a large search space, however, and is also susceptible
+	// to drift if new items are inserted/removed between requests.  We follow
+	// this model here for the clients which expect it; GetListsAfter avoids
+	// the drift by starting from the last list seen instead.
+	results := s.findLists(q, options, nil)
 
 	// Slice the result page.  Go is picky and will get mad if we pass indices
 	// beyond the bounds of the result set, but we will simply choose to return
@@ -288,13 +1077,212 @@ func GetLists(searchString string, skip int, limit int) ([]TodoList, int) {
 	results = results[skip:limit]
 
 	// Produce the output model.
+	return s.listModels(results, options), nil
+}
+
+// GetListsAfter is like GetLists, but returns the lists which come after a
+// cursor, or from the start if it is nil, rather than skipping a number of
+// them.  Lists added or removed before the cursor don't shift the page.  It
+// also returns the cursor for the next page, or nil if there are no more
+// lists.
+func (s *MemoryStore) GetListsAfter(searchString string, after *ListCursor, limit int, options ListOptions) ([]TodoList, *ListCursor, error) {
+	response := []TodoList{}
+
+	// Check the pagination parameters and options.
+	var start *listResult
+	if after != nil {
+		listid, err := uuid.Parse(after.ID)
+		if err != nil {
+			return response, nil, invalid("cursor", "must hold a list ID")
+		}
+		start = &listResult{
+			id:        listid,
+			name:      after.Name,
+			score:     after.Score,
+			created:   after.Created,
+			updated:   after.Updated,
+			tasks:     after.Tasks,
+			completed: after.Completed,
+		}
+	}
+	if limit < 0 {
+		return response, nil, invalid("limit", "must not be negative")
+	}
+	q, err := prepareSearch(searchString, &options)
+	if err != nil {
+		return response, nil, err
+	}
+
+	// Lock the database.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// Find the page, and the cursor for the next one if there is more.
+	results := s.findLists(q, options, start)
+	var next *ListCursor
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+		last := results[limit-1]
+		next = &ListCursor{last.score, last.name, last.created, last.updated, last.tasks, last.completed, last.id.String()}
+	}
+
+	// Produce the output model.
+	return s.listModels(results, options), next, nil
+}
+
+// prepareSearch parses a search string and checks the options, adding the
+// query's task terms to the filter.
+func prepareSearch(searchString string, options *ListOptions) (query, error) {
+	q, err := parseQuery(searchString)
+	if err != nil {
+		return q, err
+	}
+	if len(q.tags) > 0 {
+		options.Filter.Tags = append(append([]string(nil), options.Filter.Tags...), q.tags...)
+	}
+	options.Filter.terms = q.taskTerms
+	return q, options.validate("taskSort")
+}
+
+// listResult is a list found by a search, with its sort key; see sort.go.
+type listResult struct {
+	id        uuid.UUID
+	hit       *searchHit // Nil if the search doesn't rank lists
+	score     int
+	name      string
+	created   time.Time
+	updated   time.Time
+	tasks     int // Only counted if the order needs it
+	completed int
+}
+
+// newListResult returns the result for a list found by a search.
+func newListResult(listid uuid.UUID, l list, hit *searchHit, counts bool) listResult {
+	r := listResult{id: listid, hit: hit, name: l.name, created: l.created, updated: l.updated}
+	if hit != nil {
+		r.score = hit.score
+	}
+	if counts {
+		r.tasks = len(l.tasks)
+		for _, t := range l.tasks {
+			if t.completed {
+				r.completed++
+			}
+		}
+	}
+	return r
+}
+
+// findLists returns the sorted results of a search, leaving out those which
+// don't come after a starting point, if given.  We could provide them
+// unsorted, but this makes a mockery of pagination.  The caller must hold the
+// lock, and must have checked the options.
+func (s *MemoryStore) findLists(q query, options ListOptions, after *listResult) []listResult {
+	order, _ := parseSort("sort", options.ListSort, listSortKeys, defaultListSort)
+	counts := needsCounts(order)
+	candidates := s.tags.candidates(options.Filter.Tags)
+	results := []listResult{}
+	consider := func(listid uuid.UUID, hit *searchHit) {
+		if candidates != nil && !candidates[listid] {
+			return
+		}
+		list := s.lists[listid]
+		result := newListResult(listid, list, hit, counts)
+		if after != nil && after.compare(order, result) >= 0 {
+			return
+		}
+		if !q.excludes(list) && options.Filter.selects(list) {
+			results = append(results, result)
+		}
+	}
+	if hits := s.searchLists(q); hits != nil {
+		// Only the lists found in the search index need to be looked at; see
+		// search.go.
+		for listid, hit := range hits {
+			consider(listid, hit)
+		}
+	} else {
+		for listid := range s.lists {
+			consider(listid, nil)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].compare(order, results[j]) < 0
+	})
+	return results
+}
+
+// listModels produces the output models for search results.  The caller must
+// hold the lock.
+func (s *MemoryStore) listModels(results []listResult, options ListOptions) []TodoList {
+	response := []TodoList{}
 	for _, result := range results {
-		list := lists[result.id]
-		tasks := []Task{}
+		model := s.listModel(result.id, s.lists[result.id], options)
+		if options.Matches && result.hit != nil {
+			model.Matches = result.hit.matches()
+		}
+		response = append(response, model)
+	}
+	return response
+}
+
+// GetTasks returns the tasks selected by a filter, across all lists.  They
+// are sorted by due time, with tasks that have none last, and then by name.
+func (s *MemoryStore) GetTasks(filter TaskFilter) ([]ListTask, error) {
+	response := []ListTask{}
+
+	// Check the filter.
+	if err := filter.validate(); err != nil {
+		return response, err
+	}
+
+	// Lock the database.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	type taskresult struct {
+		listid uuid.UUID
+		id     uuid.UUID
+		task   task
+	}
+
+	// Find the tasks.  This is a scan of every task in the store; fine for
+	// now, but an index on due times is the obvious next step if the
+	// overdue view gets popular.
+	candidates := s.tags.candidates(filter.Tags)
+	results := []taskresult{}
+	for listid, list := range s.lists {
+		if candidates != nil && !candidates[listid] {
+			continue
+		}
 		for taskid, task := range list.tasks {
-			tasks = append(tasks, Task{taskid.String(), task.name, task.completed})
+			if filter.match(task, list) {
+				results = append(results, taskresult{listid, taskid, task})
+			}
 		}
-		response = append(response, TodoList{result.id.String(), list.name, list.description, tasks})
 	}
-	return response, http.StatusOK
+
+	// Sort the results.
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if c := compareDue(a.task, b.task); c != 0 {
+			return c < 0
+		}
+		if a.task.name != b.task.name {
+			return a.task.name < b.task.name
+		}
+
+		// Break ties by ID to provide a stable sort.
+		if a.listid != b.listid {
+			return bytes.Compare(a.listid[:], b.listid[:]) < 0
+		}
+		return bytes.Compare(a.id[:], b.id[:]) < 0
+	})
+
+	// Produce the output model.
+	for _, result := range results {
+		response = append(response, ListTask{result.listid.String(), s.taskModel(result.id, result.task)})
+	}
+	return response, nil
 }
@@ -1,221 +1,1560 @@
 package model
 
 import (
-	"net/http"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// Clears the versions in a model, which count the changes made before it, so
+// that it compares equal to one written out by hand.  Slices are copied rather
+// than changed in place.
+func unversioned(model interface{}) interface{} {
+	v := reflect.New(reflect.TypeOf(model)).Elem()
+	v.Set(reflect.ValueOf(model))
+	clearVersions(v)
+	return v.Interface()
+}
+
+func clearVersions(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			switch {
+			case !field.IsExported():
+			case field.Name == "Version":
+				v.Field(i).SetUint(0)
+			default:
+				clearVersions(v.Field(i))
+			}
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		v.Set(c)
+		for i := 0; i < c.Len(); i++ {
+			clearVersions(c.Index(i))
+		}
+	}
+}
+
 func TestAddList(t *testing.T) {
+	store := NewMemoryStore()
+
 	// Dummy list.
 	newlist := TodoList{
-		"d290f1ee-6c54-4b01-90e6-d701748f0851",
-		"Home",
-		"The list of things that need to be done at home\n",
-		[]Task{Task{"0e2ac84f-f723-4f24-878b-44e63e7ae580", "mow the yard", true}},
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: true}},
 	}
 
 	// Add this list; succeeds.
-	status := AddList(newlist)
-	assert.Equal(t, http.StatusCreated, status)
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
 
 	// Check list values.
-	actuallist, status := GetList("d290f1ee-6c54-4b01-90e6-d701748f0851")
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, newlist, actuallist)
+	actuallist, err := store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
 
 	// Add it again; fails due to conflict.
-	status = AddList(newlist)
-	assert.Equal(t, http.StatusConflict, status)
+	_, err = store.AddList(newlist)
+	assert.ErrorIs(t, err, ErrConflict)
 
 	// Add a list with an invalid ID; fails.
 	newlist.ID = "This is not a valid UUID"
-	status = AddList(newlist)
-	assert.Equal(t, http.StatusBadRequest, status)
+	_, err = store.AddList(newlist)
+	assert.ErrorIs(t, err, ErrInvalidID)
 
 	// Add a list with a new ID; succeeds.
 	newlist.ID = "d290f1ee-6c54-4b01-90e6-d701748f0852"
-	status = AddList(newlist)
-	assert.Equal(t, http.StatusCreated, status)
+	_, err = store.AddList(newlist)
+	assert.Nil(t, err)
 
 	// Check list values.
-	actuallist, status = GetList("d290f1ee-6c54-4b01-90e6-d701748f0852")
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, newlist, actuallist)
-
-	// Teardown.
-	lists = make(listmap)
+	actuallist, err = store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0852", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
 }
 
 func TestAddTask(t *testing.T) {
+	store := NewMemoryStore()
+
 	// Dummy list.
 	newlist := TodoList{
-		"d290f1ee-6c54-4b01-90e6-d701748f0851",
-		"Home",
-		"The list of things that need to be done at home\n",
-		[]Task{},
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{},
 	}
 
 	// Add this list; succeeds.
-	status := AddList(newlist)
-	assert.Equal(t, http.StatusCreated, status)
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
 
 	// Dummy task.
-	newtask := Task{"0e2ac84f-f723-4f24-878b-44e63e7ae580", "mow the yard", true}
+	newtask := Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: true}
 
 	// Add this task; succeeds.
-	status = AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
-	assert.Equal(t, http.StatusCreated, status)
+	_, err = store.AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
+	assert.Nil(t, err)
 
 	// Check list values.
 	newlist.Tasks = []Task{newtask}
-	actuallist, status := GetList("d290f1ee-6c54-4b01-90e6-d701748f0851")
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, newlist, actuallist)
+	actuallist, err := store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
 
 	// Add it again; fails due to conflict.
-	status = AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
-	assert.Equal(t, http.StatusConflict, status)
+	_, err = store.AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
+	assert.ErrorIs(t, err, ErrConflict)
 
 	// Add it to an invalid list; fails.
-	status = AddTask("d290f1ee-6c54-4b01-90e6-d701748f0852", newtask)
-	assert.Equal(t, http.StatusBadRequest, status)
+	_, err = store.AddTask("d290f1ee-6c54-4b01-90e6-d701748f0852", newtask)
+	assert.ErrorIs(t, err, ErrNotFound)
 
 	// Add a task with an invalid ID; fails.
 	newtask.ID = "This is not a valid UUID"
-	status = AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
-	assert.Equal(t, http.StatusBadRequest, status)
+	_, err = store.AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
+	assert.ErrorIs(t, err, ErrInvalidID)
 
 	// Add a task with a new ID; succeeds.
 	newtask.ID = "0e2ac84f-f723-4f24-878b-44e63e7ae581"
-	status = AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
-	assert.Equal(t, http.StatusCreated, status)
+	_, err = store.AddTask("d290f1ee-6c54-4b01-90e6-d701748f0851", newtask)
+	assert.Nil(t, err)
 
 	// Check list values.
 	newlist.Tasks = append(newlist.Tasks, newtask)
-	actuallist, status = GetList("d290f1ee-6c54-4b01-90e6-d701748f0851")
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, newlist, actuallist)
+	actuallist, err = store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+}
+
+func TestGeneratedIDs(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list without IDs.
+	newlist := TodoList{
+		ID:          "",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{Task{ID: "", Name: "mow the yard", Completed: false}, Task{ID: "", Name: "wash the car", Completed: false}},
+	}
+
+	// Add this list; succeeds, generating time-ordered IDs.
+	addedlist, err := store.AddList(newlist)
+	assert.Nil(t, err)
+	listid, err := uuid.Parse(addedlist.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uuid.Version(7), listid.Version())
+	assert.Len(t, addedlist.Tasks, 2)
+	assert.NotEqual(t, addedlist.Tasks[0].ID, addedlist.Tasks[1].ID)
+
+	// Check list values.
+	actuallist, err := store.GetList(addedlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, addedlist, actuallist)
+
+	// Add a task without an ID; succeeds.
+	addedtask, err := store.AddTask(addedlist.ID, Task{ID: "", Name: "paint the fence", Completed: false})
+	assert.Nil(t, err)
+	taskid, err := uuid.Parse(addedtask.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uuid.Version(7), taskid.Version())
+	assert.Equal(t, "paint the fence", addedtask.Name)
 
-	// Teardown.
-	lists = make(listmap)
+	// Client-supplied IDs are kept.
+	addedtask, err = store.AddTask(addedlist.ID, Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "weed the garden", Completed: false})
+	assert.Nil(t, err)
+	assert.Equal(t, "0e2ac84f-f723-4f24-878b-44e63e7ae580", addedtask.ID)
 }
 
 func TestSetCompleted(t *testing.T) {
+	store := NewMemoryStore()
+
 	// Dummy list.
 	newlist := TodoList{
-		"d290f1ee-6c54-4b01-90e6-d701748f0851",
-		"Home",
-		"The list of things that need to be done at home\n",
-		[]Task{Task{"0e2ac84f-f723-4f24-878b-44e63e7ae580", "mow the yard", false}},
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}},
 	}
 
 	// Add this list; succeeds.
-	status := AddList(newlist)
-	assert.Equal(t, http.StatusCreated, status)
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
 
 	// Set the task to complete; succeeds.
-	completed := CompletedTask{true}
-	status = SetCompleted("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae580", completed)
-	assert.Equal(t, http.StatusCreated, status)
+	completed := CompletedTask{Completed: true}
+	err = store.SetCompleted("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae580", completed)
+	assert.Nil(t, err)
 
 	// Check list values.
 	newlist.Tasks[0].Completed = true
-	actuallist, status := GetList("d290f1ee-6c54-4b01-90e6-d701748f0851")
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, newlist, actuallist)
+	actuallist, err := store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
 
 	// Set a task on an invalid list to complete; fails.
-	status = SetCompleted("d290f1ee-6c54-4b01-90e6-d701748f0852", "0e2ac84f-f723-4f24-878b-44e63e7ae580", completed)
-	assert.Equal(t, http.StatusBadRequest, status)
+	err = store.SetCompleted("d290f1ee-6c54-4b01-90e6-d701748f0852", "0e2ac84f-f723-4f24-878b-44e63e7ae580", completed)
+	assert.ErrorIs(t, err, ErrNotFound)
 
 	// Set an invalid task to complete; fails.
-	status = SetCompleted("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae581", completed)
-	assert.Equal(t, http.StatusBadRequest, status)
+	err = store.SetCompleted("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae581", completed)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestUpdateList(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list.
+	newlist := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}},
+	}
+
+	// Add this list; succeeds.
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
+
+	// Rename it; succeeds, leaving the description alone.
+	name := "House"
+	actuallist, err := store.UpdateList("d290f1ee-6c54-4b01-90e6-d701748f0851", TodoListPatch{Name: &name})
+	assert.Nil(t, err)
+	newlist.Name = "House"
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// Clear the description; succeeds, leaving the name alone.
+	description := ""
+	actuallist, err = store.UpdateList("d290f1ee-6c54-4b01-90e6-d701748f0851", TodoListPatch{Description: &description})
+	assert.Nil(t, err)
+	newlist.Description = ""
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// Check list values.
+	actuallist, err = store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// Update an invalid list; fails.
+	_, err = store.UpdateList("d290f1ee-6c54-4b01-90e6-d701748f0852", TodoListPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Update a list with an invalid ID; fails.
+	_, err = store.UpdateList("This is not a valid UUID", TodoListPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrInvalidID)
+}
+
+func TestUpdateTask(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list.
+	newlist := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}},
+	}
+
+	// Add this list; succeeds.
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
+
+	// Rename the task; succeeds.
+	name := "mow the lawn"
+	actualtask, err := store.UpdateTask("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae580", TaskPatch{Name: &name})
+	assert.Nil(t, err)
+	newlist.Tasks[0].Name = "mow the lawn"
+	assert.Equal(t, newlist.Tasks[0], unversioned(actualtask))
+
+	// Complete it; succeeds, leaving the name alone.
+	completed := true
+	actualtask, err = store.UpdateTask("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae580", TaskPatch{Completed: &completed})
+	assert.Nil(t, err)
+	newlist.Tasks[0].Completed = true
+	assert.Equal(t, newlist.Tasks[0], unversioned(actualtask))
+
+	// Check list values.
+	actuallist, err := store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// Update an invalid task; fails.
+	_, err = store.UpdateTask("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae581", TaskPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Update a task on an invalid list; fails.
+	_, err = store.UpdateTask("d290f1ee-6c54-4b01-90e6-d701748f0852", "0e2ac84f-f723-4f24-878b-44e63e7ae580", TaskPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMoveTask(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list, with its tasks out of alphabetical order.
+	newlist := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "wash the car", Completed: false},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "mow the yard", Completed: false},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "paint the fence", Completed: false},
+		},
+	}
+	car, yard, fence := newlist.Tasks[0], newlist.Tasks[1], newlist.Tasks[2]
+
+	// Add this list; succeeds, keeping the given order.
+	addedlist, err := store.AddList(newlist)
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(addedlist))
+
+	// By default, the tasks are sorted by name.
+	actuallist, err := store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{yard, fence, car}, unversioned(actuallist.Tasks))
+
+	// By position, they're in the order given.
+	actuallist, err = store.GetList(newlist.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{car, yard, fence}, unversioned(actuallist.Tasks))
+
+	// Move the fence to the top; succeeds.
+	moved, err := store.MoveTask(newlist.ID, fence.ID, TaskMove{Before: car.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, fence, unversioned(moved))
+	actuallist, err = store.GetList(newlist.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{fence, car, yard}, unversioned(actuallist.Tasks))
+
+	// Move it between the others; succeeds.
+	_, err = store.MoveTask(newlist.ID, fence.ID, TaskMove{After: car.ID})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(newlist.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{car, fence, yard}, unversioned(actuallist.Tasks))
+
+	// Move the car to the bottom; succeeds.
+	_, err = store.MoveTask(newlist.ID, car.ID, TaskMove{After: yard.ID})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(newlist.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{fence, yard, car}, unversioned(actuallist.Tasks))
+
+	// New tasks go at the bottom.
+	weeds := Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "weed the garden", Completed: false}
+	_, err = store.AddTask(newlist.ID, weeds)
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(newlist.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{fence, yard, car, weeds}, unversioned(actuallist.Tasks))
+
+	// Move a task next to itself; fails.
+	_, err = store.MoveTask(newlist.ID, car.ID, TaskMove{Before: car.ID})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+
+	// Move a task without saying where; fails.
+	_, err = store.MoveTask(newlist.ID, car.ID, TaskMove{})
+	assert.ErrorAs(t, err, &verr)
+
+	// Move a task both before and after; fails.
+	_, err = store.MoveTask(newlist.ID, car.ID, TaskMove{Before: yard.ID, After: fence.ID})
+	assert.ErrorAs(t, err, &verr)
+
+	// Move a task next to a non-existent task; fails.
+	_, err = store.MoveTask(newlist.ID, car.ID, TaskMove{Before: "0e2ac84f-f723-4f24-878b-44e63e7ae589"})
+	assert.ErrorAs(t, err, &verr)
+
+	// Move a non-existent task; fails.
+	_, err = store.MoveTask(newlist.ID, "0e2ac84f-f723-4f24-878b-44e63e7ae589", TaskMove{Before: car.ID})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Ask for an unknown sort order; fails.
+	_, err = store.GetList(newlist.ID, ListOptions{Sort: "color"})
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestMoveTaskBetweenLists(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy lists, both holding a task with the same ID.
+	home := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: true},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the fence", Completed: false},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "weed the garden", Completed: false},
+		},
+	}
+	work := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0852",
+		Name:        "Work",
+		Description: "",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "file the report", Completed: false},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the office", Completed: false},
+		},
+	}
+	yard, report := home.Tasks[0], work.Tasks[0]
+
+	// Add these lists; succeeds.
+	_, err := store.AddList(home)
+	assert.Nil(t, err)
+	_, err = store.AddList(work)
+	assert.Nil(t, err)
+
+	// Move the yard to work, at the end; succeeds, keeping its ID and state.
+	moved, err := store.MoveTask(home.ID, yard.ID, TaskMove{List: work.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, yard, unversioned(moved))
+	actuallist, err := store.GetList(work.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{report, work.Tasks[1], yard}, unversioned(actuallist.Tasks))
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, home.Tasks[1:], unversioned(actuallist.Tasks))
+
+	// Move it back, before the fence; succeeds.
+	_, err = store.MoveTask(work.ID, yard.ID, TaskMove{List: home.ID, Before: home.Tasks[1].ID})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, home.Tasks, unversioned(actuallist.Tasks))
+
+	// Move a task to a list which already has its ID; fails, changing nothing.
+	_, err = store.MoveTask(home.ID, home.Tasks[1].ID, TaskMove{List: work.ID})
+	assert.ErrorIs(t, err, ErrConflict)
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, home.Tasks, unversioned(actuallist.Tasks))
+
+	// Move a task next to one that's in its old list, not its new one; fails.
+	var verr *ValidationError
+	_, err = store.MoveTask(home.ID, yard.ID, TaskMove{List: work.ID, After: home.Tasks[2].ID})
+	assert.ErrorAs(t, err, &verr)
+
+	// Move a task to a non-existent list; fails, as for a missing source
+	// list.
+	_, err = store.MoveTask(home.ID, yard.ID, TaskMove{List: "d290f1ee-6c54-4b01-90e6-d701748f0859"})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Move a task to a malformed list ID; fails.
+	_, err = store.MoveTask(home.ID, yard.ID, TaskMove{List: "Work"})
+	assert.ErrorAs(t, err, &verr)
+
+	// Moving to the list the task is already in needs a neighbour; fails.
+	_, err = store.MoveTask(home.ID, yard.ID, TaskMove{List: home.ID})
+	assert.ErrorAs(t, err, &verr)
+
+	// Move a task back and forth while completing and uncompleting it.  Each
+	// move happens in one step, so the task always ends up in exactly one
+	// list, with no copy left behind.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, err := store.MoveTask(work.ID, report.ID, TaskMove{List: home.ID})
+			assert.Nil(t, err)
+			_, err = store.MoveTask(home.ID, report.ID, TaskMove{List: work.ID})
+			assert.Nil(t, err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			// Chase the task until we catch it in one of the lists.
+			listid, otherid := work.ID, home.ID
+			for {
+				err := store.SetCompleted(listid, report.ID, CompletedTask{Completed: i%2 == 1})
+				if err == nil {
+					break
+				}
+				assert.ErrorIs(t, err, ErrNotFound)
+				listid, otherid = otherid, listid
+			}
+		}
+	}()
+	wg.Wait()
+	report.Completed = true
+	actuallist, err = store.GetList(work.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Contains(t, unversioned(actuallist.Tasks), report)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, home.Tasks, unversioned(actuallist.Tasks))
+}
+
+func TestTaskDates(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Some times, in various zones.
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	assert.Nil(t, err)
+	monday := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+	tuesday := time.Date(2026, 11, 3, 17, 0, 0, 0, time.FixedZone("", 3600))
+	friday := time.Date(2026, 11, 6, 12, 0, 0, 0, time.UTC)
+
+	// Dummy lists.
+	home := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Start: &monday, Due: &tuesday},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the fence"},
+		},
+	}
+	work := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0852",
+		Name:        "Work",
+		Description: "",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "file the report", Due: &monday, Completed: true},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "book the flights", Due: &friday, TimeZone: "Europe/Oslo"},
+		},
+	}
+	yard, fence, report, flights := home.Tasks[0], home.Tasks[1], work.Tasks[0], work.Tasks[1]
+
+	// Add these lists; succeeds, keeping the offsets given, except where
+	// the task has a time zone.
+	addedlist, err := store.AddList(home)
+	assert.Nil(t, err)
+	assert.Equal(t, home, unversioned(addedlist))
+	addedlist, err = store.AddList(work)
+	assert.Nil(t, err)
+	flightsdue := friday.In(oslo)
+	flights.Due = &flightsdue
+	assert.Equal(t, []Task{report, flights}, unversioned(addedlist.Tasks))
+
+	// Filter a list by due time; tasks without one don't match.
+	actuallist, err := store.GetList(home.ID, ListOptions{Filter: TaskFilter{DueBefore: friday}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{yard}, unversioned(actuallist.Tasks))
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{DueAfter: friday}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{}, actuallist.Tasks)
+
+	// The bounds compare instants, not wall clocks: the yard is due at 16:00
+	// UTC.
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{DueAfter: time.Date(2026, 11, 3, 16, 0, 0, 0, time.UTC)}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{yard}, unversioned(actuallist.Tasks))
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{DueBefore: time.Date(2026, 11, 3, 16, 0, 0, 0, time.UTC)}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{}, actuallist.Tasks)
+
+	// Find the overdue tasks across all lists on Wednesday.
+	completed := false
+	tasks, err := store.GetTasks(TaskFilter{DueBefore: time.Date(2026, 11, 4, 0, 0, 0, 0, time.UTC), Completed: &completed})
+	assert.Nil(t, err)
+	assert.Equal(t, []ListTask{{home.ID, yard}}, unversioned(tasks))
+
+	// Get every task; they come in order of due time, with those that have
+	// none last.
+	tasks, err = store.GetTasks(TaskFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, []ListTask{{work.ID, report}, {home.ID, yard}, {work.ID, flights}, {home.ID, fence}}, unversioned(tasks))
+
+	// Add a task which starts after it's due; fails.
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Start: &friday, Due: &monday})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "start", verr.Fields[0].Field)
+
+	// Add a task in an unknown time zone; fails.
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Due: &monday, TimeZone: "Mars/Olympus_Mons"})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "timeZone", verr.Fields[0].Field)
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Due: &monday, TimeZone: "Local"})
+	assert.ErrorAs(t, err, &verr)
+
+	// Give the yard a time zone; succeeds, and its times move into it.
+	zone := "Europe/Oslo"
+	updatedtask, err := store.UpdateTask(home.ID, yard.ID, TaskPatch{TimeZone: &zone})
+	assert.Nil(t, err)
+	assert.Equal(t, "Europe/Oslo", updatedtask.TimeZone)
+	assert.Equal(t, oslo, updatedtask.Start.Location())
+	assert.Equal(t, oslo, updatedtask.Due.Location())
+	assert.True(t, monday.Equal(*updatedtask.Start))
+	assert.True(t, tuesday.Equal(*updatedtask.Due))
+
+	// Move its start after its due time; fails, changing nothing.
+	_, err = store.UpdateTask(home.ID, yard.ID, TaskPatch{Start: &friday})
+	assert.ErrorAs(t, err, &verr)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, updatedtask, actuallist.Tasks[0])
+
+	// Remove its dates and zone; succeeds.
+	none := ""
+	updatedtask, err = store.UpdateTask(home.ID, yard.ID, TaskPatch{Start: &time.Time{}, Due: &time.Time{}, TimeZone: &none})
+	assert.Nil(t, err)
+	assert.Equal(t, Task{ID: yard.ID, Name: yard.Name}, unversioned(updatedtask))
+}
+
+func TestPriority(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Some due times.
+	monday := time.Date(2026, 11, 2, 9, 0, 0, 0, time.UTC)
+	friday := time.Date(2026, 11, 6, 12, 0, 0, 0, time.UTC)
+
+	// Dummy lists.
+	home := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Priority: PriorityLow},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the fence", Priority: PriorityHigh, Due: &friday},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "wash the car"},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "weed the garden", Priority: PriorityHigh, Due: &monday},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae584", Name: "clean the gutters", Priority: PriorityHigh, Due: &monday},
+		},
+	}
+	work := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0852",
+		Name:        "Work",
+		Description: "",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae585", Name: "file the report", Priority: PriorityNone},
+		},
+	}
+	yard, fence, car, weeds, gutters := home.Tasks[0], home.Tasks[1], home.Tasks[2], home.Tasks[3], home.Tasks[4]
+
+	// Add these lists; succeeds, with no priority left out.
+	_, err := store.AddList(home)
+	assert.Nil(t, err)
+	addedlist, err := store.AddList(work)
+	assert.Nil(t, err)
+	report := Task{ID: work.Tasks[0].ID, Name: work.Tasks[0].Name}
+	assert.Equal(t, []Task{report}, unversioned(addedlist.Tasks))
+
+	// Sort by priority, then due time, then name.
+	actuallist, err := store.GetList(home.ID, ListOptions{Sort: SortPriority})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{gutters, weeds, fence, yard, car}, unversioned(actuallist.Tasks))
+
+	// Filter by priority.
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{Priorities: []string{PriorityLow, PriorityNone}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{yard, car}, unversioned(actuallist.Tasks))
+
+	// Search for lists with high priority tasks; only those tasks are
+	// included, sorted as asked.
+	response, err := store.GetLists("", 0, 0, ListOptions{Sort: SortPriority, Filter: TaskFilter{Priorities: []string{PriorityHigh}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{TodoList{ID: home.ID, Name: home.Name, Description: home.Description, Tasks: []Task{gutters, weeds, fence}}}, unversioned(response))
+
+	// Without a filter, every list is included, with all its tasks.
+	response, err = store.GetLists("", 0, 0, ListOptions{Sort: SortPriority})
+	assert.Nil(t, err)
+	assert.Equal(t, unversioned([]TodoList{TodoList{ID: home.ID, Name: home.Name, Description: home.Description, Tasks: []Task{gutters, weeds, fence, yard, car}}, addedlist}), unversioned(response))
+
+	// Raise the car's priority; succeeds.
+	urgent := PriorityUrgent
+	updatedtask, err := store.UpdateTask(home.ID, car.ID, TaskPatch{Priority: &urgent})
+	assert.Nil(t, err)
+	car.Priority = PriorityUrgent
+	assert.Equal(t, car, unversioned(updatedtask))
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPriority})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{car, gutters, weeds, fence, yard}, unversioned(actuallist.Tasks))
+
+	// Set an unknown priority; fails.
+	bogus := "whenever"
+	_, err = store.UpdateTask(home.ID, car.ID, TaskPatch{Priority: &bogus})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "priority", verr.Fields[0].Field)
+	_, err = store.AddTask(home.ID, Task{Name: "sweep the porch", Priority: "High"})
+	assert.ErrorAs(t, err, &verr)
+
+	// Filter by an unknown priority; fails.
+	_, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{Priorities: []string{bogus}}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.GetLists("", 0, 0, ListOptions{Filter: TaskFilter{Priorities: []string{bogus}}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.GetTasks(TaskFilter{Priorities: []string{bogus}})
+	assert.ErrorAs(t, err, &verr)
+
+	// Sort in an unknown order; fails.
+	_, err = store.GetLists("", 0, 0, ListOptions{Sort: "color"})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "taskSort", verr.Fields[0].Field)
+
+	// Remove the car's priority; succeeds.
+	none := ""
+	updatedtask, err = store.UpdateTask(home.ID, car.ID, TaskPatch{Priority: &none})
+	assert.Nil(t, err)
+	assert.Equal(t, "", updatedtask.Priority)
+}
+
+func TestTags(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy lists, with tags in various forms.
+	home := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name: "Home",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Tags: []string{"Outdoors", " weekend "}},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "paint the fence", Tags: []string{"outdoors", "OUTDOORS"}},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "wash the car"},
+		},
+	}
+	work := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0852",
+		Name: "Work",
+		Tags: []string{"Release-Blocker"},
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "file the report", Tags: []string{"weekend"}},
+		},
+	}
+	errands := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0853",
+		Name: "Errands",
+		Tags: []string{"weekend"},
+	}
+
+	// Add these lists; succeeds, with the tags in canonical form.
+	addedlist, err := store.AddList(home)
+	assert.Nil(t, err)
+	yard, fence, car := addedlist.Tasks[0], addedlist.Tasks[1], addedlist.Tasks[2]
+	assert.Equal(t, []string{"outdoors", "weekend"}, yard.Tags)
+	assert.Equal(t, []string{"outdoors"}, fence.Tags)
+	assert.Nil(t, car.Tags)
+	addedlist, err = store.AddList(work)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"release-blocker"}, addedlist.Tags)
+	report := addedlist.Tasks[0]
+	_, err = store.AddList(errands)
+	assert.Nil(t, err)
+
+	// Count the tags.
+	tags, err := store.GetTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []TagCount{{"weekend", 1, 2}, {"outdoors", 0, 2}, {"release-blocker", 1, 0}}, tags)
+
+	// Filter a list by tag, in any case.
+	actuallist, err := store.GetList(home.ID, ListOptions{Filter: TaskFilter{Tags: []string{"Outdoors"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{yard, fence}, actuallist.Tasks)
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{Tags: []string{"outdoors", "weekend"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{yard}, actuallist.Tasks)
+
+	// Tasks carry their list's tags.
+	actuallist, err = store.GetList(work.ID, ListOptions{Filter: TaskFilter{Tags: []string{"release-blocker", "weekend"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{report}, actuallist.Tasks)
+
+	// Search for everything tagged for the weekend, across lists.  Lists
+	// tagged themselves are found even if empty.
+	response, err := store.GetLists("", 0, 0, ListOptions{Filter: TaskFilter{Tags: []string{"weekend"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(response))
+	assert.Equal(t, errands.ID, response[0].ID)
+	assert.Equal(t, []string{"weekend"}, response[0].Tags)
+	assert.Equal(t, []Task{yard}, response[1].Tasks)
+	assert.Equal(t, []Task{report}, response[2].Tasks)
+
+	// Combined with another filter, empty lists drop out.
+	completed := false
+	response, err = store.GetLists("", 0, 0, ListOptions{Filter: TaskFilter{Tags: []string{"weekend"}, Completed: &completed}})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(response))
+	tasks, err := store.GetTasks(TaskFilter{Tags: []string{"release-blocker"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []ListTask{{work.ID, report}}, tasks)
+
+	// Search for an unused tag.
+	response, err = store.GetLists("", 0, 0, ListOptions{Filter: TaskFilter{Tags: []string{"someday"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{}, response)
+
+	// Retag, move and delete things; the counts follow.
+	newtags := []string{"indoors"}
+	_, err = store.UpdateTask(home.ID, fence.ID, TaskPatch{Tags: &newtags})
+	assert.Nil(t, err)
+	_, err = store.UpdateList(work.ID, TodoListPatch{Tags: &[]string{}})
+	assert.Nil(t, err)
+	_, err = store.MoveTask(home.ID, yard.ID, TaskMove{List: work.ID})
+	assert.Nil(t, err)
+	err = store.DeleteList(errands.ID)
+	assert.Nil(t, err)
+	tags, err = store.GetTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []TagCount{{"weekend", 0, 2}, {"indoors", 0, 1}, {"outdoors", 0, 1}}, tags)
+	tasks, err = store.GetTasks(TaskFilter{Tags: []string{"outdoors"}})
+	assert.Nil(t, err)
+	assert.Equal(t, unversioned([]ListTask{{work.ID, yard}}), unversioned(tasks))
+	err = store.DeleteTask(work.ID, yard.ID)
+	assert.Nil(t, err)
+	tags, err = store.GetTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []TagCount{{"indoors", 0, 1}, {"weekend", 0, 1}}, tags)
+
+	// The output can't be used to change the store.
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	actuallist.Tasks[0].Tags[0] = "outdoors"
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"indoors"}, actuallist.Tasks[0].Tags)
+
+	// Bad tags; fails.
+	var verr *ValidationError
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Tags: []string{" "}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Tags: []string{"a,b"}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.AddList(TodoList{Name: "Garden", Tags: []string{strings.Repeat("x", 65)}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{Tags: []string{""}}})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "tag", verr.Fields[0].Field)
+}
+
+func TestSubtasks(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list, with a child listed before its parent.
+	home := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name: "Home",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "buy paint", Parent: "0e2ac84f-f723-4f24-878b-44e63e7ae580"},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "paint the fence"},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "sand the fence", Parent: "0e2ac84f-f723-4f24-878b-44e63e7ae580"},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "find sandpaper", Parent: "0e2ac84f-f723-4f24-878b-44e63e7ae582"},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae584", Name: "wash the car"},
+		},
+	}
+	paint, fence, sand, sandpaper, car := home.Tasks[0], home.Tasks[1], home.Tasks[2], home.Tasks[3], home.Tasks[4]
+
+	// Add this list; succeeds.
+	addedlist, err := store.AddList(home)
+	assert.Nil(t, err)
+	assert.Equal(t, home, unversioned(addedlist))
+
+	// Get it as a tree, in the order given.
+	actuallist, err := store.GetList(home.ID, ListOptions{Sort: SortPosition, Tree: true})
+	assert.Nil(t, err)
+	fencetree := fence
+	sandtree := sand
+	sandtree.Subtasks = []Task{sandpaper}
+	fencetree.Subtasks = []Task{paint, sandtree}
+	assert.Equal(t, []Task{fencetree, car}, unversioned(actuallist.Tasks))
+
+	// Filter the tree; the parents of the tasks found are included.
+	actuallist, err = store.GetList(home.ID, ListOptions{Tree: true, Filter: TaskFilter{Tags: []string{}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{fencetree, car}, unversioned(actuallist.Tasks))
+	_, err = store.UpdateTask(home.ID, sandpaper.ID, TaskPatch{Tags: &[]string{"shopping"}})
+	assert.Nil(t, err)
+	sandpaper.Tags = []string{"shopping"}
+	actuallist, err = store.GetList(home.ID, ListOptions{Tree: true, Filter: TaskFilter{Tags: []string{"shopping"}}})
+	assert.Nil(t, err)
+	sandtree.Subtasks = []Task{sandpaper}
+	fencetree.Subtasks = []Task{sandtree}
+	assert.Equal(t, []Task{fencetree}, unversioned(actuallist.Tasks))
+
+	// Flat, the tasks carry their parents' IDs instead.
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{Tags: []string{"shopping"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{sandpaper}, unversioned(actuallist.Tasks))
+
+	// Complete the fence and everything under it; succeeds.
+	err = store.SetCompleted(home.ID, fence.ID, CompletedTask{Completed: true, Cascade: true})
+	assert.Nil(t, err)
+	completed := true
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{Completed: &completed}})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(actuallist.Tasks))
+
+	// Reopen the fence alone; succeeds, leaving its subtasks done.
+	err = store.SetCompleted(home.ID, fence.ID, CompletedTask{Completed: false})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{Filter: TaskFilter{Completed: &completed}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(actuallist.Tasks))
+
+	// Make a task its own ancestor; fails.
+	var verr *ValidationError
+	parent := sandpaper.ID
+	_, err = store.UpdateTask(home.ID, fence.ID, TaskPatch{Parent: &parent})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "parent", verr.Fields[0].Field)
+	parent = fence.ID
+	_, err = store.UpdateTask(home.ID, fence.ID, TaskPatch{Parent: &parent})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.AddList(TodoList{Name: "Loop", Tasks: []Task{
+		Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae585", Name: "chicken", Parent: "0e2ac84f-f723-4f24-878b-44e63e7ae586"},
+		Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae586", Name: "egg", Parent: "0e2ac84f-f723-4f24-878b-44e63e7ae585"},
+	}})
+	assert.ErrorAs(t, err, &verr)
+
+	// Hang a task from one which isn't in the list; fails.
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Parent: "0e2ac84f-f723-4f24-878b-44e63e7ae589"})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Parent: "the fence"})
+	assert.ErrorAs(t, err, &verr)
+
+	// Give a task subtasks directly; fails.
+	_, err = store.AddTask(home.ID, Task{Name: "weed the garden", Subtasks: []Task{paint}})
+	assert.ErrorAs(t, err, &verr)
+
+	// Move the sanding under the car, then back to the top; succeeds.
+	parent = car.ID
+	updatedtask, err := store.UpdateTask(home.ID, sand.ID, TaskPatch{Parent: &parent})
+	assert.Nil(t, err)
+	assert.Equal(t, car.ID, updatedtask.Parent)
+	parent = ""
+	updatedtask, err = store.UpdateTask(home.ID, sand.ID, TaskPatch{Parent: &parent})
+	assert.Nil(t, err)
+	assert.Equal(t, "", updatedtask.Parent)
+
+	// Move the sanding to another list; its subtree goes along.
+	work := TodoList{ID: "d290f1ee-6c54-4b01-90e6-d701748f0852", Name: "Work", Tasks: []Task{
+		Task{ID: sandpaper.ID, Name: "order sandpaper"},
+	}}
+	_, err = store.AddList(work)
+	assert.Nil(t, err)
+	_, err = store.MoveTask(home.ID, sand.ID, TaskMove{List: work.ID})
+	assert.ErrorIs(t, err, ErrConflict)
+	err = store.DeleteTask(work.ID, sandpaper.ID)
+	assert.Nil(t, err)
+	moved, err := store.MoveTask(home.ID, sand.ID, TaskMove{List: work.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, "", moved.Parent)
+	actuallist, err = store.GetList(work.ID, ListOptions{Tree: true})
+	assert.Nil(t, err)
+	sandtree = sand
+	sandtree.Parent = ""
+	sandtree.Completed = true
+	sandpaper.Completed = true
+	sandtree.Subtasks = []Task{sandpaper}
+	assert.Equal(t, []Task{sandtree}, unversioned(actuallist.Tasks))
+
+	// Delete the fence; its subtree goes too.
+	err = store.DeleteTask(home.ID, fence.ID)
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{car}, unversioned(actuallist.Tasks))
+}
+
+func TestDependencies(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy lists.  The painting waits for the fence to be sanded, in the
+	// same list, and for the paint to be bought, in another.
+	shop := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0852",
+		Name: "Shopping",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "buy paint"},
+		},
+	}
+	home := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name: "Home",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "paint the fence", BlockedBy: []TaskRef{
+				{Task: "0e2ac84f-f723-4f24-878b-44e63e7ae581"},
+				{List: shop.ID, Task: "0e2ac84f-f723-4f24-878b-44e63e7ae583"},
+			}},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "sand the fence"},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "wash the car"},
+		},
+	}
+	buy := shop.Tasks[0]
+	paint, sand, car := home.Tasks[0], home.Tasks[1], home.Tasks[2]
+
+	// Add a list blocked by a task which doesn't exist yet; fails.
+	var verr *ValidationError
+	_, err := store.AddList(home)
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "blockedBy", verr.Fields[0].Field)
+
+	// Add the lists; succeeds.  The blockers are reported in full, sorted.
+	_, err = store.AddList(shop)
+	assert.Nil(t, err)
+	addedlist, err := store.AddList(home)
+	assert.Nil(t, err)
+	paint.BlockedBy = []TaskRef{{home.ID, sand.ID}, {shop.ID, buy.ID}}
+	paint.Blocked = true
+	assert.Equal(t, []Task{paint, sand, car}, unversioned(addedlist.Tasks))
+
+	// Complete the painting; fails while either blocker is open.
+	err = store.SetCompleted(home.ID, paint.ID, CompletedTask{Completed: true})
+	assert.ErrorIs(t, err, ErrBlocked)
+	err = store.SetCompleted(home.ID, sand.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	err = store.SetCompleted(home.ID, paint.ID, CompletedTask{Completed: true})
+	assert.ErrorIs(t, err, ErrBlocked)
+	completed := true
+	_, err = store.UpdateTask(home.ID, paint.ID, TaskPatch{Completed: &completed})
+	assert.ErrorIs(t, err, ErrBlocked)
+
+	// Force it, then reopen it; succeeds.
+	err = store.SetCompleted(home.ID, paint.ID, CompletedTask{Completed: true, Force: true})
+	assert.Nil(t, err)
+	actuallist, err := store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, true, actuallist.Tasks[0].Completed)
+	assert.Equal(t, true, actuallist.Tasks[0].Blocked)
+	err = store.SetCompleted(home.ID, paint.ID, CompletedTask{Completed: false})
+	assert.Nil(t, err)
+
+	// Make dependency cycles; fails.
+	blockers := []TaskRef{{Task: paint.ID}}
+	_, err = store.UpdateTask(home.ID, sand.ID, TaskPatch{BlockedBy: &blockers})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, FieldError{Field: "blockedBy", Message: "would make the task depend on itself"}, verr.Fields[0])
+	_, err = store.UpdateTask(shop.ID, buy.ID, TaskPatch{BlockedBy: &[]TaskRef{{List: home.ID, Task: paint.ID}}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.UpdateTask(home.ID, car.ID, TaskPatch{BlockedBy: &[]TaskRef{{Task: car.ID}}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.AddList(TodoList{Name: "Loop", Tasks: []Task{
+		Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae585", Name: "chicken", BlockedBy: []TaskRef{{Task: "0e2ac84f-f723-4f24-878b-44e63e7ae586"}}},
+		Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae586", Name: "egg", BlockedBy: []TaskRef{{Task: "0e2ac84f-f723-4f24-878b-44e63e7ae585"}}},
+	}})
+	assert.ErrorAs(t, err, &verr)
+
+	// Refer to a blocker badly; fails.
+	_, err = store.UpdateTask(home.ID, car.ID, TaskPatch{BlockedBy: &[]TaskRef{{Task: "the fence"}}})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.UpdateTask(home.ID, car.ID, TaskPatch{BlockedBy: &[]TaskRef{{List: "home", Task: sand.ID}}})
+	assert.ErrorAs(t, err, &verr)
+
+	// Move the shopping into the home list; the painting follows it.
+	_, err = store.MoveTask(shop.ID, buy.ID, TaskMove{List: home.ID})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, buy.ID, actuallist.Tasks[0].ID)
+	assert.Equal(t, []TaskRef{{home.ID, sand.ID}, {home.ID, buy.ID}}, actuallist.Tasks[1].BlockedBy)
+
+	// Cascading completion may complete a blocker along with its
+	// dependents; succeeds.
+	parent := car.ID
+	_, err = store.UpdateTask(home.ID, paint.ID, TaskPatch{Parent: &parent})
+	assert.Nil(t, err)
+	_, err = store.UpdateTask(home.ID, buy.ID, TaskPatch{Parent: &parent})
+	assert.Nil(t, err)
+	err = store.SetCompleted(home.ID, car.ID, CompletedTask{Completed: true, Cascade: true})
+	assert.Nil(t, err)
+	err = store.SetCompleted(home.ID, car.ID, CompletedTask{Completed: false, Cascade: true})
+	assert.Nil(t, err)
+
+	// Delete the shopping; the painting is no longer blocked.
+	err = store.DeleteTask(home.ID, buy.ID)
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TaskRef{{home.ID, sand.ID}}, actuallist.Tasks[0].BlockedBy)
+	assert.Equal(t, false, actuallist.Tasks[0].Blocked)
+	err = store.SetCompleted(home.ID, paint.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+
+	// Delete the home list; nothing is left in the index.
+	err = store.DeleteList(home.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(store.dependencies))
+}
+
+func TestRecurrence(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list, with a chore due every other Friday morning, three times.
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	require.Nil(t, err)
+	friday := time.Date(2026, 3, 20, 7, 0, 0, 0, oslo)
+	home := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name: "Home",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "take out the bins", Due: &friday, TimeZone: "Europe/Oslo", Recurrence: "rrule:freq=weekly;interval=2;count=3", Tags: []string{"chores"}},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "wash the car"},
+		},
+	}
+	bins, car := home.Tasks[0], home.Tasks[1]
+
+	// Add this list; succeeds, with the rule in canonical form.
+	addedlist, err := store.AddList(home)
+	assert.Nil(t, err)
+	bins.Recurrence = "FREQ=WEEKLY;INTERVAL=2;COUNT=3"
+	assert.Equal(t, []Task{bins, car}, unversioned(addedlist.Tasks))
+
+	// Complete the bins; the next occurrence follows them, two weeks on at
+	// the same time of day, after the clocks have gone forward.
+	err = store.SetCompleted(home.ID, bins.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	actuallist, err := store.GetList(home.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	require.Equal(t, 3, len(actuallist.Tasks))
+	assert.Equal(t, true, actuallist.Tasks[0].Completed)
+	next := actuallist.Tasks[1]
+	assert.NotEqual(t, bins.ID, next.ID)
+	assert.Equal(t, "2026-04-03T07:00:00+02:00", next.Due.Format(time.RFC3339))
+	assert.Equal(t, "FREQ=WEEKLY;INTERVAL=2;COUNT=2", next.Recurrence)
+	assert.Equal(t, []string{"chores"}, next.Tags)
+	assert.Equal(t, false, next.Completed)
+	tags, err := store.GetTags()
+	assert.Nil(t, err)
+	assert.Equal(t, []TagCount{{"chores", 0, 2}}, tags)
+
+	// The completed occurrence has left the series, so completing it again,
+	// even after reopening it, adds nothing.
+	err = store.SetCompleted(home.ID, bins.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	err = store.SetCompleted(home.ID, bins.ID, CompletedTask{Completed: false})
+	assert.Nil(t, err)
+	err = store.SetCompleted(home.ID, bins.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(actuallist.Tasks))
+	assert.Equal(t, "", actuallist.Tasks[0].Recurrence)
+
+	// Complete the rest; the third is the last.
+	err = store.SetCompleted(home.ID, next.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	require.Equal(t, 4, len(actuallist.Tasks))
+	last := actuallist.Tasks[2]
+	assert.Equal(t, "2026-04-17T07:00:00+02:00", last.Due.Format(time.RFC3339))
+	assert.Equal(t, "FREQ=WEEKLY;INTERVAL=2;COUNT=1", last.Recurrence)
+	err = store.SetCompleted(home.ID, last.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(actuallist.Tasks))
+
+	// Completing an occurrence by patching it advances the series too, from
+	// the occurrence as patched.  Toggling it back and forth adds nothing
+	// more.
+	recurrence := "FREQ=DAILY"
+	_, err = store.UpdateTask(home.ID, last.ID, TaskPatch{Recurrence: &recurrence})
+	assert.Nil(t, err)
+	completed := false
+	_, err = store.UpdateTask(home.ID, last.ID, TaskPatch{Completed: &completed})
+	assert.Nil(t, err)
+	completed = true
+	patched, err := store.UpdateTask(home.ID, last.ID, TaskPatch{Completed: &completed})
+	assert.Nil(t, err)
+	assert.Equal(t, "", patched.Recurrence)
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	require.Equal(t, 5, len(actuallist.Tasks))
+	daily := actuallist.Tasks[3]
+	assert.Equal(t, "2026-04-18T07:00:00+02:00", daily.Due.Format(time.RFC3339))
+	assert.Equal(t, "FREQ=DAILY", daily.Recurrence)
+	assert.False(t, daily.Completed)
+	completed = false
+	_, err = store.UpdateTask(home.ID, last.ID, TaskPatch{Completed: &completed})
+	assert.Nil(t, err)
+	completed = true
+	_, err = store.UpdateTask(home.ID, last.ID, TaskPatch{Completed: &completed})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(actuallist.Tasks))
+
+	// A rule without a due time, or a bad rule; fails.
+	var verr *ValidationError
+	_, err = store.UpdateTask(home.ID, car.ID, TaskPatch{Recurrence: &recurrence})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "recurrence", verr.Fields[0].Field)
+	recurrence = "FREQ=FORTNIGHTLY"
+	_, err = store.AddTask(home.ID, Task{Name: "water the plants", Due: &friday, Recurrence: recurrence})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "recurrence", verr.Fields[0].Field)
+
+	// Removing the due time of a recurring task; fails.
+	noDue := time.Time{}
+	_, err = store.UpdateTask(home.ID, daily.ID, TaskPatch{Due: &noDue})
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestComments(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// Dummy list.
+	home := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name: "Home",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "paint the fence"},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "wash the car"},
+		},
+	}
+	fence, car := home.Tasks[0], home.Tasks[1]
+	_, err := store.AddList(home)
+	assert.Nil(t, err)
+
+	// Add two comments; they get IDs and the store's time.
+	first, err := store.AddComment(home.ID, fence.ID, Comment{Author: "ada", Text: "white or green?"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", first.ID)
+	assert.Equal(t, now, first.Created)
+	assert.Nil(t, first.Updated)
+	now = now.Add(time.Hour)
+	second, err := store.AddComment(home.ID, fence.ID, Comment{ID: "5d3c6a8e-1c0b-4a5e-9f51-1b0c1a2d3e4f", Author: "bob", Text: "green"})
+	assert.Nil(t, err)
+	assert.Equal(t, "5d3c6a8e-1c0b-4a5e-9f51-1b0c1a2d3e4f", second.ID)
+	comments, err := store.GetComments(home.ID, fence.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{first, second}, comments)
+
+	// A task without comments has an empty thread.
+	comments, err = store.GetComments(home.ID, car.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{}, comments)
+
+	// Comments are only in the list when asked for.
+	actuallist, err := store.GetList(home.ID, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{fence, car}, unversioned(actuallist.Tasks))
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPosition, Comments: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{first, second}, actuallist.Tasks[0].Comments)
+	assert.Nil(t, actuallist.Tasks[1].Comments)
+
+	// Edit the first; it keeps its place and creation time.
+	now = now.Add(time.Hour)
+	text := "white, or perhaps green?"
+	edited, err := store.UpdateComment(home.ID, fence.ID, first.ID, CommentPatch{Text: &text})
+	assert.Nil(t, err)
+	first.Text = text
+	first.Updated = &now
+	assert.Equal(t, first, edited)
+	comments, err = store.GetComments(home.ID, fence.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{first, second}, comments)
+
+	// Editing the task leaves its comments alone.
+	name := "paint the fence green"
+	_, err = store.UpdateTask(home.ID, fence.ID, TaskPatch{Name: &name})
+	assert.Nil(t, err)
+	comments, err = store.GetComments(home.ID, fence.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{first, second}, comments)
+
+	// The comments move with the task.
+	_, err = store.MoveTask(home.ID, fence.ID, TaskMove{After: car.ID})
+	assert.Nil(t, err)
+	comments, err = store.GetComments(home.ID, fence.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{first, second}, comments)
 
-	// Teardown.
-	lists = make(listmap)
+	// Bad comments and patches; fail.
+	var verr *ValidationError
+	_, err = store.AddComment(home.ID, fence.ID, Comment{Text: "anonymous"})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "author", verr.Fields[0].Field)
+	_, err = store.AddComment(home.ID, fence.ID, Comment{Author: "ada", Text: "  "})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "text", verr.Fields[0].Field)
+	_, err = store.AddComment(home.ID, fence.ID, Comment{Author: "ada", Text: strings.Repeat("x", maxCommentLength+1)})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.AddComment(home.ID, fence.ID, Comment{Author: "ada", Text: "late", Created: now})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "created", verr.Fields[0].Field)
+	empty := ""
+	_, err = store.UpdateComment(home.ID, fence.ID, first.ID, CommentPatch{Text: &empty})
+	assert.ErrorAs(t, err, &verr)
+	_, err = store.AddTask(home.ID, Task{Name: "mow the lawn", Comments: []Comment{{Author: "ada", Text: "soon"}}})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "comments", verr.Fields[0].Field)
+
+	// A duplicate ID; fails.
+	_, err = store.AddComment(home.ID, fence.ID, Comment{ID: second.ID, Author: "bob", Text: "green!"})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// Missing lists, tasks and comments; fail.
+	_, err = store.AddComment("3ee98ee7-e5b1-4a7a-9d8e-51ed4e6ad3f4", fence.ID, Comment{Author: "ada", Text: "hello"})
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.GetComments(home.ID, "3ee98ee7-e5b1-4a7a-9d8e-51ed4e6ad3f4")
+	assert.ErrorIs(t, err, ErrNotFound)
+	err = store.DeleteComment(home.ID, car.ID, first.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Delete the first comment.
+	err = store.DeleteComment(home.ID, fence.ID, first.ID)
+	assert.Nil(t, err)
+	comments, err = store.GetComments(home.ID, fence.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []Comment{second}, comments)
+
+	// The next occurrence of a recurring task starts a fresh thread.
+	due := time.Date(2026, 5, 8, 9, 0, 0, 0, time.UTC)
+	chore, err := store.AddTask(home.ID, Task{Name: "water the plants", Due: &due, Recurrence: "FREQ=WEEKLY"})
+	assert.Nil(t, err)
+	_, err = store.AddComment(home.ID, chore.ID, Comment{Author: "ada", Text: "the ferns too"})
+	assert.Nil(t, err)
+	err = store.SetCompleted(home.ID, chore.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(home.ID, ListOptions{Sort: SortPosition, Comments: true})
+	assert.Nil(t, err)
+	require.Equal(t, 4, len(actuallist.Tasks))
+	assert.Equal(t, 1, len(actuallist.Tasks[2].Comments))
+	assert.Nil(t, actuallist.Tasks[3].Comments)
+}
+
+func TestDeleteList(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list.
+	newlist := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false}},
+	}
+
+	// Add this list; succeeds.
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
+
+	// Delete it; succeeds.
+	err = store.DeleteList("d290f1ee-6c54-4b01-90e6-d701748f0851")
+	assert.Nil(t, err)
+
+	// It's gone.
+	_, err = store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Delete it again; fails.
+	err = store.DeleteList("d290f1ee-6c54-4b01-90e6-d701748f0851")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Delete a list with an invalid ID; fails.
+	err = store.DeleteList("This is not a valid UUID")
+	assert.ErrorIs(t, err, ErrInvalidID)
+
+	// The ID can be reused.
+	_, err = store.AddList(newlist)
+	assert.Nil(t, err)
+}
+
+func TestDeleteTask(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy list.
+	newlist := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the yard", Completed: false},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "wash the car", Completed: false},
+		},
+	}
+
+	// Add this list; succeeds.
+	_, err := store.AddList(newlist)
+	assert.Nil(t, err)
+
+	// Delete a task; succeeds.
+	err = store.DeleteTask("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae580")
+	assert.Nil(t, err)
+
+	// Check list values.
+	newlist.Tasks = newlist.Tasks[1:]
+	actuallist, err := store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, newlist, unversioned(actuallist))
+
+	// Delete it again; fails.
+	err = store.DeleteTask("d290f1ee-6c54-4b01-90e6-d701748f0851", "0e2ac84f-f723-4f24-878b-44e63e7ae580")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Delete a task from an invalid list; fails.
+	err = store.DeleteTask("d290f1ee-6c54-4b01-90e6-d701748f0852", "0e2ac84f-f723-4f24-878b-44e63e7ae581")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Delete a task with an invalid ID; fails.
+	err = store.DeleteTask("d290f1ee-6c54-4b01-90e6-d701748f0851", "This is not a valid UUID")
+	assert.ErrorIs(t, err, ErrInvalidID)
 }
 
 func TestGetList(t *testing.T) {
+	store := NewMemoryStore()
+
 	// Getting lists successfully is covered by other tests.
 
 	// Get a non-existent list; fails.
-	_, status := GetList("d290f1ee-6c54-4b01-90e6-d701748f0851")
-	assert.Equal(t, http.StatusNotFound, status)
+	_, err := store.GetList("d290f1ee-6c54-4b01-90e6-d701748f0851", ListOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
 
 	// Get a list with an invalid ID; fails.
-	_, status = GetList("This is not a valid UUID")
-	assert.Equal(t, http.StatusBadRequest, status)
+	_, err = store.GetList("This is not a valid UUID", ListOptions{})
+	assert.ErrorIs(t, err, ErrInvalidID)
+}
+
+func TestGetListPage(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Add a list with some tasks, some of them completed and tagged.
+	newlist, err := store.AddList(TodoList{ID: "d290f1ee-6c54-4b01-90e6-d701748f0851", Name: "Home", Tasks: []Task{
+		{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "Clean the gutters", Tags: []string{"outside"}},
+		{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "Clean the oven", Completed: true},
+		{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "Mow the yard", Tags: []string{"outside"}},
+		{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae584", Name: "Paint the fence", Tags: []string{"outside"}},
+		{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae585", Name: "Wash the car", Completed: true, Tags: []string{"outside"}},
+	}})
+	assert.Nil(t, err)
+	tasks := newlist.Tasks
+
+	// Get the whole list; the counts come with it.
+	response, next, err := store.GetListPage(newlist.ID, nil, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, tasks, response.Tasks)
+	assert.Equal(t, &TaskCounts{Total: 5, Filtered: 5}, response.Counts)
+	assert.Nil(t, next)
+
+	// Page through the incomplete tasks outside.
+	options := ListOptions{Filter: TaskFilter{Completed: new(bool), Tags: []string{"outside"}}}
+	response, next, err = store.GetListPage(newlist.ID, nil, 2, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{tasks[0], tasks[2]}, response.Tasks)
+	assert.Equal(t, &TaskCounts{Total: 5, Filtered: 3}, response.Counts)
+	require.NotNil(t, next)
+	assert.Equal(t, "Mow the yard", next.Name)
+	assert.Equal(t, tasks[2].ID, next.ID)
+
+	// A task removed before the cursor doesn't shift the next page.
+	err = store.DeleteTask(newlist.ID, tasks[0].ID)
+	assert.Nil(t, err)
+	response, next, err = store.GetListPage(newlist.ID, next, 2, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{tasks[3]}, response.Tasks)
+	assert.Equal(t, &TaskCounts{Total: 4, Filtered: 2}, response.Counts)
+	assert.Nil(t, next)
+
+	// Filter by name, in other orders.
+	response, next, err = store.GetListPage(newlist.ID, nil, 1, ListOptions{Sort: "-name", Filter: TaskFilter{Name: "THE O"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{tasks[1]}, response.Tasks)
+	assert.Equal(t, &TaskCounts{Total: 4, Filtered: 1}, response.Counts)
+	assert.Nil(t, next)
+	response, next, err = store.GetListPage(newlist.ID, nil, 2, ListOptions{Sort: "-completed", Filter: TaskFilter{Name: "a"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{tasks[1], tasks[4]}, response.Tasks)
+	require.NotNil(t, next)
+	assert.True(t, next.Completed)
+	response, next, err = store.GetListPage(newlist.ID, next, 2, ListOptions{Sort: "-completed", Filter: TaskFilter{Name: "a"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []Task{tasks[2], tasks[3]}, response.Tasks)
+	assert.Nil(t, next)
+
+	// Bad parameters; fail.
+	var verr *ValidationError
+	_, _, err = store.GetListPage(newlist.ID, &TaskCursor{Name: "Mow the yard", ID: "elsewhere"}, 2, ListOptions{})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "cursor", verr.Fields[0].Field)
+	_, _, err = store.GetListPage(newlist.ID, &TaskCursor{Priority: "whenever", ID: tasks[1].ID}, 2, ListOptions{})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "cursor", verr.Fields[0].Field)
+	_, _, err = store.GetListPage(newlist.ID, nil, -1, ListOptions{})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "limit", verr.Fields[0].Field)
+	_, _, err = store.GetListPage(newlist.ID, nil, 2, ListOptions{Tree: true})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "view", verr.Fields[0].Field)
+	_, _, err = store.GetListPage("d290f1ee-6c54-4b01-90e6-d701748f0852", nil, 2, ListOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
 }
 
 func TestGetLists(t *testing.T) {
+	store := NewMemoryStore()
+
 	// An initial UUID.
 	id, _ := uuid.Parse("d290f1ee-6c54-4b01-90e6-d701748f0851")
 
 	// Dummy list #1.
 	homelist := TodoList{
-		id.String(),
-		"Home",
-		"The list of things that need to be done at home\n",
-		[]Task{},
+		ID:          id.String(),
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{},
 	}
 	id[15]++ // Increment UUID; remains unique in the scope of this test
 
 	// Dummy list #2.
 	worklist := TodoList{
-		id.String(),
-		"Work",
-		"The list of things that need to be done at work\n",
-		[]Task{},
+		ID:          id.String(),
+		Name:        "Work",
+		Description: "The list of things that need to be done at work\n",
+		Tasks:       []Task{},
 	}
 	id[15]++
 
 	// Add these lists; succeeds.
-	status := AddList(homelist)
-	assert.Equal(t, http.StatusCreated, status)
-	status = AddList(worklist)
-	assert.Equal(t, http.StatusCreated, status)
+	_, err := store.AddList(homelist)
+	assert.Nil(t, err)
+	_, err = store.AddList(worklist)
+	assert.Nil(t, err)
 
 	// Retrieve these lists; succeeds.
-	response, status := GetLists("", 0, 0)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, []TodoList{homelist, worklist}, response)
+	response, err := store.GetLists("", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{homelist, worklist}, unversioned(response))
 
 	// Retrieve the home list; succeeds.
-	response, status = GetLists("Home", 0, 0)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, []TodoList{homelist}, response)
+	response, err = store.GetLists("Home", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{homelist}, unversioned(response))
 
 	// Retrieve the work list; succeeds.
-	response, status = GetLists("Work", 0, 0)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, []TodoList{worklist}, response)
-
-	// Retrieve all lists containing an O; succeeds.
-	// (Yes, this is kind of a lame search.  I'd try to leverage the underlying
-	// database in a more sophisticated service, but for the sake of showing my
-	// ideas quickly, here we are.)
-	response, status = GetLists("O", 0, 0)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, []TodoList{homelist, worklist}, response)
-
-	// Retrieve a first page of lists containing an O; succeeds.
-	response, status = GetLists("O", 0, 1)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, []TodoList{homelist}, response)
-
-	// Retrieve a second page of lists containing an O; succeeds.
-	response, status = GetLists("O", 1, 1)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, []TodoList{worklist}, response)
-
-	// Retrieve a third page of lists containing an O; succeeds but is empty.
-	response, status = GetLists("O", 2, 1)
-	assert.Equal(t, http.StatusOK, status)
+	response, err = store.GetLists("Work", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{worklist}, unversioned(response))
+
+	// Retrieve all lists with things to do; succeeds.  They match equally
+	// well, so they come in order of name.
+	response, err = store.GetLists("things", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{homelist, worklist}, unversioned(response))
+
+	// Retrieve a first page of lists with things to do; succeeds.
+	response, err = store.GetLists("things", 0, 1, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{homelist}, unversioned(response))
+
+	// Retrieve a second page of lists with things to do; succeeds.
+	response, err = store.GetLists("things", 1, 1, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{worklist}, unversioned(response))
+
+	// Retrieve a third page of lists with things to do; succeeds but is
+	// empty.
+	response, err = store.GetLists("things", 2, 1, ListOptions{})
+	assert.Nil(t, err)
 	assert.Equal(t, []TodoList{}, response)
 
 	// Add a bunch of additional lists.
@@ -223,32 +1562,886 @@ func TestGetLists(t *testing.T) {
 	worklists := []TodoList{worklist}
 	for i := 0; i < 5; i++ {
 		homelist.ID = id.String()
-		status = AddList(homelist)
-		assert.Equal(t, http.StatusCreated, status)
+		_, err = store.AddList(homelist)
+		assert.Nil(t, err)
 		homelists = append(homelists, homelist)
 		id[15]++
 
 		worklist.ID = id.String()
-		status = AddList(worklist)
-		assert.Equal(t, http.StatusCreated, status)
+		_, err = store.AddList(worklist)
+		assert.Nil(t, err)
 		worklists = append(worklists, worklist)
 		id[15]++
 	}
 
 	// Retrieve all home lists; succeeds.
-	response, status = GetLists("Home", 0, 0)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, homelists, response)
+	response, err = store.GetLists("Home", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, homelists, unversioned(response))
 
 	// Retrieve all work lists; succeeds.
-	response, status = GetLists("Work", 0, 0)
-	assert.Equal(t, http.StatusOK, status)
-	assert.Equal(t, worklists, response)
+	response, err = store.GetLists("Work", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, worklists, unversioned(response))
 
 	// Pass bad parameters; fails.
-	response, status = GetLists("", -1, -1)
-	assert.Equal(t, http.StatusBadRequest, status)
+	_, err = store.GetLists("", -1, -1, ListOptions{})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestGetListsAfter(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// Add some lists, two of them with the same name.
+	lists := []TodoList{
+		{ID: "d290f1ee-6c54-4b01-90e6-d701748f0851", Name: "Chores", Description: "Around the house", Tasks: []Task{}},
+		{ID: "d290f1ee-6c54-4b01-90e6-d701748f0852", Name: "Errands", Tasks: []Task{}},
+		{ID: "d290f1ee-6c54-4b01-90e6-d701748f0853", Name: "Errands", Tasks: []Task{}},
+		{ID: "d290f1ee-6c54-4b01-90e6-d701748f0854", Name: "House", Tasks: []Task{}},
+		{ID: "d290f1ee-6c54-4b01-90e6-d701748f0855", Name: "Work", Tasks: []Task{}},
+	}
+	for _, l := range lists {
+		_, err := store.AddList(l)
+		assert.Nil(t, err)
+	}
+
+	// Page through them two at a time.
+	response, next, err := store.GetListsAfter("", nil, 2, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, lists[:2], unversioned(response))
+	assert.Equal(t, &ListCursor{Name: "Errands", Created: now, Updated: now, ID: lists[1].ID}, next)
+	response, next, err = store.GetListsAfter("", next, 2, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, lists[2:4], unversioned(response))
+
+	// A list added before the cursor doesn't shift the last page, and one
+	// removed before it doesn't either.
+	_, err = store.AddList(TodoList{ID: "d290f1ee-6c54-4b01-90e6-d701748f0856", Name: "Birthdays", Tasks: []Task{}})
+	assert.Nil(t, err)
+	err = store.DeleteList(lists[0].ID)
+	assert.Nil(t, err)
+	response, last, err := store.GetListsAfter("", next, 2, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, lists[4:], unversioned(response))
+	assert.Nil(t, last)
+
+	// Without a limit there's just the one page.
+	response, next, err = store.GetListsAfter("", nil, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(response))
+	assert.Nil(t, next)
+
+	// Ranked searches page by score first, and lists with the same score
+	// and name by ID.
+	response, next, err = store.GetListsAfter("errands", nil, 1, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{lists[1]}, unversioned(response))
+	assert.Equal(t, &ListCursor{Score: 8, Name: "Errands", Created: now, Updated: now, ID: lists[1].ID}, next)
+	response, next, err = store.GetListsAfter("errands", next, 1, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{lists[2]}, unversioned(response))
+	assert.Nil(t, next)
+
+	// Bad parameters; fail.
+	var verr *ValidationError
+	_, _, err = store.GetListsAfter("", &ListCursor{Name: "House", ID: "elsewhere"}, 2, ListOptions{})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "cursor", verr.Fields[0].Field)
+	_, _, err = store.GetListsAfter("", nil, -1, ListOptions{})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "limit", verr.Fields[0].Field)
+}
+
+func TestSort(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	names := func(lists []TodoList) []string {
+		result := []string{}
+		for _, l := range lists {
+			result = append(result, l.Name)
+		}
+		return result
+	}
+	taskNames := func(l TodoList) []string {
+		result := []string{}
+		for _, t := range l.Tasks {
+			result = append(result, t.Name)
+		}
+		return result
+	}
+
+	// Add some lists, with some of their tasks completed.
+	groceries, err := store.AddList(TodoList{Name: "Groceries", Tasks: []Task{{Name: "buy milk", Completed: true}, {Name: "buy eggs"}}})
+	assert.Nil(t, err)
+	chores, err := store.AddList(TodoList{Name: "Chores", Tasks: []Task{{Name: "sweep", Completed: true}, {Name: "dust", Completed: true}, {Name: "mop"}}})
+	assert.Nil(t, err)
+	errands, err := store.AddList(TodoList{Name: "Errands"})
+	assert.Nil(t, err)
+	_, err = store.AddTask(groceries.ID, Task{Name: "buy bread"})
+	assert.Nil(t, err)
+	err = store.SetCompleted(chores.ID, chores.Tasks[1].ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+
+	// Sort the lists by each key.
+	for _, c := range []struct {
+		sort  string
+		names []string
+	}{
+		{"", []string{"Chores", "Errands", "Groceries"}},
+		{"-completed,name", []string{"Chores", "Groceries", "Errands"}},
+		{"completed", []string{"Errands", "Groceries", "Chores"}},
+		{"tasks", []string{"Errands", "Chores", "Groceries"}},
+		{"-tasks,-name", []string{"Groceries", "Chores", "Errands"}},
+		{"created", []string{"Groceries", "Chores", "Errands"}},
+		{"-updated", []string{"Chores", "Groceries", "Errands"}},
+	} {
+		response, err := store.GetLists("", 0, 0, ListOptions{ListSort: c.sort})
+		assert.Nil(t, err)
+		assert.Equal(t, c.names, names(response), c.sort)
+	}
+
+	// Page through the lists in an order, with a list moving ahead of the
+	// cursor in between; it isn't seen again.
+	response, next, err := store.GetListsAfter("", nil, 1, ListOptions{ListSort: "-updated"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Chores"}, names(response))
+	require.NotNil(t, next)
+	description := "On the way home"
+	_, err = store.UpdateList(errands.ID, TodoListPatch{Description: &description})
+	assert.Nil(t, err)
+	response, next, err = store.GetListsAfter("", next, 2, ListOptions{ListSort: "-updated"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Groceries"}, names(response))
+	assert.Nil(t, next)
+
+	// Sort the tasks by each key.
+	priority := PriorityHigh
+	_, err = store.UpdateTask(groceries.ID, groceries.Tasks[1].ID, TaskPatch{Priority: &priority})
+	assert.Nil(t, err)
+	for _, c := range []struct {
+		sort  string
+		names []string
+	}{
+		{"", []string{"buy bread", "buy eggs", "buy milk"}},
+		{"-completed,name", []string{"buy milk", "buy bread", "buy eggs"}},
+		{"-created", []string{"buy bread", "buy eggs", "buy milk"}},
+		{"updated", []string{"buy milk", "buy bread", "buy eggs"}},
+		{"priority", []string{"buy eggs", "buy bread", "buy milk"}},
+		{"-priority", []string{"buy bread", "buy milk", "buy eggs"}},
+	} {
+		l, err := store.GetList(groceries.ID, ListOptions{Sort: c.sort})
+		assert.Nil(t, err)
+		assert.Equal(t, c.names, taskNames(l), c.sort)
+	}
+
+	// The tasks in GetLists too.
+	response, err = store.GetLists("groceries", 0, 0, ListOptions{Sort: "-completed,name"})
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(response))
+	assert.Equal(t, []string{"buy milk", "buy bread", "buy eggs"}, taskNames(response[0]))
+
+	// Bad orders; fail.
+	var verr *ValidationError
+	_, err = store.GetLists("", 0, 0, ListOptions{ListSort: "size"})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "sort", verr.Fields[0].Field)
+	_, err = store.GetLists("", 0, 0, ListOptions{ListSort: "name,-name"})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "sort", verr.Fields[0].Field)
+	_, err = store.GetLists("", 0, 0, ListOptions{Sort: "tasks"})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "taskSort", verr.Fields[0].Field)
+	_, err = store.GetList(groceries.ID, ListOptions{Sort: "relevance"})
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "sort", verr.Fields[0].Field)
+}
+
+func TestVersions(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Add a list, and then a task; the task and the list it changed get the
+	// new version, and the other task keeps its own.
+	newlist, err := store.AddList(TodoList{Name: "Home", Tasks: []Task{{Name: "mow the yard"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), newlist.Version)
+	assert.Equal(t, uint64(1), newlist.Tasks[0].Version)
+	yard := newlist.Tasks[0]
+	car, err := store.AddTask(newlist.ID, Task{Name: "wash the car"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), car.Version)
+	actuallist, err := store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), actuallist.Version)
+	assert.Equal(t, []Task{yard, car}, actuallist.Tasks)
+
+	// Complete the task at a version it has moved on from, fails, and changes
+	// nothing.
+	err = store.IfVersion(1).SetCompleted(newlist.ID, car.ID, CompletedTask{Completed: true})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	assert.EqualError(t, err, "task "+car.ID+" is at version 2: version mismatch")
+	actuallist, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.False(t, actuallist.Tasks[1].Completed)
+
+	// At its version, succeeds.  The other task is at an older version, but
+	// that doesn't matter.
+	err = store.IfVersion(car.Version).SetCompleted(newlist.ID, car.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	moved, err := store.IfVersion(yard.Version).MoveTask(newlist.ID, yard.ID, TaskMove{Before: car.ID})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), moved.Version)
+
+	// A comment changes the list's version, but not the task's.
+	_, err = store.AddComment(newlist.ID, car.ID, Comment{Author: "ada", Text: "the wax too"})
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), actuallist.Version)
+	assert.Equal(t, uint64(3), actuallist.Tasks[1].Version)
+
+	// Changes to the comments check the task's version.
+	_, err = store.IfVersion(2).AddComment(newlist.ID, car.ID, Comment{Author: "bob", Text: "and the tyres"})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	comments, err := store.GetComments(newlist.ID, car.ID)
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(comments))
+	text := "the wax and the polish"
+	_, err = store.IfVersion(2).UpdateComment(newlist.ID, car.ID, comments[0].ID, CommentPatch{Text: &text})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	err = store.IfVersion(2).DeleteComment(newlist.ID, car.ID, comments[0].ID)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	_, err = store.IfVersion(3).UpdateComment(newlist.ID, car.ID, comments[0].ID, CommentPatch{Text: &text})
+	assert.Nil(t, err)
+
+	// Changes to the list check its version.  Any of several versions may
+	// match, and none at all never does.
+	name := "House"
+	_, err = store.IfVersion(5).UpdateList(newlist.ID, TodoListPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	_, err = store.IfVersion(5, 6).UpdateList(newlist.ID, TodoListPatch{Name: &name})
+	assert.Nil(t, err)
+	_, err = store.IfVersion().AddTask(newlist.ID, Task{Name: "fix the gate"})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	err = store.IfVersion(6).DeleteList(newlist.ID)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	// Changes to a task check its version.
+	_, err = store.IfVersion(3).UpdateTask(newlist.ID, yard.ID, TaskPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	err = store.IfVersion(3).DeleteTask(newlist.ID, yard.ID)
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+	err = store.IfVersion(4).DeleteTask(newlist.ID, yard.ID)
+	assert.Nil(t, err)
+
+	// Missing lists and tasks are still not found.
+	err = store.IfVersion(1).DeleteTask(newlist.ID, yard.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Delete the list and add it again; the versions keep increasing.
+	err = store.IfVersion(8).DeleteList(newlist.ID)
+	assert.Nil(t, err)
+	newlist, err = store.AddList(TodoList{ID: newlist.ID, Name: "Home"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), newlist.Version)
+}
+
+func TestBlockerVersions(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// Add a list, and another with a task blocked by one in the first.
+	home, err := store.AddList(TodoList{Name: "Home", Tasks: []Task{{Name: "buy paint"}}})
+	assert.Nil(t, err)
+	paint := home.Tasks[0]
+	work, err := store.AddList(TodoList{Name: "Work", Tasks: []Task{{Name: "paint the office", BlockedBy: []TaskRef{{List: home.ID, Task: paint.ID}}}}})
+	assert.Nil(t, err)
+	office := work.Tasks[0]
+	assert.Equal(t, uint64(2), work.Version)
+
+	// Completing the blocker changes the other list's version and time, but
+	// not the blocked task's.
+	now = now.Add(time.Hour)
+	err = store.SetCompleted(home.ID, paint.ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	info, err := store.GetListVersion(work.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 3, Modified: now}, info)
+	actual, err := store.GetList(work.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), actual.Version)
+	assert.False(t, actual.Tasks[0].Blocked)
+	assert.Equal(t, uint64(2), actual.Tasks[0].Version)
+
+	// A batch which reopens it and then fails leaves the version as it was.
+	reopen := false
+	_, err = store.Batch([]Operation{
+		{Op: "updateTask", ID: home.ID, TaskID: paint.ID, TaskPatch: TaskPatch{Completed: &reopen}},
+		{Op: "frobnicate"},
+	})
+	assert.NotNil(t, err)
+	info, err = store.GetListVersion(work.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 3, Modified: now}, info)
+
+	// Reopening it by a patch changes the version again.
+	_, err = store.UpdateTask(home.ID, paint.ID, TaskPatch{Completed: &reopen})
+	assert.Nil(t, err)
+	info, err = store.GetListVersion(work.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), info.Version)
+
+	// Moving the blocker to another list changes the blocked task as well,
+	// so a change made at its old version fails.
+	shop, err := store.AddList(TodoList{Name: "Shop"})
+	assert.Nil(t, err)
+	_, err = store.MoveTask(home.ID, paint.ID, TaskMove{List: shop.ID})
+	assert.Nil(t, err)
+	actual, err = store.GetList(work.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(6), actual.Version)
+	assert.Equal(t, uint64(6), actual.Tasks[0].Version)
+	assert.Equal(t, []TaskRef{{List: shop.ID, Task: paint.ID}}, actual.Tasks[0].BlockedBy)
+	name := "paint the hall"
+	_, err = store.IfVersion(office.Version).UpdateTask(work.ID, office.ID, TaskPatch{Name: &name})
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+
+	// So does deleting the blocker's list, which unblocks it.
+	now = now.Add(time.Hour)
+	err = store.DeleteList(shop.ID)
+	assert.Nil(t, err)
+	actual, err = store.GetList(work.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(7), actual.Version)
+	assert.Equal(t, uint64(7), actual.Tasks[0].Version)
+	assert.Nil(t, actual.Tasks[0].BlockedBy)
+	info, err = store.GetListVersion(work.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 7, Modified: now}, info)
+}
+
+func TestVersionInfo(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// Add two lists, one with a task due soon.
+	due := now.Add(time.Hour)
+	home, err := store.AddList(TodoList{Name: "Home", Tasks: []Task{{Name: "pay the rent", Due: &due}}})
+	assert.Nil(t, err)
+	work, err := store.AddList(TodoList{Name: "Work"})
+	assert.Nil(t, err)
+	info, err := store.GetListVersion(home.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 1, Modified: now}, info)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 2, Modified: now}, info)
+
+	// Time passes, and the task falls overdue, which changes the info even
+	// though nothing was changed.
+	now = now.Add(2 * time.Hour)
+	info, err = store.GetListVersion(home.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 1, Overdue: 1, Modified: due}, info)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 2, Overdue: 1, Modified: due}, info)
+
+	// Deleting a list changes the store's info, but not the other list's.
+	err = store.DeleteList(work.ID)
+	assert.Nil(t, err)
+	info, err = store.GetListVersion(home.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 1, Overdue: 1, Modified: due}, info)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 3, Overdue: 1, Modified: now}, info)
+
+	// So does a comment, which changes the list's.
+	_, err = store.AddComment(home.ID, home.Tasks[0].ID, Comment{Author: "ada", Text: "paid"})
+	assert.Nil(t, err)
+	info, err = store.GetListVersion(home.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 4, Overdue: 1, Modified: now}, info)
+
+	// The store keeps count of its overdue tasks as they're added, changed
+	// and completed, and as time passes.
+	soon, later, latest := now.Add(time.Hour), now.Add(2*time.Hour), now.Add(90*time.Minute)
+	gas, err := store.AddTask(home.ID, Task{Name: "buy gas", Due: &soon})
+	assert.Nil(t, err)
+	milk, err := store.AddTask(home.ID, Task{Name: "buy milk", Due: &later})
+	assert.Nil(t, err)
+	_, err = store.UpdateTask(home.ID, milk.ID, TaskPatch{Due: &latest})
+	assert.Nil(t, err)
+	err = store.SetCompleted(home.ID, home.Tasks[0].ID, CompletedTask{Completed: true})
+	assert.Nil(t, err)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 8, Modified: now}, info)
+	now = now.Add(3 * time.Hour)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 8, Overdue: 2, Modified: latest}, info)
+
+	// A batch which fails leaves the count as it was.
+	_, err = store.Batch([]Operation{{Op: "deleteTask", ID: home.ID, TaskID: gas.ID}, {Op: "frobnicate"}})
+	assert.NotNil(t, err)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 8, Overdue: 2, Modified: latest}, info)
+
+	// If the clock goes back, the tasks are counted as of then.
+	now = soon.Add(15 * time.Minute)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 8, Overdue: 1, Modified: soon}, info)
+	now = soon.Add(2 * time.Hour)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 8, Overdue: 2, Modified: latest}, info)
+
+	// Deleting an overdue task takes it off the count.
+	err = store.DeleteTask(home.ID, gas.ID)
+	assert.Nil(t, err)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 9, Overdue: 1, Modified: now}, info)
+
+	// Missing and malformed lists fail.
+	_, err = store.GetListVersion(work.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = store.GetListVersion("42")
+	assert.ErrorIs(t, err, ErrInvalidID)
+}
+
+func TestBatch(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// Add a list, and another with a task blocked by one in the first.
+	home, err := store.AddList(TodoList{Name: "Home", Tasks: []Task{{Name: "mow the yard"}, {Name: "wash the car"}}})
+	assert.Nil(t, err)
+	yard, car := home.Tasks[0], home.Tasks[1]
+	work, err := store.AddList(TodoList{Name: "Work", Tasks: []Task{{Name: "leave early", BlockedBy: []TaskRef{{home.ID, yard.ID}}}}})
+	assert.Nil(t, err)
+	early := work.Tasks[0]
+
+	// Make several changes in a batch.  Each sees the ones before it, and
+	// they all get the same version.
+	garden := "9b3c8f2e-5d1a-4c6b-8e7f-0a1b2c3d4e5f"
+	name := "House"
+	results, err := store.Batch([]Operation{
+		{Op: "addList", List: TodoList{ID: garden, Name: "Garden"}},
+		{Op: "addTask", ID: garden, Task: Task{Name: "plant the bulbs", Tags: []string{"autumn"}}},
+		{Op: "moveTask", ID: home.ID, TaskID: yard.ID, Move: TaskMove{List: garden}},
+		{Op: "setCompleted", ID: home.ID, TaskID: car.ID, Completed: CompletedTask{Completed: true}},
+		{Op: "updateList", ID: home.ID, ListPatch: TodoListPatch{Name: &name}},
+		{Op: "addComment", ID: garden, TaskID: yard.ID, Comment: Comment{Author: "ada", Text: "before it rains"}},
+	})
+	assert.Nil(t, err)
+	require.Equal(t, 6, len(results))
+	assert.Equal(t, "Garden", results[0].List.Name)
+	bulbs := *results[1].Task
+	assert.Equal(t, uint64(3), bulbs.Version)
+	assert.Equal(t, uint64(3), results[2].Task.Version)
+	assert.Equal(t, OperationResult{}, results[3])
+	assert.Equal(t, "House", results[4].List.Name)
+	assert.Equal(t, "before it rains", results[5].Comment.Text)
+	info, err := store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 3, Modified: now}, info)
+	actuallist, err := store.GetList(garden, ListOptions{Sort: SortPosition})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), actuallist.Version)
+	require.Equal(t, 2, len(actuallist.Tasks))
+	assert.Equal(t, "plant the bulbs", actuallist.Tasks[0].Name)
+	assert.Equal(t, "mow the yard", actuallist.Tasks[1].Name)
+	actuallist, err = store.GetList(home.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), actuallist.Version)
+	assert.True(t, actuallist.Tasks[0].Completed)
+
+	// The blocked task followed the move.
+	actuallist, err = store.GetList(work.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TaskRef{{garden, yard.ID}}, actuallist.Tasks[0].BlockedBy)
+
+	// Make some more changes, the last of which fails.  None of them are
+	// made.
+	before, err := store.GetLists("", 0, 0, ListOptions{Comments: true})
+	assert.Nil(t, err)
+	tags, err := store.GetTags()
+	assert.Nil(t, err)
+	then := now
+	now = now.Add(time.Hour)
+	_, err = store.Batch([]Operation{
+		{Op: "deleteTask", ID: garden, TaskID: yard.ID},
+		{Op: "moveTask", ID: garden, TaskID: bulbs.ID, Move: TaskMove{List: home.ID}},
+		{Op: "addList", List: TodoList{Name: "Errands", Tags: []string{"town"}}},
+		{Op: "updateTask", ID: work.ID, TaskID: early.ID, TaskPatch: TaskPatch{Name: &name}},
+		{Op: "deleteList", ID: home.ID},
+		{Op: "deleteComment", ID: garden, TaskID: yard.ID, CommentID: results[5].Comment.ID},
+	})
+	var batchErr *BatchError
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 5, batchErr.Index)
+	assert.ErrorIs(t, err, ErrNotFound)
+	actuallists, err := store.GetLists("", 0, 0, ListOptions{Comments: true})
+	assert.Nil(t, err)
+	assert.Equal(t, before, actuallists)
+	actualtags, err := store.GetTags()
+	assert.Nil(t, err)
+	assert.Equal(t, tags, actualtags)
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, VersionInfo{Version: 3, Modified: then}, info)
+
+	// The indexes are as they were too: searches and filters find what they
+	// did, and deleting the blocker still unblocks the blocked task.
+	actuallists, err = store.GetLists("yard", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(actuallists))
+	assert.Equal(t, garden, actuallists[0].ID)
+	actuallists, err = store.GetLists("errands", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(actuallists))
+	tasks, err := store.GetTasks(TaskFilter{Tags: []string{"autumn"}})
+	assert.Nil(t, err)
+	require.Equal(t, 1, len(tasks))
+	assert.Equal(t, bulbs.ID, tasks[0].Task.ID)
+	err = store.DeleteTask(garden, yard.ID)
+	assert.Nil(t, err)
+	actuallist, err = store.GetList(work.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, actuallist.Tasks[0].BlockedBy)
+
+	// The next change gets the next version.
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), info.Version)
+
+	// An unknown operation fails a batch as well.
+	_, err = store.Batch([]Operation{{Op: "addList", List: TodoList{Name: "Errands"}}, {Op: "renameList"}})
+	require.ErrorAs(t, err, &batchErr)
+	assert.Equal(t, 1, batchErr.Index)
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "op", verr.Fields[0].Field)
+
+	// An empty batch does nothing.
+	results, err = store.Batch(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(results))
+	info, err = store.GetListsVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), info.Version)
+}
+
+func TestStats(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// Add a list with tasks done, overdue and neither.
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+	newlist, err := store.AddList(TodoList{Name: "Home", Tasks: []Task{
+		{Name: "mow the yard", Completed: true},
+		{Name: "wash the car", Completed: true, Due: &yesterday},
+		{Name: "pay the rent", Due: &yesterday},
+		{Name: "clean the gutters", Due: &tomorrow},
+		{Name: "paint the fence"},
+		{Name: "fix the gate"},
+	}})
+	assert.Nil(t, err)
+	empty, err := store.AddList(TodoList{Name: "Work"})
+	assert.Nil(t, err)
+
+	// The stats cover all of the tasks, whatever the filter.
+	response, err := store.GetList(newlist.ID, ListOptions{Stats: true, Filter: TaskFilter{Completed: new(bool)}})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(response.Tasks))
+	assert.Equal(t, &ListStats{Total: 6, Completed: 2, PercentDone: 33, Overdue: 1, LastActivity: &now}, response.Stats)
+	response, err = store.GetList(empty.ID, ListOptions{Stats: true})
+	assert.Nil(t, err)
+	assert.Equal(t, &ListStats{LastActivity: &now}, response.Stats)
+
+	// Only if asked for.
+	response, err = store.GetList(newlist.ID, ListOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, response.Stats)
+
+	// Time passes, and the task due tomorrow is overdue too.  A comment is
+	// activity.
+	now = now.AddDate(0, 0, 2)
+	_, err = store.AddComment(newlist.ID, newlist.Tasks[0].ID, Comment{Author: "ada", Text: "too short"})
+	assert.Nil(t, err)
+	response, err = store.GetList(newlist.ID, ListOptions{Stats: true})
+	assert.Nil(t, err)
+	assert.Equal(t, &ListStats{Total: 6, Completed: 2, PercentDone: 33, Overdue: 2, LastActivity: &now}, response.Stats)
+
+	// Completing every task is 100%.
+	for _, task := range newlist.Tasks {
+		err = store.SetCompleted(newlist.ID, task.ID, CompletedTask{Completed: true})
+		assert.Nil(t, err)
+	}
+	response, err = store.GetList(newlist.ID, ListOptions{Stats: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, response.Stats.PercentDone)
+	assert.Equal(t, 0, response.Stats.Overdue)
+
+	// A summary leaves out the tasks, but the filter still picks the lists.
+	lists, err := store.GetLists("", 0, 0, ListOptions{Stats: true, Summary: true})
+	assert.Nil(t, err)
+	require.Equal(t, 2, len(lists))
+	assert.Nil(t, lists[0].Tasks)
+	assert.Equal(t, 6, lists[0].Stats.Total)
+	lists, err = store.GetLists("", 0, 0, ListOptions{Summary: true, Filter: TaskFilter{Completed: new(bool)}})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(lists))
+}
+
+func TestSearch(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy lists, with the word "garden" in different places.
+	home := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "Around the house and the garden",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "mow the lawn"},
+		},
+	}
+	garden := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0852",
+		Name: "Garden",
+	}
+	shopping := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0853",
+		Name: "Shopping",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "buy gardening gloves"},
+		},
+	}
+	for _, l := range []TodoList{home, garden, shopping} {
+		_, err := store.AddList(l)
+		assert.Nil(t, err)
+	}
+	names := func(lists []TodoList) []string {
+		result := []string{}
+		for _, l := range lists {
+			result = append(result, l.Name)
+		}
+		return result
+	}
+
+	// Search for the word; matches in names rank above those in task names,
+	// and those above descriptions, and whole words above prefixes.
+	response, err := store.GetLists("GARDEN", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Garden", "Home", "Shopping"}, names(response))
+	response, err = store.GetLists("gard", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Garden", "Shopping", "Home"}, names(response))
+
+	// Report which fields matched.
+	response, err = store.GetLists("garden", 0, 0, ListOptions{Matches: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"name"}, response[0].Matches)
+	assert.Equal(t, []string{"description"}, response[1].Matches)
+	assert.Equal(t, []string{"tasks"}, response[2].Matches)
+
+	// Every word must match, though not necessarily in the same field.
+	response, err = store.GetLists("garden, lawn!", 0, 0, ListOptions{Matches: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Home"}, names(response))
+	assert.Equal(t, []string{"description", "tasks"}, response[0].Matches)
+
+	// Words only match at their beginning.
+	response, err = store.GetLists("arden", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{}, response)
+
+	// A search term without words; fails.
+	_, err = store.GetLists("garden !?", 0, 0, ListOptions{})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, []FieldError{{Field: "searchString", Message: "must contain a letter or digit", Position: 8}}, verr.Fields)
+
+	// The index follows renamed lists and tasks.
+	name := "Allotment"
+	_, err = store.UpdateList(garden.ID, TodoListPatch{Name: &name})
+	assert.Nil(t, err)
+	name = "buy work gloves"
+	_, err = store.UpdateTask(shopping.ID, shopping.Tasks[0].ID, TaskPatch{Name: &name})
+	assert.Nil(t, err)
+	response, err = store.GetLists("garden", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Home"}, names(response))
+	response, err = store.GetLists("allot", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Allotment"}, names(response))
+
+	// And tasks added, moved and deleted.
+	seeds, err := store.AddTask(shopping.ID, Task{Name: "buy seeds"})
+	assert.Nil(t, err)
+	response, err = store.GetLists("seeds", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Shopping"}, names(response))
+	_, err = store.MoveTask(shopping.ID, seeds.ID, TaskMove{List: garden.ID})
+	assert.Nil(t, err)
+	response, err = store.GetLists("seeds", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Allotment"}, names(response))
+	err = store.DeleteTask(garden.ID, seeds.ID)
+	assert.Nil(t, err)
+	response, err = store.GetLists("seeds", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{}, response)
+
+	// And lists deleted.
+	err = store.DeleteList(home.ID)
+	assert.Nil(t, err)
+	response, err = store.GetLists("lawn", 0, 0, ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{}, response)
+
+	// Searches combine with task filters.
+	response, err = store.GetLists("buy", 0, 0, ListOptions{Filter: TaskFilter{Completed: new(bool)}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Shopping"}, names(response))
+}
+
+func TestSearchQuery(t *testing.T) {
+	store := NewMemoryStore()
+
+	// Dummy lists.
+	due := time.Date(2026, 10, 30, 17, 0, 0, 0, time.UTC)
+	later := time.Date(2026, 11, 20, 17, 0, 0, 0, time.UTC)
+	groceries := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Groceries",
+		Description: "Weekly shop for the house",
+		Tags:        []string{"home"},
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae580", Name: "buy milk", Due: &due},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae581", Name: "buy fresh bread", Due: &later, Priority: PriorityHigh},
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae582", Name: "buy eggs", Completed: true},
+		},
+	}
+	party := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0852",
+		Name:        "Party groceries",
+		Description: "Bread and circuses",
+		Tasks: []Task{
+			Task{ID: "0e2ac84f-f723-4f24-878b-44e63e7ae583", Name: "bread rolls", Tags: []string{"bakery"}},
+		},
+	}
+	work := TodoList{
+		ID:   "d290f1ee-6c54-4b01-90e6-d701748f0853",
+		Name: "Work",
+		Tags: []string{"office"},
+	}
+	for _, l := range []TodoList{groceries, party, work} {
+		_, err := store.AddList(l)
+		assert.Nil(t, err)
+	}
+	milk, bread, eggs := groceries.Tasks[0], groceries.Tasks[1], groceries.Tasks[2]
+	bread.Priority = PriorityHigh
+	search := func(text string) []TodoList {
+		response, err := store.GetLists(text, 0, 0, ListOptions{})
+		assert.Nil(t, err, text)
+		return response
+	}
+	names := func(lists []TodoList) []string {
+		result := []string{}
+		for _, l := range lists {
+			result = append(result, l.Name)
+		}
+		return result
+	}
+
+	// Fields and negation.
+	assert.Equal(t, []string{"Groceries", "Party groceries"}, names(search("name:groceries")))
+	assert.Equal(t, []string{"Groceries"}, names(search("groceries -party")))
+	assert.Equal(t, []string{"Party groceries"}, names(search("description:bread")))
+	assert.Equal(t, []string{"Groceries", "Work"}, names(search("-description:circus -nothing")))
+	assert.Equal(t, []string{}, names(search("name:shop")))
+
+	// Phrases need whole words, in order.
+	assert.Equal(t, []string{"Groceries"}, names(search(`"fresh bread"`)))
+	assert.Equal(t, []string{"Groceries"}, names(search("fresh-bread")))
+	assert.Equal(t, []string{}, names(search(`"bread fresh"`)))
+	assert.Equal(t, []string{}, names(search(`"fresh brea"`)))
+	assert.Equal(t, []string{"Party groceries"}, names(search(`bread -"fresh bread"`)))
+
+	// Task terms select tasks, and the lists with any.
+	response := search("task:buy completed:false")
+	assert.Equal(t, []TodoList{{ID: groceries.ID, Name: groceries.Name, Description: groceries.Description, Tags: groceries.Tags, Tasks: []Task{bread, milk}}}, unversioned(response))
+	response = search("completed:true")
+	require.Equal(t, 1, len(response))
+	assert.Equal(t, []Task{eggs}, unversioned(response[0].Tasks))
+	response = search("due:<2026-11-01")
+	require.Equal(t, 1, len(response))
+	assert.Equal(t, []Task{milk}, unversioned(response[0].Tasks))
+	response = search("due:2026-11-20 priority:high")
+	require.Equal(t, 1, len(response))
+	assert.Equal(t, []Task{bread}, unversioned(response[0].Tasks))
+	response = search("groceries -task:buy")
+	require.Equal(t, 1, len(response))
+	assert.Equal(t, "Party groceries", response[0].Name)
+
+	// Tags work as in the filter, including the list's own.
+	assert.Equal(t, []string{"Groceries"}, names(search("tag:home task:milk")))
+	assert.Equal(t, []string{"Work"}, names(search("tag:office")))
+	response = search("-tag:bakery")
+	require.Equal(t, 1, len(response))
+	assert.Equal(t, 3, len(response[0].Tasks))
+
+	// The query combines with the filter.
+	completed := false
+	response, err := store.GetLists("task:eggs", 0, 0, ListOptions{Filter: TaskFilter{Completed: &completed}})
+	assert.Nil(t, err)
+	assert.Equal(t, []TodoList{}, response)
+
+	// A bad query; fails.
+	_, err = store.GetLists("name:groceries due:<tomorrow", 0, 0, ListOptions{})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, 20, verr.Fields[0].Position)
+}
+
+func TestIsolatedStores(t *testing.T) {
+	first := NewMemoryStore()
+	second := NewMemoryStore()
+
+	// Dummy list.
+	newlist := TodoList{
+		ID:          "d290f1ee-6c54-4b01-90e6-d701748f0851",
+		Name:        "Home",
+		Description: "The list of things that need to be done at home\n",
+		Tasks:       []Task{},
+	}
+
+	// Add the list to the first store; succeeds.
+	_, err := first.AddList(newlist)
+	assert.Nil(t, err)
+
+	// The second store doesn't see it.
+	_, err = second.GetList(newlist.ID, ListOptions{})
+	assert.ErrorIs(t, err, ErrNotFound)
 
-	// Teardown.
-	lists = make(listmap)
+	// Adding the same list to the second store doesn't conflict.
+	_, err = second.AddList(newlist)
+	assert.Nil(t, err)
 }
@@ -0,0 +1,239 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// TodoListPatch is a JSON merge patch (RFC 7396) over a TodoList.  Nil fields
+// are left untouched, and tags are replaced as a whole.  The ID can't change,
+// and tasks are changed through their own endpoints.
+type TodoListPatch struct {
+	Name        *string
+	Description *string
+	Tags        *[]string
+}
+
+// TaskPatch is a JSON merge patch (RFC 7396) over a Task.  Nil fields are
+// left untouched, and a zero time or an empty time zone, priority,
+// recurrence or parent removes it.  Tags and blockers are replaced as a
+// whole.  The ID can't change.
+type TaskPatch struct {
+	Name       *string
+	Completed  *bool
+	Start      *time.Time
+	Due        *time.Time
+	TimeZone   *string
+	Priority   *string
+	Recurrence *string
+	Tags       *[]string
+	Parent     *string
+	BlockedBy  *[]TaskRef
+}
+
+// CommentPatch is a JSON merge patch (RFC 7396) over a Comment.  Only the
+// text may change.
+type CommentPatch struct {
+	Text *string
+}
+
+// A merge patch is a JSON object whose members replace the members of the
+// target, with null removing a member.  We decode the members one at a time
+// so that every problem can be reported against its field, rather than
+// stopping at the first one as encoding/json would.
+type patchDecoder struct {
+	members map[string]json.RawMessage
+	errors  []FieldError
+}
+
+func newPatchDecoder(data []byte) (*patchDecoder, error) {
+	d := &patchDecoder{}
+	if err := json.Unmarshal(data, &d.members); err != nil || d.members == nil {
+		return nil, invalid("", "must be a JSON object")
+	}
+	return d, nil
+}
+
+func (d *patchDecoder) fail(field string, message string) {
+	d.errors = append(d.errors, FieldError{Field: field, Message: message})
+}
+
+// take removes a member, returning its value and whether it was present.
+func (d *patchDecoder) take(field string) (json.RawMessage, bool) {
+	value, ok := d.members[field]
+	delete(d.members, field)
+	return value, ok
+}
+
+func isNull(value json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(value), []byte("null"))
+}
+
+// readOnly rejects a member which may not be patched.
+func (d *patchDecoder) readOnly(field string, message string) {
+	if _, ok := d.take(field); ok {
+		d.fail(field, message)
+	}
+}
+
+// string decodes a string member.  If the member is null, the result is
+// cleared if nullable and rejected otherwise.
+func (d *patchDecoder) string(field string, nullable bool) *string {
+	value, ok := d.take(field)
+	if !ok {
+		return nil
+	}
+	result := ""
+	if isNull(value) {
+		if !nullable {
+			d.fail(field, "must not be null")
+			return nil
+		}
+		return &result
+	}
+	if json.Unmarshal(value, &result) != nil {
+		d.fail(field, "must be a string")
+		return nil
+	}
+	return &result
+}
+
+// bool decodes a boolean member; null resets it to false.
+func (d *patchDecoder) bool(field string) *bool {
+	value, ok := d.take(field)
+	if !ok {
+		return nil
+	}
+	result := false
+	if !isNull(value) && json.Unmarshal(value, &result) != nil {
+		d.fail(field, "must be a boolean")
+		return nil
+	}
+	return &result
+}
+
+// strings decodes a member holding an array of strings, which replaces the
+// whole array; null empties it.
+func (d *patchDecoder) strings(field string) *[]string {
+	value, ok := d.take(field)
+	if !ok {
+		return nil
+	}
+	result := []string{}
+	if !isNull(value) && json.Unmarshal(value, &result) != nil {
+		d.fail(field, "must be an array of strings")
+		return nil
+	}
+	return &result
+}
+
+// refs decodes a member holding an array of task references, which replaces
+// the whole array; null empties it.
+func (d *patchDecoder) refs(field string) *[]TaskRef {
+	value, ok := d.take(field)
+	if !ok {
+		return nil
+	}
+	result := []TaskRef{}
+	if !isNull(value) && json.Unmarshal(value, &result) != nil {
+		d.fail(field, "must be an array of task references")
+		return nil
+	}
+	return &result
+}
+
+// time decodes an RFC 3339 time member; null clears it.
+func (d *patchDecoder) time(field string) *time.Time {
+	value, ok := d.take(field)
+	if !ok {
+		return nil
+	}
+	result := time.Time{}
+	if !isNull(value) && json.Unmarshal(value, &result) != nil {
+		d.fail(field, "must be an RFC 3339 time")
+		return nil
+	}
+	return &result
+}
+
+// finish reports any members we didn't recognize, along with everything else
+// that went wrong.
+func (d *patchDecoder) finish() error {
+	unknown := make([]string, 0, len(d.members))
+	for field := range d.members {
+		unknown = append(unknown, field)
+	}
+	sort.Strings(unknown)
+	for _, field := range unknown {
+		d.fail(field, "unknown field")
+	}
+
+	if len(d.errors) > 0 {
+		return &ValidationError{d.errors}
+	}
+	return nil
+}
+
+// DecodeTodoListPatch parses a merge patch document for a list.
+func DecodeTodoListPatch(data []byte) (TodoListPatch, error) {
+	patch := TodoListPatch{}
+	d, err := newPatchDecoder(data)
+	if err != nil {
+		return patch, err
+	}
+
+	d.readOnly("id", "is read-only")
+	patch.Name = d.string("name", false)
+	patch.Description = d.string("description", true)
+	patch.Tags = d.strings("tags")
+	d.readOnly("tasks", "must be changed through the task endpoints")
+	d.readOnly("matches", "is read-only")
+	d.readOnly("counts", "is read-only")
+	d.readOnly("stats", "is read-only")
+	d.readOnly("version", "is read-only; send it in If-Match to make the change conditional")
+	return patch, d.finish()
+}
+
+// DecodeTaskPatch parses a merge patch document for a task.
+func DecodeTaskPatch(data []byte) (TaskPatch, error) {
+	patch := TaskPatch{}
+	d, err := newPatchDecoder(data)
+	if err != nil {
+		return patch, err
+	}
+
+	d.readOnly("id", "is read-only")
+	patch.Name = d.string("name", false)
+	patch.Completed = d.bool("completed")
+	patch.Start = d.time("start")
+	patch.Due = d.time("due")
+	patch.TimeZone = d.string("timeZone", true)
+	patch.Priority = d.string("priority", true)
+	patch.Recurrence = d.string("recurrence", true)
+	patch.Tags = d.strings("tags")
+	patch.Parent = d.string("parent", true)
+	d.readOnly("comments", "must be changed through the comment endpoints")
+	patch.BlockedBy = d.refs("blockedBy")
+	d.readOnly("blocked", "is read-only; it follows from blockedBy")
+	d.readOnly("subtasks", "is read-only; give the subtasks a parent instead")
+	d.readOnly("version", "is read-only; send it in If-Match to make the change conditional")
+	return patch, d.finish()
+}
+
+// DecodeCommentPatch parses a merge patch document for a comment.
+func DecodeCommentPatch(data []byte) (CommentPatch, error) {
+	patch := CommentPatch{}
+	d, err := newPatchDecoder(data)
+	if err != nil {
+		return patch, err
+	}
+
+	d.readOnly("id", "is read-only")
+	d.readOnly("author", "is read-only")
+	patch.Text = d.string("text", false)
+	d.readOnly("created", "is read-only")
+	d.readOnly("updated", "is read-only")
+	return patch, d.finish()
+}
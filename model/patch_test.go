@@ -0,0 +1,121 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeTodoListPatch(t *testing.T) {
+	// Set the name, clear the description.
+	patch, err := DecodeTodoListPatch([]byte(`{"name": "House", "description": null}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "House", *patch.Name)
+	assert.Equal(t, "", *patch.Description)
+
+	// An empty patch changes nothing.
+	patch, err = DecodeTodoListPatch([]byte(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, TodoListPatch{}, patch)
+
+	// Each bad field is reported.
+	_, err = DecodeTodoListPatch([]byte(`{"id": "d290f1ee-6c54-4b01-90e6-d701748f0851", "name": null, "description": 7, "tasks": [], "color": "red"}`))
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, []FieldError{
+		{Field: "id", Message: "is read-only"},
+		{Field: "name", Message: "must not be null"},
+		{Field: "description", Message: "must be a string"},
+		{Field: "tasks", Message: "must be changed through the task endpoints"},
+		{Field: "color", Message: "unknown field"},
+	}, verr.Fields)
+
+	// So are the fields a list is read with, as when it's patched back.
+	_, err = DecodeTodoListPatch([]byte(`{"name": "House", "counts": {"total": 1}, "stats": {}, "version": 3}`))
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, []FieldError{
+		{Field: "counts", Message: "is read-only"},
+		{Field: "stats", Message: "is read-only"},
+		{Field: "version", Message: "is read-only; send it in If-Match to make the change conditional"},
+	}, verr.Fields)
+
+	// Not an object; fails.
+	_, err = DecodeTodoListPatch([]byte(`["name"]`))
+	assert.ErrorAs(t, err, &verr)
+	_, err = DecodeTodoListPatch([]byte(`null`))
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestDecodeTaskPatch(t *testing.T) {
+	// Set the name and completion.
+	patch, err := DecodeTaskPatch([]byte(`{"name": "mow the lawn", "completed": true}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "mow the lawn", *patch.Name)
+	assert.Equal(t, true, *patch.Completed)
+
+	// Removing the completed flag resets it to the default.
+	patch, err = DecodeTaskPatch([]byte(`{"completed": null}`))
+	assert.Nil(t, err)
+	assert.Nil(t, patch.Name)
+	assert.Equal(t, false, *patch.Completed)
+
+	// Set the due time, keeping its offset, and remove the start time.
+	patch, err = DecodeTaskPatch([]byte(`{"due": "2026-11-01T17:00:00+01:00", "start": null, "timeZone": "Europe/Oslo", "priority": null, "recurrence": "FREQ=DAILY"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 11, 1, 16, 0, 0, 0, time.UTC).Unix(), patch.Due.Unix())
+	_, offset := patch.Due.Zone()
+	assert.Equal(t, 3600, offset)
+	assert.True(t, patch.Start.IsZero())
+	assert.Equal(t, "Europe/Oslo", *patch.TimeZone)
+	assert.Equal(t, "", *patch.Priority)
+	assert.Equal(t, "FREQ=DAILY", *patch.Recurrence)
+
+	// Replace the blockers; null removes them all.
+	patch, err = DecodeTaskPatch([]byte(`{"blockedBy": [{"task": "0e2ac84f-f723-4f24-878b-44e63e7ae580"}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []TaskRef{{Task: "0e2ac84f-f723-4f24-878b-44e63e7ae580"}}, *patch.BlockedBy)
+	patch, err = DecodeTaskPatch([]byte(`{"blockedBy": null}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []TaskRef{}, *patch.BlockedBy)
+
+	// Each bad field is reported.
+	_, err = DecodeTaskPatch([]byte(`{"id": "0e2ac84f-f723-4f24-878b-44e63e7ae580", "completed": "yes", "due": "tomorrow", "blockedBy": ["0e2ac84f-f723-4f24-878b-44e63e7ae580"], "blocked": false}`))
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, []FieldError{
+		{Field: "id", Message: "is read-only"},
+		{Field: "completed", Message: "must be a boolean"},
+		{Field: "due", Message: "must be an RFC 3339 time"},
+		{Field: "blockedBy", Message: "must be an array of task references"},
+		{Field: "blocked", Message: "is read-only; it follows from blockedBy"},
+	}, verr.Fields)
+
+	// So is the version.
+	_, err = DecodeTaskPatch([]byte(`{"name": "mow the lawn", "version": 4}`))
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, []FieldError{
+		{Field: "version", Message: "is read-only; send it in If-Match to make the change conditional"},
+	}, verr.Fields)
+}
+
+func TestDecodeCommentPatch(t *testing.T) {
+	// Set the text.
+	patch, err := DecodeCommentPatch([]byte(`{"text": "green, after all"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "green, after all", *patch.Text)
+
+	// An empty patch changes nothing.
+	patch, err = DecodeCommentPatch([]byte(`{}`))
+	assert.Nil(t, err)
+	assert.Nil(t, patch.Text)
+
+	// Each bad field is reported.
+	_, err = DecodeCommentPatch([]byte(`{"author": "bob", "text": null, "created": "2026-05-01T09:00:00Z"}`))
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, 3, len(verr.Fields))
+	assert.Equal(t, "author", verr.Fields[0].Field)
+	assert.Equal(t, "text", verr.Fields[1].Field)
+	assert.Equal(t, "created", verr.Fields[2].Field)
+}
@@ -0,0 +1,222 @@
+package model
+
+import (
+	"errors"
+	"strings"
+)
+
+// Tasks are ordered by position keys: strings which sort in the order of the
+// tasks, and between any two of which another key can always be found.  This
+// is fractional indexing; moving a task only changes its own key, rather than
+// renumbering everything after it.
+//
+// A key is an integer part followed by a fractional part, both written in
+// base 62 with digits in ASCII order.  The first character of the integer
+// part encodes its length: 'a' through 'z' for lengths 2 through 27, and 'Z'
+// down to 'A' for the same lengths below zero.  So appending to the end of a
+// list just increments the integer part, and keys only grow when we squeeze
+// between two neighbours.  The fractional part never ends in '0', so that
+// there is always room below it.
+
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// The smallest integer part, which can't be decremented.
+var smallestInteger = "A" + strings.Repeat("0", 26)
+
+var errPosition = errors.New("invalid position key")
+
+func digitValue(digit byte) int {
+	return strings.IndexByte(positionDigits, digit)
+}
+
+// midpoint returns a fractional part strictly between a and b.  An empty b
+// means there is no upper bound.
+func midpoint(a string, b string) string {
+	if b != "" {
+		// Carry over any common prefix, treating a as padded with zeros.
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			if n > len(a) {
+				return b[:n] + midpoint("", b[n:])
+			}
+			return b[:n] + midpoint(a[n:], b[n:])
+		}
+	}
+
+	// The first digits differ.  If there's a digit between them, use it.
+	digitA := 0
+	if a != "" {
+		digitA = digitValue(a[0])
+	}
+	digitB := len(positionDigits)
+	if b != "" {
+		digitB = digitValue(b[0])
+	}
+	if digitB-digitA > 1 {
+		return positionDigits[(digitA+digitB+1)/2 : (digitA+digitB+1)/2+1]
+	}
+
+	// The first digits are consecutive.  If b has more digits, its first
+	// digit alone lies between; otherwise keep a's first digit and look for
+	// room after it.
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if a != "" {
+		rest = a[1:]
+	}
+	return positionDigits[digitA:digitA+1] + midpoint(rest, "")
+}
+
+func digitAt(s string, n int) byte {
+	if n < len(s) {
+		return s[n]
+	}
+	return '0'
+}
+
+func integerLength(head byte) (int, error) {
+	switch {
+	case head >= 'a' && head <= 'z':
+		return int(head-'a') + 2, nil
+	case head >= 'A' && head <= 'Z':
+		return int('Z'-head) + 2, nil
+	}
+	return 0, errPosition
+}
+
+// splitPosition validates a key and splits it into its integer and
+// fractional parts.
+func splitPosition(key string) (string, string, error) {
+	if key == "" || key == smallestInteger {
+		return "", "", errPosition
+	}
+	n, err := integerLength(key[0])
+	if err != nil || n > len(key) {
+		return "", "", errPosition
+	}
+	for i := 1; i < len(key); i++ {
+		if digitValue(key[i]) < 0 {
+			return "", "", errPosition
+		}
+	}
+	if strings.HasSuffix(key[n:], "0") {
+		return "", "", errPosition
+	}
+	return key[:n], key[n:], nil
+}
+
+// incrementInteger returns the next integer, or false if there is none.
+func incrementInteger(x string) (string, bool) {
+	head := x[0]
+	digits := []byte(x[1:])
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digitValue(digits[i]) + 1
+		if d < len(positionDigits) {
+			digits[i] = positionDigits[d]
+			return string(head) + string(digits), true
+		}
+		digits[i] = '0'
+	}
+
+	// Carry into the head, which changes the length.
+	switch {
+	case head == 'Z':
+		return "a0", true
+	case head == 'z':
+		return "", false
+	case head >= 'a':
+		return string(head+1) + string(digits) + "0", true
+	default:
+		return string(head+1) + string(digits[1:]), true
+	}
+}
+
+// decrementInteger returns the previous integer, or false if there is none.
+func decrementInteger(x string) (string, bool) {
+	last := positionDigits[len(positionDigits)-1]
+	head := x[0]
+	digits := []byte(x[1:])
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digitValue(digits[i]) - 1
+		if d >= 0 {
+			digits[i] = positionDigits[d]
+			return string(head) + string(digits), true
+		}
+		digits[i] = last
+	}
+
+	// Borrow from the head, which changes the length.
+	switch {
+	case head == 'a':
+		return "Z" + string(last), true
+	case head == 'A':
+		return "", false
+	case head <= 'Z':
+		return string(head-1) + string(digits) + string(last), true
+	default:
+		return string(head-1) + string(digits[1:]), true
+	}
+}
+
+// positionBetween returns a key which sorts strictly between a and b.  An
+// empty a means the start of the list, and an empty b the end.
+func positionBetween(a string, b string) (string, error) {
+	if a == "" && b == "" {
+		return "a0", nil
+	}
+
+	if a == "" {
+		ib, fb, err := splitPosition(b)
+		if err != nil {
+			return "", err
+		}
+		if ib == smallestInteger {
+			return ib + midpoint("", fb), nil
+		}
+		if fb != "" {
+			return ib, nil
+		}
+		key, ok := decrementInteger(ib)
+		if !ok {
+			return "", errPosition
+		}
+		return key, nil
+	}
+
+	ia, fa, err := splitPosition(a)
+	if err != nil {
+		return "", err
+	}
+
+	if b == "" {
+		key, ok := incrementInteger(ia)
+		if !ok {
+			return ia + midpoint(fa, ""), nil
+		}
+		return key, nil
+	}
+
+	ib, fb, err := splitPosition(b)
+	if err != nil {
+		return "", err
+	}
+	if a >= b {
+		return "", errPosition
+	}
+	if ia == ib {
+		return ia + midpoint(fa, fb), nil
+	}
+	key, ok := incrementInteger(ia)
+	if !ok {
+		return "", errPosition
+	}
+	if key < b {
+		return key, nil
+	}
+	return ia + midpoint(fa, ""), nil
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionBetween(t *testing.T) {
+	// Known keys.
+	for _, c := range []struct{ a, b, expected string }{
+		{"", "", "a0"},
+		{"a0", "", "a1"},
+		{"az", "", "b00"},
+		{"Zz", "", "a0"},
+		{"", "a0", "Zz"},
+		{"", "b00", "az"},
+		{"", "Z0", "Yzz"},
+		{"a0", "a1", "a0V"},
+		{"a1", "a2", "a1V"},
+		{"a0V", "a1", "a0l"},
+		{"a0", "a0V", "a0G"},
+		{"a0", "a01", "a00V"},
+		{"a0", "b00", "a1"},
+		{"", smallestInteger + "1", smallestInteger + "0V"},
+	} {
+		key, err := positionBetween(c.a, c.b)
+		assert.Nil(t, err, "%q, %q", c.a, c.b)
+		assert.Equal(t, c.expected, key, "%q, %q", c.a, c.b)
+	}
+
+	// Bad keys.
+	for _, c := range []struct{ a, b string }{
+		{"a1", "a0"},
+		{"a0", "a0"},
+		{"a00", ""},
+		{"a", ""},
+		{"a0!", ""},
+		{"", smallestInteger},
+		{"!0", ""},
+	} {
+		_, err := positionBetween(c.a, c.b)
+		assert.NotNil(t, err, "%q, %q", c.a, c.b)
+	}
+}
+
+func TestPositionOrdering(t *testing.T) {
+	// Append many keys; they stay ordered and short.
+	keys := []string{}
+	last := ""
+	for i := 0; i < 10000; i++ {
+		key, err := positionBetween(last, "")
+		require.Nil(t, err)
+		assert.Greater(t, key, last)
+		keys = append(keys, key)
+		last = key
+	}
+	assert.LessOrEqual(t, len(last), 4)
+
+	// Prepend many keys.
+	first := keys[0]
+	for i := 0; i < 10000; i++ {
+		key, err := positionBetween("", first)
+		require.Nil(t, err)
+		assert.Less(t, key, first)
+		first = key
+	}
+	assert.LessOrEqual(t, len(first), 4)
+
+	// Squeeze repeatedly into the same gap, from both sides.
+	low, high := keys[0], keys[1]
+	for i := 0; i < 500; i++ {
+		key, err := positionBetween(low, high)
+		require.Nil(t, err)
+		assert.Greater(t, key, low)
+		assert.Less(t, key, high)
+		assert.False(t, strings.HasSuffix(key, "0"))
+		if i%2 == 0 {
+			low = key
+		} else {
+			high = key
+		}
+	}
+}
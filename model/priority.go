@@ -0,0 +1,39 @@
+package model
+
+import (
+	"strings"
+)
+
+// Task priorities, from lowest to highest.  A task with no priority has
+// PriorityNone, which is left out of the output model.
+const (
+	PriorityNone   = "none"
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+var priorities = []string{PriorityNone, PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent}
+
+// parsePriority returns the rank of a priority, where higher is more urgent.
+// The empty string is PriorityNone.
+func parsePriority(field string, priority string) (int, error) {
+	if priority == "" {
+		return 0, nil
+	}
+	for rank, p := range priorities {
+		if p == priority {
+			return rank, nil
+		}
+	}
+	return 0, invalid(field, "must be one of %s", strings.Join(priorities, ", "))
+}
+
+// priorityName returns the name of a rank for the output model.
+func priorityName(rank int) string {
+	if rank == 0 {
+		return ""
+	}
+	return priorities[rank]
+}
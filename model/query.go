@@ -0,0 +1,415 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// The search string of GetLists is a query in a small language:
+//
+//	query = { term }
+//	term  = [ "-" ] [ field ":" ] value
+//	value = word | '"' { character | '\"' | '\\' } '"'
+//
+// Terms are separated by spaces, a list must match all of them, and a "-"
+// in front of a term negates it.  A word is anything up to the next space,
+// and a quoted value may contain spaces.  A prefix which isn't one of the
+// fields below, as in "http://example.com", is just part of the word.  A term
+// without a field matches the list's name, description or task names, as
+// does "name:" or "description:" for that field alone.  Text values are split
+// into words as in search.go; a single word matches the beginning of a word,
+// and a quoted value, or one with several words, matches those whole words in
+// order.
+//
+// The other fields are about tasks: "task:" matches the task's name, as
+// above, and "tag:", "completed:", "priority:" and "due:" work like the task
+// filters.  A list matches these terms if any of its tasks matches all of
+// them, and then only those tasks are included, as with the filters.  A due
+// time may be a date, which stands for the whole day in UTC, or an RFC 3339
+// time, after an optional "<", "<=", ">" or ">=".
+//
+// Errors are reported with the position of the offending character, counted
+// in characters from 1.
+
+// Fields of a query term.
+const (
+	queryName        = "name"
+	queryDescription = "description"
+	queryTask        = "task"
+	queryTag         = "tag"
+	queryCompleted   = "completed"
+	queryPriority    = "priority"
+	queryDue         = "due"
+)
+
+var queryFields = map[string]bool{
+	queryName: true, queryDescription: true, queryTask: true, queryTag: true,
+	queryCompleted: true, queryPriority: true, queryDue: true,
+}
+
+type queryTerm struct {
+	negate bool
+	field  string // Empty for any of the list's text
+
+	// Text terms.
+	words  []string
+	phrase bool // Whole words, in order
+
+	// Task terms.
+	tag       string
+	completed bool
+	priority  int
+	from, to  time.Time // Due at or after from and before to; zero is open
+}
+
+// query is a parsed search string.
+type query struct {
+	listTerms []queryTerm // About the list's text
+	taskTerms []queryTerm // About individual tasks
+	tags      []string    // Tags wanted, which work as in the task filter
+}
+
+// queryError returns a validation error at a position in the search string.
+func queryError(position int, format string, args ...interface{}) *ValidationError {
+	err := invalid("searchString", format, args...)
+	err.Fields[0].Position = position
+	return err
+}
+
+type queryParser struct {
+	text []rune
+	i    int // Index of the next character
+}
+
+func (p *queryParser) done() bool {
+	return p.i >= len(p.text)
+}
+
+// atSpace reports whether the parser is at the end of a term.
+func (p *queryParser) atSpace() bool {
+	return p.done() || unicode.IsSpace(p.text[p.i])
+}
+
+// parseQuery parses a search string.
+func parseQuery(text string) (query, error) {
+	q := query{}
+	p := queryParser{text: []rune(text)}
+	for {
+		for !p.done() && p.atSpace() {
+			p.i++
+		}
+		if p.done() {
+			return q, nil
+		}
+		term, err := p.term()
+		if err != nil {
+			return q, err
+		}
+		switch {
+		case term.field == "" || term.field == queryName || term.field == queryDescription:
+			q.listTerms = append(q.listTerms, term)
+		case term.field == queryTag && !term.negate:
+			q.tags = append(q.tags, term.tag)
+		default:
+			q.taskTerms = append(q.taskTerms, term)
+		}
+	}
+}
+
+func (p *queryParser) term() (queryTerm, error) {
+	term := queryTerm{}
+	if p.text[p.i] == '-' {
+		term.negate = true
+		p.i++
+		if p.atSpace() {
+			return term, queryError(p.i, "\"-\" must be followed by a term")
+		}
+	}
+
+	// A field is a run of letters ending in a colon.  Anything else before a
+	// colon, such as "http:", is part of the value.
+	start := p.i
+	for !p.done() && unicode.IsLetter(p.text[p.i]) {
+		p.i++
+	}
+	if field := string(p.text[start:p.i]); !p.done() && p.text[p.i] == ':' && queryFields[field] {
+		term.field = field
+		p.i++
+	} else {
+		p.i = start
+	}
+
+	position := p.i + 1
+	value, quoted, err := p.value()
+	if err != nil {
+		return term, err
+	}
+	return term, term.parseValue(value, quoted, position)
+}
+
+// value reads a word or a quoted value.
+func (p *queryParser) value() (string, bool, error) {
+	if p.atSpace() {
+		return "", false, queryError(p.i+1, "missing value")
+	}
+	if p.text[p.i] != '"' {
+		start := p.i
+		for !p.atSpace() {
+			if p.text[p.i] == '"' {
+				return "", false, queryError(p.i+1, "unexpected quote")
+			}
+			p.i++
+		}
+		return string(p.text[start:p.i]), false, nil
+	}
+
+	open := p.i + 1
+	p.i++
+	value := strings.Builder{}
+	for !p.done() {
+		c := p.text[p.i]
+		p.i++
+		switch {
+		case c == '"':
+			if !p.atSpace() {
+				return "", false, queryError(p.i+1, "expected a space after the closing quote")
+			}
+			return value.String(), true, nil
+		case c == '\\' && !p.done() && (p.text[p.i] == '"' || p.text[p.i] == '\\'):
+			value.WriteRune(p.text[p.i])
+			p.i++
+		default:
+			value.WriteRune(c)
+		}
+	}
+	return "", false, queryError(open, "unterminated quote")
+}
+
+// parseValue interprets a term's value according to its field.
+func (term *queryTerm) parseValue(value string, quoted bool, position int) error {
+	switch term.field {
+	case "", queryName, queryDescription, queryTask:
+		term.words = tokenize(value)
+		if len(term.words) == 0 {
+			return queryError(position, "must contain a letter or digit")
+		}
+		term.phrase = quoted || len(term.words) > 1
+
+	case queryTag:
+		tags, err := normalizeTags(queryTag, []string{value})
+		if err != nil {
+			return queryError(position, "tag: %s", err.(*ValidationError).Fields[0].Message)
+		}
+		term.tag = tags[0]
+
+	case queryCompleted:
+		completed, err := strconv.ParseBool(value)
+		if err != nil {
+			return queryError(position, "completed: must be true or false")
+		}
+		term.completed = completed
+
+	case queryPriority:
+		priority, err := parsePriority(queryPriority, value)
+		if err != nil || value == "" {
+			return queryError(position, "priority: must be one of %s", strings.Join(priorities, ", "))
+		}
+		term.priority = priority
+
+	case queryDue:
+		return term.parseDue(value, position)
+	}
+	return nil
+}
+
+// parseDue turns a due term into a range of times.  A date is the whole day,
+// and a time a single instant, so that "<=" includes all of it and ">"
+// excludes all of it.
+func (term *queryTerm) parseDue(value string, position int) error {
+	op := ""
+	for _, prefix := range []string{"<=", ">=", "<", ">"} {
+		if strings.HasPrefix(value, prefix) {
+			op = prefix
+			break
+		}
+	}
+	value = value[len(op):]
+
+	start, err := time.Parse("2006-01-02", value)
+	end := start.AddDate(0, 0, 1)
+	if err != nil {
+		start, err = time.Parse(time.RFC3339, value)
+		end = start.Add(time.Nanosecond)
+	}
+	if err != nil {
+		return queryError(position, "due: must be a date or an RFC 3339 time, optionally after <, <=, > or >=")
+	}
+
+	switch op {
+	case "":
+		term.from, term.to = start, end
+	case "<":
+		term.to = start
+	case "<=":
+		term.to = end
+	case ">":
+		term.from = end
+	case ">=":
+		term.from = start
+	}
+	return nil
+}
+
+// fields returns the fields of a list a text term looks at.
+func (term queryTerm) fields() [numFields]bool {
+	switch term.field {
+	case queryName:
+		return [numFields]bool{fieldName: true}
+	case queryDescription:
+		return [numFields]bool{fieldDescription: true}
+	}
+	return [numFields]bool{true, true, true}
+}
+
+// count counts the occurrences of a text term in some text, as whole words
+// and as the mere beginnings of words.
+func (term queryTerm) count(text string) (whole int, partial int) {
+	words := tokenize(text)
+	if term.phrase {
+	next:
+		for i := 0; i+len(term.words) <= len(words); i++ {
+			for j, word := range term.words {
+				if words[i+j] != word {
+					continue next
+				}
+			}
+			whole++
+		}
+		return whole, 0
+	}
+	for _, word := range words {
+		switch {
+		case word == term.words[0]:
+			whole++
+		case strings.HasPrefix(word, term.words[0]):
+			partial++
+		}
+	}
+	return whole, partial
+}
+
+// scoreList matches a text term against a list, scoring it the same way as
+// the search index.
+func (term queryTerm) scoreList(l list) (*searchHit, bool) {
+	hit := &searchHit{}
+	fields := term.fields()
+	score := func(field int, text string) {
+		if whole, partial := term.count(text); fields[field] && whole+partial > 0 {
+			hit.score += fieldWeights[field] * (2*whole + partial)
+			hit.fields[field] = true
+		}
+	}
+	score(fieldName, l.name)
+	score(fieldDescription, l.description)
+	for _, t := range l.tasks {
+		score(fieldTasks, t.name)
+	}
+	return hit, hit.fields != [numFields]bool{}
+}
+
+// matchTask reports whether a task term matches a task in the given list,
+// before any negation.
+func (term queryTerm) matchTask(t task, l list) bool {
+	switch term.field {
+	case queryTask:
+		whole, partial := term.count(t.name)
+		return whole+partial > 0
+	case queryTag:
+		return hasTags([]string{term.tag}, t.tags, l.tags)
+	case queryCompleted:
+		return t.completed == term.completed
+	case queryPriority:
+		return t.priority == term.priority
+	case queryDue:
+		return !t.due.IsZero() &&
+			(term.from.IsZero() || !t.due.Before(term.from)) &&
+			(term.to.IsZero() || t.due.Before(term.to))
+	}
+	return false
+}
+
+// excludes reports whether one of a query's negated text terms matches a
+// list.
+func (q query) excludes(l list) bool {
+	for _, term := range q.listTerms {
+		if !term.negate {
+			continue
+		}
+		if _, ok := term.scoreList(l); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// searchLists finds the lists matching all of a query's positive text terms,
+// with how well they match.  If there are no such terms, any list may match,
+// and it returns nil.  The caller must hold the lock.
+func (s *MemoryStore) searchLists(q query) map[uuid.UUID]*searchHit {
+	var hits map[uuid.UUID]*searchHit
+	for _, term := range q.listTerms {
+		if term.negate {
+			continue
+		}
+
+		// A single word can be looked up directly.  For a phrase, the index
+		// finds the lists with all of its words, and then we check that they
+		// come in order.
+		var found map[uuid.UUID]*searchHit
+		if !term.phrase {
+			found = s.search.lookup(term.words[0], term.fields(), true)
+		} else {
+			var candidates map[uuid.UUID]*searchHit
+			for _, word := range term.words {
+				more := s.search.lookup(word, term.fields(), false)
+				if candidates != nil {
+					for listid := range candidates {
+						if more[listid] == nil {
+							delete(candidates, listid)
+						}
+					}
+				} else {
+					candidates = more
+				}
+			}
+			found = make(map[uuid.UUID]*searchHit)
+			for listid := range candidates {
+				if hit, ok := term.scoreList(s.lists[listid]); ok {
+					found[listid] = hit
+				}
+			}
+		}
+
+		// Keep the lists which matched every term so far.
+		if hits == nil {
+			hits = found
+			continue
+		}
+		for listid, hit := range hits {
+			more, ok := found[listid]
+			if !ok {
+				delete(hits, listid)
+				continue
+			}
+			hit.score += more.score
+			for field := range hit.fields {
+				hit.fields[field] = hit.fields[field] || more.fields[field]
+			}
+		}
+	}
+	return hits
+}
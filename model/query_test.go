@@ -0,0 +1,99 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	// Good queries.
+	q, err := parseQuery(`name:groceries tag:Home completed:false due:<2026-11-01 "exact phrase" -excluded`)
+	require.Nil(t, err)
+	assert.Equal(t, []queryTerm{
+		{field: queryName, words: []string{"groceries"}},
+		{words: []string{"exact", "phrase"}, phrase: true},
+		{negate: true, words: []string{"excluded"}},
+	}, q.listTerms)
+	assert.Equal(t, []string{"home"}, q.tags)
+	assert.Equal(t, []queryTerm{
+		{field: queryCompleted},
+		{field: queryDue, to: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+	}, q.taskTerms)
+
+	q, err = parseQuery(`  task:"say \"hi\"" -tag:work priority:high re-paint  `)
+	require.Nil(t, err)
+	assert.Equal(t, []queryTerm{
+		{words: []string{"re", "paint"}, phrase: true},
+	}, q.listTerms)
+	assert.Equal(t, []queryTerm{
+		{field: queryTask, words: []string{"say", "hi"}, phrase: true},
+		{negate: true, field: queryTag, tag: "work"},
+		{field: queryPriority, priority: 3},
+	}, q.taskTerms)
+
+	// Prefixes which aren't fields are part of the text.
+	q, err = parseQuery(`http://example.com note: call -colour:red`)
+	require.Nil(t, err)
+	assert.Equal(t, []queryTerm{
+		{words: []string{"http", "example", "com"}, phrase: true},
+		{words: []string{"note"}},
+		{words: []string{"call"}},
+		{negate: true, words: []string{"colour", "red"}, phrase: true},
+	}, q.listTerms)
+	assert.Nil(t, q.taskTerms)
+
+	q, err = parseQuery("")
+	require.Nil(t, err)
+	assert.Equal(t, query{}, q)
+
+	// Due times, as ranges.
+	day := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	instant := time.Date(2026, 11, 1, 12, 0, 0, 0, time.FixedZone("", 3600))
+	for _, c := range []struct {
+		text     string
+		from, to time.Time
+	}{
+		{"due:2026-11-01", day, day.AddDate(0, 0, 1)},
+		{"due:<2026-11-01", time.Time{}, day},
+		{"due:<=2026-11-01", time.Time{}, day.AddDate(0, 0, 1)},
+		{"due:>2026-11-01", day.AddDate(0, 0, 1), time.Time{}},
+		{"due:>=2026-11-01", day, time.Time{}},
+		{"due:>=2026-11-01T12:00:00+01:00", instant, time.Time{}},
+		{"due:2026-11-01T12:00:00+01:00", instant, instant.Add(time.Nanosecond)},
+	} {
+		q, err := parseQuery(c.text)
+		require.Nil(t, err, c.text)
+		require.Equal(t, 1, len(q.taskTerms), c.text)
+		assert.True(t, c.from.Equal(q.taskTerms[0].from), c.text)
+		assert.True(t, c.to.Equal(q.taskTerms[0].to), c.text)
+	}
+
+	// Bad queries, with where the problem is.
+	for _, c := range []struct {
+		text     string
+		message  string
+		position int
+	}{
+		{"milk -", `"-" must be followed by a term`, 6},
+		{"milk - eggs", `"-" must be followed by a term`, 6},
+		{"name: milk", "missing value", 6},
+		{`name:"milk`, "unterminated quote", 6},
+		{`"milk"eggs`, "expected a space after the closing quote", 7},
+		{`mi"lk`, "unexpected quote", 3},
+		{"milk ???", "must contain a letter or digit", 6},
+		{"completed:maybe", "completed: must be true or false", 11},
+		{"priority:whenever", "priority: must be one of none, low, medium, high, urgent", 10},
+		{"due:<tomorrow", "due: must be a date or an RFC 3339 time, optionally after <, <=, > or >=", 5},
+		{"tag:a,b", "tag: must not contain tags with commas", 5},
+		{"øl name:", "missing value", 9},
+	} {
+		_, err := parseQuery(c.text)
+		var verr *ValidationError
+		if assert.ErrorAs(t, err, &verr, c.text) {
+			assert.Equal(t, []FieldError{{Field: "searchString", Message: c.message, Position: c.position}}, verr.Fields, c.text)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every mutation of a store is described by a record.  The public methods
+// validate their input under the write lock and build a record; committing
+// the record hands it to the journal (if any) and then applies it to the
+// in-memory lists.  Applying a record cannot fail, so a durable store can
+// replay its journal through exactly the same code path on startup.
+
+const (
+	opAddList       = "addList"
+	opAddTask       = "addTask"
+	opSetCompleted  = "setCompleted"
+	opDeleteList    = "deleteList"
+	opDeleteTask    = "deleteTask"
+	opUpdateList    = "updateList"
+	opUpdateTask    = "updateTask"
+	opMoveTask      = "moveTask"
+	opAddComment    = "addComment"
+	opUpdateComment = "updateComment"
+	opDeleteComment = "deleteComment"
+	opBatch         = "batch"
+)
+
+// taskRecord is the persistent form of a task.
+type taskRecord struct {
+	ID         uuid.UUID       `json:"id"`
+	Name       string          `json:"name"`
+	Completed  bool            `json:"completed,omitempty"`
+	Position   string          `json:"position"`
+	Start      *time.Time      `json:"start,omitempty"`
+	Due        *time.Time      `json:"due,omitempty"`
+	TimeZone   string          `json:"timeZone,omitempty"`
+	Priority   string          `json:"priority,omitempty"`
+	Recurrence string          `json:"recurrence,omitempty"`
+	Anchor     *time.Time      `json:"anchor,omitempty"`
+	Tags       []string        `json:"tags,omitempty"`
+	Parent     *uuid.UUID      `json:"parent,omitempty"`
+	BlockedBy  []keyRecord     `json:"blockedBy,omitempty"`
+	Comments   []commentRecord `json:"comments,omitempty"` // Only in snapshots
+	Created    *time.Time      `json:"created,omitempty"`  // Only in snapshots
+	Updated    *time.Time      `json:"updated,omitempty"`  // Only in snapshots
+	Version    uint64          `json:"version,omitempty"`  // Only in snapshots
+}
+
+// keyRecord is the persistent form of a taskKey.
+type keyRecord struct {
+	List uuid.UUID `json:"list"`
+	Task uuid.UUID `json:"task"`
+}
+
+type record struct {
+	Seq         uint64       `json:"seq"`
+	Op          string       `json:"op"`
+	Time        *time.Time   `json:"time,omitempty"`    // When committed; in a snapshot, when the list was last updated, or in the header the last record was
+	Created     *time.Time   `json:"created,omitempty"` // The list's; only in snapshots
+	Version     uint64       `json:"version,omitempty"` // The list's; only in snapshots
+	Changed     *time.Time   `json:"changed,omitempty"` // When the list's version was set; only in snapshots
+	List        uuid.UUID    `json:"list"`
+	TaskID      uuid.UUID    `json:"taskId"`
+	Name        string       `json:"name,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Completed   bool         `json:"completed,omitempty"`
+	Cascade     bool         `json:"cascade,omitempty"`
+	Position    string       `json:"position,omitempty"`
+	Tasks       []taskRecord `json:"tasks,omitempty"`
+	Tags        []string     `json:"tags,omitempty"` // The list's
+
+	// Destination is the list a task is moved to, if it's changing lists.
+	Destination *uuid.UUID `json:"destination,omitempty"`
+
+	// Comment is the comment added or edited, or just the ID of the one
+	// deleted.
+	Comment *commentRecord `json:"comment,omitempty"`
+
+	// Batch is the records of a batch, in the order they were applied; see
+	// batch.go.
+	Batch []record `json:"batch,omitempty"`
+}
+
+// destination returns the list a task is moved to, or uuid.Nil if it stays
+// in its own.
+func (r record) destination() uuid.UUID {
+	if r.Destination == nil {
+		return uuid.Nil
+	}
+	return *r.Destination
+}
+
+// A journal persists records before they are applied.
+type journal interface {
+	// append makes a record durable.  If it returns an error, the record is
+	// not applied.
+	append(r *record) error
+
+	// applied is called after a record has been applied, with the write lock
+	// still held, so that the journal may compact itself.
+	applied()
+}
+
+// newTaskRecord returns the persistent form of a task, without its comments,
+// which are journaled separately, or its times, which are those of the
+// records.
+func newTaskRecord(id uuid.UUID, t task) taskRecord {
+	r := taskRecord{
+		ID:         id,
+		Name:       t.name,
+		Completed:  t.completed,
+		Position:   t.position,
+		Start:      timePointer(t.start),
+		Due:        timePointer(t.due),
+		TimeZone:   t.timeZone,
+		Priority:   priorityName(t.priority),
+		Recurrence: t.recurrence,
+		Anchor:     timePointer(t.anchor),
+		Tags:       t.tags,
+		Parent:     parentPointer(t.parent),
+	}
+	for _, key := range t.blockers {
+		r.BlockedBy = append(r.BlockedBy, keyRecord{key.list, key.task})
+	}
+	return r
+}
+
+func (r taskRecord) task() task {
+	t := task{
+		name:       r.Name,
+		completed:  r.Completed,
+		position:   r.Position,
+		start:      timeValue(r.Start),
+		due:        timeValue(r.Due),
+		timeZone:   r.TimeZone,
+		recurrence: r.Recurrence,
+		anchor:     timeValue(r.Anchor),
+		tags:       r.Tags,
+		parent:     parentValue(r.Parent),
+	}
+	for _, key := range r.BlockedBy {
+		t.blockers = append(t.blockers, taskKey{key.List, key.Task})
+	}
+	for _, c := range r.Comments {
+		t.comments = append(t.comments, c.comment())
+	}
+	t.created = timeValue(r.Created)
+	t.updated = timeValue(r.Updated)
+	t.version = r.Version
+
+	// Priorities are persisted by name, so that the ranks may change.  The
+	// name was checked when the task was written.
+	t.priority, _ = parsePriority("priority", r.Priority)
+
+	// The times come back with a fixed offset; put them back in the task's
+	// time zone.  The zone was checked when the task was written, so the
+	// only error possible is a zone since removed from the system database,
+	// and then the offsets will do.
+	t.normalize()
+	return t
+}
+
+// commit journals and applies a record.  The caller must hold the write lock
+// and must already have validated the record against the current state.  In a
+// batch, the record is applied at once but journaled with the rest of the
+// batch.
+func (s *MemoryStore) commit(tx *txn, r record) error {
+	if tx != nil && tx.batch != nil {
+		tx.batch.apply(s, r)
+		return nil
+	}
+	r.Seq = s.seq + 1
+	r.Time = timePointer(s.clock())
+	if s.journal != nil {
+		if err := s.journal.append(&r); err != nil {
+			return err
+		}
+	}
+	s.apply(r)
+	if s.journal != nil {
+		s.journal.applied()
+	}
+	return nil
+}
+
+// touch marks a list as updated by a record.  The caller must hold the write
+// lock.
+func (s *MemoryStore) touch(listid uuid.UUID, now time.Time, seq uint64) {
+	list := s.lists[listid]
+	list.updated = now
+	list.version = seq
+	list.changed = now
+	s.lists[listid] = list
+}
+
+// stamp sets the times and version of a new task which weren't recorded with
+// it.
+func (t *task) stamp(now time.Time, seq uint64) {
+	if t.created.IsZero() {
+		t.created = now
+	}
+	if t.updated.IsZero() {
+		t.updated = now
+	}
+	if t.version == 0 {
+		t.version = seq
+	}
+}
+
+// apply modifies the in-memory lists according to a record.  The caller must
+// hold the write lock.
+func (s *MemoryStore) apply(r record) {
+	now := timeValue(r.Time)
+	switch r.Op {
+	case opAddList:
+		newlist := list{name: r.Name, description: r.Description, tags: r.Tags, tasks: make(taskmap), created: timeValue(r.Created), updated: now, version: r.Version, changed: timeValue(r.Changed)}
+		if newlist.created.IsZero() {
+			newlist.created = now
+		}
+		if newlist.version == 0 {
+			newlist.version = r.Seq
+			newlist.changed = now
+		}
+		for _, t := range r.Tasks {
+			task := t.task()
+			task.stamp(now, r.Seq)
+			newlist.tasks[t.ID] = task
+			s.tags.addTask(taskKey{r.List, t.ID}, t.Tags)
+			s.dues.add(task)
+			s.dependencies.add(taskKey{r.List, t.ID}, task.blockers)
+		}
+		s.lists[r.List] = newlist
+		s.tags.addList(r.List, r.Tags)
+		s.search.addList(r.List, newlist)
+
+	case opAddTask, opUpdateTask:
+		tasks := s.lists[r.List].tasks
+		for _, t := range r.Tasks {
+			key := taskKey{r.List, t.ID}
+			s.tags.removeTask(key, tasks[t.ID].tags)
+			s.dependencies.remove(key, tasks[t.ID].blockers)
+			s.search.remove(r.List, fieldTasks, tasks[t.ID].name)
+			s.dues.remove(tasks[t.ID])
+			task := t.task()
+			if r.Op == opUpdateTask {
+				task.comments = tasks[t.ID].comments
+				task.created = tasks[t.ID].created
+			}
+			task.stamp(now, r.Seq)
+			if task.completed != tasks[t.ID].completed {
+				s.touchDependents(key, now, r.Seq)
+			}
+			tasks[t.ID] = task
+			s.tags.addTask(key, t.Tags)
+			s.dependencies.add(key, task.blockers)
+			s.search.add(r.List, fieldTasks, task.name)
+			s.dues.add(task)
+		}
+		s.touch(r.List, now, r.Seq)
+
+	case opSetCompleted:
+		tasks := s.lists[r.List].tasks
+		affected := []uuid.UUID{r.TaskID}
+		if r.Cascade {
+			affected = subtree(tasks, r.TaskID)
+		}
+		for _, taskid := range affected {
+			task := tasks[taskid]
+			s.dues.remove(task)
+			task.completed = r.Completed
+			task.updated = now
+			task.version = r.Seq
+			tasks[taskid] = task
+			s.dues.add(task)
+			s.touchDependents(taskKey{r.List, taskid}, now, r.Seq)
+		}
+
+		// Completing a recurring task adds its next occurrence, and takes the
+		// completed one off the series.
+		if len(r.Tasks) > 0 {
+			task := tasks[r.TaskID]
+			task.recurrence = ""
+			task.anchor = time.Time{}
+			tasks[r.TaskID] = task
+		}
+		for _, t := range r.Tasks {
+			key := taskKey{r.List, t.ID}
+			task := t.task()
+			task.stamp(now, r.Seq)
+			tasks[t.ID] = task
+			s.tags.addTask(key, t.Tags)
+			s.dependencies.add(key, task.blockers)
+			s.search.add(r.List, fieldTasks, task.name)
+			s.dues.add(task)
+		}
+		s.touch(r.List, now, r.Seq)
+
+	case opMoveTask:
+		tasks := s.lists[r.List].tasks
+		task := tasks[r.TaskID]
+		task.position = r.Position
+		task.updated = now
+		task.version = r.Seq
+		s.touch(r.List, now, r.Seq)
+		if destid := r.destination(); destid != uuid.Nil && destid != r.List {
+			// The task's subtree goes with it, and it leaves its parent
+			// behind.
+			task.parent = uuid.Nil
+			dest := s.lists[destid].tasks
+			for _, taskid := range subtree(tasks, r.TaskID) {
+				moved := tasks[taskid]
+				if taskid == r.TaskID {
+					moved = task
+				}
+				delete(tasks, taskid)
+				dest[taskid] = moved
+				s.tags.removeTask(taskKey{r.List, taskid}, moved.tags)
+				s.tags.addTask(taskKey{destid, taskid}, moved.tags)
+				s.rekeyTask(taskKey{r.List, taskid}, taskKey{destid, taskid}, moved, now, r.Seq)
+				s.search.remove(r.List, fieldTasks, moved.name)
+				s.search.add(destid, fieldTasks, moved.name)
+			}
+			s.touch(destid, now, r.Seq)
+			break
+		}
+		tasks[r.TaskID] = task
+
+	case opAddComment, opUpdateComment, opDeleteComment:
+		s.applyComment(r)
+
+		// The comments are part of the list as GetList returns it, but not
+		// of its data; see version.go.
+		list := s.lists[r.List]
+		list.version = r.Seq
+		list.changed = now
+		s.lists[r.List] = list
+
+	case opUpdateList:
+		list := s.lists[r.List]
+		s.tags.removeList(r.List, list.tags)
+		s.search.remove(r.List, fieldName, list.name)
+		s.search.remove(r.List, fieldDescription, list.description)
+		list.name = r.Name
+		list.description = r.Description
+		list.tags = r.Tags
+		list.updated = now
+		list.version = r.Seq
+		list.changed = now
+		s.lists[r.List] = list
+		s.tags.addList(r.List, r.Tags)
+		s.search.add(r.List, fieldName, list.name)
+		s.search.add(r.List, fieldDescription, list.description)
+
+	case opDeleteList:
+		list := s.lists[r.List]
+		s.search.removeList(r.List, list)
+		for taskid, task := range list.tasks {
+			s.tags.removeTask(taskKey{r.List, taskid}, task.tags)
+			s.dropTask(taskKey{r.List, taskid}, task, now, r.Seq)
+			s.dues.remove(task)
+		}
+		s.tags.removeList(r.List, list.tags)
+		delete(s.lists, r.List)
+
+	case opDeleteTask:
+		tasks := s.lists[r.List].tasks
+		for _, taskid := range subtree(tasks, r.TaskID) {
+			s.tags.removeTask(taskKey{r.List, taskid}, tasks[taskid].tags)
+			s.dropTask(taskKey{r.List, taskid}, tasks[taskid], now, r.Seq)
+			s.search.remove(r.List, fieldTasks, tasks[taskid].name)
+			s.dues.remove(tasks[taskid])
+			delete(tasks, taskid)
+		}
+		s.touch(r.List, now, r.Seq)
+
+	case opBatch:
+		for _, sub := range r.Batch {
+			s.apply(sub)
+		}
+	}
+	s.seq = r.Seq
+	if now.After(s.changed) {
+		s.changed = now
+	}
+}
+
+// listRecord returns a record which recreates a list in its current state.
+// Snapshots are written as a series of these.
+func (s *MemoryStore) listRecord(listid uuid.UUID) record {
+	list := s.lists[listid]
+	r := record{Seq: s.seq, Op: opAddList, Time: timePointer(list.updated), Created: timePointer(list.created), Version: list.version, Changed: timePointer(list.changed), List: listid, Name: list.name, Description: list.description, Tags: list.tags}
+	for taskid, task := range list.tasks {
+		t := newTaskRecord(taskid, task)
+		t.Created = timePointer(task.created)
+		t.Updated = timePointer(task.updated)
+		t.Version = task.version
+		for _, c := range task.comments {
+			t.Comments = append(t.Comments, newCommentRecord(c))
+		}
+		r.Tasks = append(r.Tasks, t)
+	}
+	return r
+}
@@ -0,0 +1,422 @@
+package model
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A task may recur, following a rule in the RRULE syntax of RFC 5545.  We
+// support a subset: FREQ (DAILY, WEEKLY, MONTHLY or YEARLY), INTERVAL, BYDAY,
+// COUNT and UNTIL.  BYDAY may carry an ordinal, such as -1FR for the last
+// Friday, only with MONTHLY, and isn't supported with YEARLY at all.  Weeks
+// start on Monday.
+//
+// The series is anchored at the task's due time, which it must have.  Every
+// occurrence falls at the same wall-clock time in the task's time zone, or
+// at the due time's own offset if it has none, so a chore due at 09:00 stays
+// at 09:00 across daylight saving changes.  We remember the due time the rule
+// was anchored at, since an occurrence moved by a daylight saving gap no
+// longer shows the series' time of day; the anchor moves whenever the client
+// changes the due time, time zone or rule.  Completing an occurrence, through
+// SetCompleted or UpdateTask, adds the next one to the list, just after it;
+// the completed one stays behind as a record of the work done, but leaves the
+// series, so that completing it again doesn't add another.  The next
+// occurrence carries the rule on with its COUNT reduced by one, so that COUNT
+// always says how many occurrences are left, counting the current one.  Since
+// each occurrence is itself on the series, the next one can be worked out from
+// it and the anchor alone.
+//
+// As in RFC 5545, dates which don't exist are skipped rather than moved: a
+// monthly task due on the 31st comes round only in months with 31 days, and
+// a yearly one due on 29 February only in leap years.  A wall-clock time
+// which doesn't exist on some day, because the clocks went forward, is moved
+// on by the length of the gap; one which happens twice, because they went
+// back, is taken the first time.
+
+// maxRecurrenceSteps bounds the search for the next occurrence, so that a
+// rule which can never match again doesn't loop forever.
+const maxRecurrenceSteps = 1000
+
+var weekdayNames = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+var byDayPattern = regexp.MustCompile(`^([+-]?[0-9]{1,2})?(SU|MO|TU|WE|TH|FR|SA)$`)
+
+type weekdayNum struct {
+	ordinal int // Zero for every such day, or the nth; negative counts from the end
+	day     time.Weekday
+}
+
+func (w weekdayNum) String() string {
+	if w.ordinal == 0 {
+		return weekdayNames[w.day]
+	}
+	return strconv.Itoa(w.ordinal) + weekdayNames[w.day]
+}
+
+type rule struct {
+	freq     string
+	interval int
+	byDay    []weekdayNum
+	count    int    // Zero if unlimited
+	until    string // As given, or empty if none
+}
+
+// parseRule parses a recurrence rule.  An "RRULE:" prefix is allowed, and
+// case doesn't matter.
+func parseRule(text string) (rule, error) {
+	r := rule{interval: 1}
+	text = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(text)), "RRULE:")
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(text, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return rule{}, invalid("recurrence", "%q is not a NAME=VALUE pair", part)
+		}
+		if seen[name] {
+			return rule{}, invalid("recurrence", "%s is given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.freq = value
+			default:
+				return rule{}, invalid("recurrence", "FREQ must be DAILY, WEEKLY, MONTHLY or YEARLY")
+			}
+		case "INTERVAL":
+			r.interval, err = positiveRulePart(name, value)
+		case "COUNT":
+			r.count, err = positiveRulePart(name, value)
+		case "UNTIL":
+			if _, err = parseUntil(value, time.UTC); err != nil {
+				return rule{}, invalid("recurrence", "UNTIL must be a date or a date and time, such as 20261231 or 20261231T235959Z")
+			}
+			r.until = value
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				match := byDayPattern.FindStringSubmatch(day)
+				if match == nil {
+					return rule{}, invalid("recurrence", "%q is not a day such as MO or -1FR", day)
+				}
+				w := weekdayNum{}
+				for i, name := range weekdayNames {
+					if name == match[2] {
+						w.day = time.Weekday(i)
+					}
+				}
+				if match[1] != "" {
+					w.ordinal, _ = strconv.Atoi(match[1])
+					if w.ordinal == 0 || w.ordinal < -5 || w.ordinal > 5 {
+						return rule{}, invalid("recurrence", "%q must count from 1 to 5 days from either end of the month", day)
+					}
+				}
+				r.byDay = append(r.byDay, w)
+			}
+		default:
+			return rule{}, invalid("recurrence", "%s is not supported", name)
+		}
+		if err != nil {
+			return rule{}, err
+		}
+	}
+
+	// Check the parts make sense together.
+	if r.freq == "" {
+		return rule{}, invalid("recurrence", "FREQ is required")
+	}
+	if r.count > 0 && r.until != "" {
+		return rule{}, invalid("recurrence", "COUNT and UNTIL must not both be given")
+	}
+	for _, w := range r.byDay {
+		if r.freq == "YEARLY" {
+			return rule{}, invalid("recurrence", "BYDAY is not supported with YEARLY")
+		}
+		if w.ordinal != 0 && r.freq != "MONTHLY" {
+			return rule{}, invalid("recurrence", "BYDAY may only count days with MONTHLY")
+		}
+	}
+	return r, nil
+}
+
+func positiveRulePart(name string, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, invalid("recurrence", "%s must be a positive integer", name)
+	}
+	return n, nil
+}
+
+// parseUntil returns the last instant a series may reach.  A date covers the
+// whole day in the given location, and a time without a Z is taken to be in
+// it too.
+func parseUntil(until string, loc *time.Location) (time.Time, error) {
+	switch {
+	case len(until) == len("20060102"):
+		date, err := time.ParseInLocation("20060102", until, loc)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return date.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	case strings.HasSuffix(until, "Z"):
+		return time.Parse("20060102T150405Z", until)
+	default:
+		return time.ParseInLocation("20060102T150405", until, loc)
+	}
+}
+
+// String returns the rule in canonical form.
+func (r rule) String() string {
+	parts := []string{"FREQ=" + r.freq}
+	if r.interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.interval))
+	}
+	if len(r.byDay) > 0 {
+		days := make([]string, 0, len(r.byDay))
+		for _, w := range r.byDay {
+			days = append(days, w.String())
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.count))
+	}
+	if r.until != "" {
+		parts = append(parts, "UNTIL="+r.until)
+	}
+	return strings.Join(parts, ";")
+}
+
+// matches reports whether a date is one of the days the rule picks out.
+// Only for rules without ordinals.
+func (r rule) matches(date time.Time) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, w := range r.byDay {
+		if w.day == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDays returns the dates the rule picks out in the month starting on
+// the given date, in order.  Without BYDAY, that's the given day of the
+// month, if the month has it.
+func (r rule) monthDays(first time.Time, day int) []time.Time {
+	result := []time.Time{}
+	if len(r.byDay) == 0 {
+		date := first.AddDate(0, 0, day-1)
+		if date.Month() == first.Month() {
+			result = append(result, date)
+		}
+		return result
+	}
+
+	last := first.AddDate(0, 1, -1)
+	seen := make(map[int]bool)
+	for _, w := range r.byDay {
+		// Find the first and last such day in the month, then count from
+		// whichever end the ordinal says.
+		firstDay := first.AddDate(0, 0, (int(w.day)-int(first.Weekday())+7)%7)
+		lastDay := last.AddDate(0, 0, -((int(last.Weekday()) - int(w.day) + 7) % 7))
+		dates := []time.Time{}
+		switch {
+		case w.ordinal == 0:
+			for date := firstDay; !date.After(last); date = date.AddDate(0, 0, 7) {
+				dates = append(dates, date)
+			}
+		case w.ordinal > 0:
+			dates = append(dates, firstDay.AddDate(0, 0, 7*(w.ordinal-1)))
+		default:
+			dates = append(dates, lastDay.AddDate(0, 0, 7*(w.ordinal+1)))
+		}
+		for _, date := range dates {
+			if date.Month() == first.Month() && !seen[date.Day()] {
+				seen[date.Day()] = true
+				result = append(result, date)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Before(result[j])
+	})
+	return result
+}
+
+// next returns the occurrence after the given one, if the series goes on.
+// The anchor gives the series' time of day and location.
+func (r rule) next(due time.Time, anchor time.Time) (time.Time, bool) {
+	// Work on dates alone, in UTC where every day is 24 hours long, and put
+	// the time back on at the end.
+	y, m, d := due.Date()
+	date := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	next := time.Time{}
+	switch r.freq {
+	case "DAILY":
+		for step := 1; step <= maxRecurrenceSteps && next.IsZero(); step++ {
+			if candidate := date.AddDate(0, 0, step*r.interval); r.matches(candidate) {
+				next = candidate
+			}
+		}
+	case "WEEKLY":
+		if len(r.byDay) == 0 {
+			next = date.AddDate(0, 0, 7*r.interval)
+			break
+		}
+		monday := date.AddDate(0, 0, -((int(date.Weekday()) + 6) % 7))
+		for step := 0; step <= maxRecurrenceSteps && next.IsZero(); step++ {
+			week := monday.AddDate(0, 0, 7*step*r.interval)
+			for i := 0; i < 7; i++ {
+				if candidate := week.AddDate(0, 0, i); candidate.After(date) && r.matches(candidate) {
+					next = candidate
+					break
+				}
+			}
+		}
+	case "MONTHLY":
+		for step := 0; step <= maxRecurrenceSteps && next.IsZero(); step++ {
+			first := time.Date(y, m+time.Month(step*r.interval), 1, 0, 0, 0, 0, time.UTC)
+			for _, candidate := range r.monthDays(first, d) {
+				if candidate.After(date) {
+					next = candidate
+					break
+				}
+			}
+		}
+	case "YEARLY":
+		for step := 1; step <= maxRecurrenceSteps && next.IsZero(); step++ {
+			if candidate := time.Date(y+step*r.interval, m, d, 0, 0, 0, 0, time.UTC); candidate.Day() == d {
+				next = candidate
+			}
+		}
+	}
+	if next.IsZero() {
+		return time.Time{}, false
+	}
+
+	// Put the time of day back on, and check we haven't run past the end.
+	result := wallClock(next, anchor)
+	if r.until != "" {
+		end, _ := parseUntil(r.until, anchor.Location())
+		if result.After(end) {
+			return time.Time{}, false
+		}
+	}
+	return result, true
+}
+
+// wallClock returns the given date at the wall-clock time of another time, in
+// its location.  Times skipped when the clocks go forward are moved on by the
+// length of the gap, and times repeated when they go back are taken the first
+// time, as RFC 5545 asks; time.Date doesn't promise either.
+func wallClock(date time.Time, clock time.Time) time.Time {
+	loc := clock.Location()
+	y, m, d := date.Date()
+	hh, mm, ss := clock.Clock()
+	naive := time.Date(y, m, d, hh, mm, ss, clock.Nanosecond(), time.UTC)
+
+	// Try the offsets in force a day either side.  Assuming the clocks
+	// change at most once in that time, at least one fits unless the time
+	// was skipped, and both fit if it was repeated.
+	result := time.Time{}
+	for _, probe := range []time.Duration{-24 * time.Hour, 24 * time.Hour} {
+		_, offset := naive.Add(probe).In(loc).Zone()
+		candidate := naive.Add(-time.Duration(offset) * time.Second).In(loc)
+		cy, cm, cd := candidate.Date()
+		ch, cmin, cs := candidate.Clock()
+		fits := cy == y && cm == m && cd == d && ch == hh && cmin == mm && cs == ss
+		if fits && (result.IsZero() || candidate.Before(result)) {
+			result = candidate
+		}
+	}
+	if result.IsZero() {
+		// The time was skipped; read it with the offset from before.
+		_, offset := naive.Add(-24 * time.Hour).In(loc).Zone()
+		result = naive.Add(-time.Duration(offset) * time.Second).In(loc)
+	}
+	return result
+}
+
+// checkRecurrence checks a task's recurrence rule and puts it in canonical
+// form.  If the rule has lost its anchor, it is anchored at the due time.
+func (t *task) checkRecurrence() error {
+	if t.recurrence == "" {
+		t.anchor = time.Time{}
+		return nil
+	}
+	r, err := parseRule(t.recurrence)
+	if err != nil {
+		return err
+	}
+	if t.due.IsZero() {
+		return invalid("recurrence", "needs a due time to anchor it")
+	}
+	t.recurrence = r.String()
+	if t.anchor.IsZero() {
+		t.anchor = t.due
+	}
+	return nil
+}
+
+// advance returns the record of the next occurrence of a recurring task
+// being completed, with a new ID and placed just after it, if the series goes
+// on.
+func advance(tasks taskmap, t task) ([]taskRecord, error) {
+	next, ok := t.nextOccurrence()
+	if !ok {
+		return nil, nil
+	}
+	nextid, err := newID("")
+	if err != nil {
+		return nil, err
+	}
+	next.position, err = positionNear(tasks, uuid.Nil, t.position, false)
+	if err != nil {
+		return nil, err
+	}
+	return []taskRecord{newTaskRecord(nextid, next)}, nil
+}
+
+// nextOccurrence returns the occurrence of a recurring task which follows it,
+// if the series goes on.  It is open, and as yet has no position.
+func (t task) nextOccurrence() (task, bool) {
+	if t.recurrence == "" {
+		return task{}, false
+	}
+
+	// The rule was checked when the task was written.
+	r, err := parseRule(t.recurrence)
+	if err != nil || r.count == 1 {
+		return task{}, false
+	}
+	due, ok := r.next(t.due, t.anchor)
+	if !ok {
+		return task{}, false
+	}
+
+	next := t
+	next.completed = false
+	next.position = ""
+	next.comments = nil
+	next.due = due
+	if !t.start.IsZero() {
+		// Keep the start the same number of days ahead of the due time, at
+		// the same time of day.
+		days := int(due.Sub(t.due).Round(24*time.Hour) / (24 * time.Hour))
+		next.start = wallClock(t.start.AddDate(0, 0, days), t.start)
+	}
+	if r.count > 0 {
+		r.count--
+		next.recurrence = r.String()
+	}
+	return next, true
+}
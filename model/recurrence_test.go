@@ -0,0 +1,236 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	// Good rules, in canonical form.
+	for _, c := range []struct{ text, expected string }{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"rrule:freq=weekly;byday=mo,th;interval=2", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"},
+		{"FREQ=WEEKLY;INTERVAL=1", "FREQ=WEEKLY"},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=6", "FREQ=MONTHLY;BYDAY=-1FR;COUNT=6"},
+		{"FREQ=MONTHLY;BYDAY=+2TU,SA", "FREQ=MONTHLY;BYDAY=2TU,SA"},
+		{"FREQ=YEARLY;UNTIL=20301231", "FREQ=YEARLY;UNTIL=20301231"},
+		{"FREQ=DAILY;UNTIL=20261231T235959Z", "FREQ=DAILY;UNTIL=20261231T235959Z"},
+		{"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", "FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR"},
+	} {
+		r, err := parseRule(c.text)
+		assert.Nil(t, err, c.text)
+		assert.Equal(t, c.expected, r.String(), c.text)
+	}
+
+	// Bad rules.
+	for _, text := range []string{
+		"",
+		"DAILY",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20261231",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ=WEEKLY;BYDAY=MONDAY",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=6MO",
+		"FREQ=MONTHLY;BYDAY=0MO",
+		"FREQ=YEARLY;BYDAY=MO",
+		"FREQ=DAILY;BYMONTH=1",
+		"FREQ=DAILY;",
+	} {
+		_, err := parseRule(text)
+		var verr *ValidationError
+		assert.ErrorAs(t, err, &verr, text)
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	for _, c := range []struct {
+		rule     string
+		zone     string // Empty to keep the due time's offset
+		due      string
+		expected []string
+		ends     bool // Whether the series ends after the expected occurrences
+	}{
+		// Days keep their wall-clock time across daylight saving changes.
+		{"FREQ=DAILY", "Europe/Oslo", "2026-03-27T09:00:00+01:00", []string{
+			"2026-03-28T09:00:00+01:00",
+			"2026-03-29T09:00:00+02:00",
+			"2026-03-30T09:00:00+02:00",
+		}, false},
+		{"FREQ=DAILY", "America/New_York", "2026-10-31T09:00:00-04:00", []string{
+			"2026-11-01T09:00:00-05:00",
+			"2026-11-02T09:00:00-05:00",
+		}, false},
+
+		// A fixed offset doesn't change.
+		{"FREQ=DAILY", "", "2026-03-28T09:00:00+01:00", []string{
+			"2026-03-29T09:00:00+01:00",
+		}, false},
+
+		// A time skipped when the clocks go forward moves on by the gap, and
+		// one repeated when they go back is taken the first time.
+		{"FREQ=DAILY", "Europe/Oslo", "2026-03-28T02:30:00+01:00", []string{
+			"2026-03-29T03:30:00+02:00",
+			"2026-03-30T02:30:00+02:00",
+		}, false},
+		{"FREQ=DAILY", "America/New_York", "2026-03-07T02:30:00-05:00", []string{
+			"2026-03-08T03:30:00-04:00",
+			"2026-03-09T02:30:00-04:00",
+		}, false},
+		{"FREQ=DAILY", "Europe/Oslo", "2026-10-24T02:30:00+02:00", []string{
+			"2026-10-25T02:30:00+02:00",
+			"2026-10-26T02:30:00+01:00",
+		}, false},
+		{"FREQ=DAILY", "America/New_York", "2026-10-31T01:30:00-04:00", []string{
+			"2026-11-01T01:30:00-04:00",
+			"2026-11-02T01:30:00-05:00",
+		}, false},
+
+		// Weekdays only, over a weekend.
+		{"FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR", "", "2026-01-02T09:00:00Z", []string{
+			"2026-01-05T09:00:00Z",
+			"2026-01-06T09:00:00Z",
+		}, false},
+
+		// Every other week, twice a week, starting on the second day.
+		{"FREQ=WEEKLY", "Europe/Oslo", "2026-03-23T18:00:00+01:00", []string{
+			"2026-03-30T18:00:00+02:00",
+		}, false},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", "", "2026-01-01T09:00:00Z", []string{
+			"2026-01-12T09:00:00Z",
+			"2026-01-15T09:00:00Z",
+			"2026-01-26T09:00:00Z",
+		}, false},
+
+		// Months without the day are skipped, not clamped.
+		{"FREQ=MONTHLY", "", "2026-01-31T12:00:00Z", []string{
+			"2026-03-31T12:00:00Z",
+			"2026-05-31T12:00:00Z",
+			"2026-07-31T12:00:00Z",
+			"2026-08-31T12:00:00Z",
+			"2026-10-31T12:00:00Z",
+		}, false},
+		{"FREQ=MONTHLY;INTERVAL=2", "", "2026-01-30T12:00:00Z", []string{
+			"2026-03-30T12:00:00Z",
+			"2026-05-30T12:00:00Z",
+		}, false},
+		{"FREQ=MONTHLY", "Europe/Oslo", "2026-02-28T23:30:00+01:00", []string{
+			"2026-03-28T23:30:00+01:00",
+			"2026-04-28T23:30:00+02:00",
+		}, false},
+
+		// Counted weekdays, from either end of the month.
+		{"FREQ=MONTHLY;BYDAY=-1FR", "", "2026-01-30T16:00:00Z", []string{
+			"2026-02-27T16:00:00Z",
+			"2026-03-27T16:00:00Z",
+			"2026-04-24T16:00:00Z",
+		}, false},
+		{"FREQ=MONTHLY;BYDAY=1MO,3MO", "", "2026-02-02T08:00:00Z", []string{
+			"2026-02-16T08:00:00Z",
+			"2026-03-02T08:00:00Z",
+			"2026-03-16T08:00:00Z",
+		}, false},
+		{"FREQ=MONTHLY;BYDAY=5SA", "", "2026-01-31T10:00:00Z", []string{
+			"2026-05-30T10:00:00Z",
+			"2026-08-29T10:00:00Z",
+		}, false},
+
+		// Leap days come round only in leap years.
+		{"FREQ=YEARLY", "", "2028-02-29T00:00:00Z", []string{
+			"2032-02-29T00:00:00Z",
+			"2036-02-29T00:00:00Z",
+		}, false},
+		{"FREQ=YEARLY;INTERVAL=3", "", "2026-07-04T00:00:00Z", []string{
+			"2029-07-04T00:00:00Z",
+		}, false},
+
+		// UNTIL as a date covers the whole day, in the task's zone.
+		{"FREQ=DAILY;UNTIL=20260103", "Europe/Oslo", "2026-01-01T23:30:00+01:00", []string{
+			"2026-01-02T23:30:00+01:00",
+			"2026-01-03T23:30:00+01:00",
+		}, true},
+		{"FREQ=WEEKLY;UNTIL=20260115T090000Z", "", "2026-01-01T09:00:00Z", []string{
+			"2026-01-08T09:00:00Z",
+			"2026-01-15T09:00:00Z",
+		}, true},
+		{"FREQ=DAILY;UNTIL=20260102T100000", "America/New_York", "2026-01-01T10:00:00-05:00", []string{
+			"2026-01-02T10:00:00-05:00",
+		}, true},
+	} {
+		r, err := parseRule(c.rule)
+		require.Nil(t, err, c.rule)
+		due, err := time.Parse(time.RFC3339, c.due)
+		require.Nil(t, err, c.due)
+		if c.zone != "" {
+			loc, err := time.LoadLocation(c.zone)
+			require.Nil(t, err, c.zone)
+			due = due.In(loc)
+		}
+		anchor := due
+		for _, expected := range c.expected {
+			next, ok := r.next(due, anchor)
+			assert.True(t, ok, "%s from %s", c.rule, due)
+			assert.Equal(t, expected, next.Format(time.RFC3339), "%s from %s", c.rule, due)
+			due = next
+		}
+		if c.ends {
+			_, ok := r.next(due, anchor)
+			assert.False(t, ok, "%s from %s", c.rule, due)
+		}
+	}
+}
+
+func TestNextTaskOccurrence(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Oslo")
+	require.Nil(t, err)
+
+	// The start keeps its distance in days and its time of day, and the
+	// count goes down.
+	current := task{
+		name:       "take out the bins",
+		completed:  true,
+		position:   "a0",
+		start:      time.Date(2026, 3, 26, 20, 0, 0, 0, loc),
+		due:        time.Date(2026, 3, 27, 7, 0, 0, 0, loc),
+		timeZone:   "Europe/Oslo",
+		recurrence: "FREQ=WEEKLY;COUNT=2",
+		anchor:     time.Date(2026, 3, 27, 7, 0, 0, 0, loc),
+		tags:       []string{"chores"},
+	}
+	next, ok := current.nextOccurrence()
+	assert.True(t, ok)
+	assert.Equal(t, task{
+		name:       "take out the bins",
+		start:      time.Date(2026, 4, 2, 20, 0, 0, 0, loc),
+		due:        time.Date(2026, 4, 3, 7, 0, 0, 0, loc),
+		timeZone:   "Europe/Oslo",
+		recurrence: "FREQ=WEEKLY;COUNT=1",
+		anchor:     time.Date(2026, 3, 27, 7, 0, 0, 0, loc),
+		tags:       []string{"chores"},
+	}, next)
+
+	// The last one has no successor.
+	_, ok = next.nextOccurrence()
+	assert.False(t, ok)
+
+	// Nor does a task which doesn't recur.
+	current.recurrence = ""
+	_, ok = current.nextOccurrence()
+	assert.False(t, ok)
+
+	// A rule needs a due time.
+	current = task{name: "water the plants", recurrence: "freq=daily"}
+	var verr *ValidationError
+	assert.ErrorAs(t, current.checkRecurrence(), &verr)
+	current.due = time.Date(2026, 3, 27, 7, 0, 0, 0, loc)
+	assert.Nil(t, current.checkRecurrence())
+	assert.Equal(t, "FREQ=DAILY", current.recurrence)
+	assert.Equal(t, current.due, current.anchor)
+}
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// GetLists searches list names, descriptions and the names of the lists'
+// tasks.  The text is split into words: runs of letters and digits, compared
+// without regard to case.  A word of the search string matches the beginning
+// of a word in the list, so that a search can be typed as it goes; see
+// query.go for the rest of the query language.  Lists are ranked by how well
+// they match, with matches in the name counting for the most and whole words
+// for more than prefixes.
+//
+// The store keeps an inverted index from each word to the lists containing
+// it, maintained as records are applied, so that a search doesn't have to
+// look at every list.  The words are kept in a trie, which finds all of the
+// words with a given prefix together.
+
+// Fields of a list which are searched.  The weights are what a match in each
+// adds to a list's score.
+const (
+	fieldName = iota
+	fieldDescription
+	fieldTasks
+	numFields
+)
+
+var (
+	fieldNames   = [numFields]string{"name", "description", "tasks"}
+	fieldWeights = [numFields]int{4, 1, 2}
+)
+
+// tokenize splits text into words in canonical form.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// posting counts the occurrences of a word in each field of a list.
+type posting [numFields]int
+
+type searchNode struct {
+	children map[byte]*searchNode
+	postings map[uuid.UUID]*posting // Lists containing the word ending here
+}
+
+type searchIndex struct {
+	root searchNode
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{searchNode{children: make(map[byte]*searchNode)}}
+}
+
+// find returns the node for a word or prefix, or nil if no word begins with
+// it.
+func (index *searchIndex) find(prefix string) *searchNode {
+	node := &index.root
+	for i := 0; i < len(prefix) && node != nil; i++ {
+		node = node.children[prefix[i]]
+	}
+	return node
+}
+
+func (index *searchIndex) add(listid uuid.UUID, field int, text string) {
+	for _, word := range tokenize(text) {
+		node := &index.root
+		for i := 0; i < len(word); i++ {
+			child, ok := node.children[word[i]]
+			if !ok {
+				child = &searchNode{children: make(map[byte]*searchNode)}
+				node.children[word[i]] = child
+			}
+			node = child
+		}
+		if node.postings == nil {
+			node.postings = make(map[uuid.UUID]*posting)
+		}
+		p, ok := node.postings[listid]
+		if !ok {
+			p = &posting{}
+			node.postings[listid] = p
+		}
+		p[field]++
+	}
+}
+
+// remove undoes add for the same text, dropping nodes left empty.
+func (index *searchIndex) remove(listid uuid.UUID, field int, text string) {
+	for _, word := range tokenize(text) {
+		index.root.remove(listid, field, word)
+	}
+}
+
+// remove removes one occurrence of the rest of a word below a node, and
+// reports whether the node is left empty.
+func (node *searchNode) remove(listid uuid.UUID, field int, rest string) bool {
+	if rest == "" {
+		if p, ok := node.postings[listid]; ok {
+			p[field]--
+			if *p == (posting{}) {
+				delete(node.postings, listid)
+			}
+		}
+	} else if child, ok := node.children[rest[0]]; ok && child.remove(listid, field, rest[1:]) {
+		delete(node.children, rest[0])
+	}
+	return len(node.postings) == 0 && len(node.children) == 0
+}
+
+func (index *searchIndex) addList(listid uuid.UUID, l list) {
+	index.add(listid, fieldName, l.name)
+	index.add(listid, fieldDescription, l.description)
+	for _, t := range l.tasks {
+		index.add(listid, fieldTasks, t.name)
+	}
+}
+
+func (index *searchIndex) removeList(listid uuid.UUID, l list) {
+	index.remove(listid, fieldName, l.name)
+	index.remove(listid, fieldDescription, l.description)
+	for _, t := range l.tasks {
+		index.remove(listid, fieldTasks, t.name)
+	}
+}
+
+// searchHit is how well a list matches a search.
+type searchHit struct {
+	score  int
+	fields [numFields]bool
+}
+
+// matches returns the names of the fields which matched.
+func (hit *searchHit) matches() []string {
+	result := []string{}
+	for field, matched := range hit.fields {
+		if matched {
+			result = append(result, fieldNames[field])
+		}
+	}
+	return result
+}
+
+// lookup returns the lists with a word in the given fields, with how well
+// they match.  If prefix is set, words beginning with it match too, though a
+// whole word counts for more than one it's merely the beginning of.
+func (index *searchIndex) lookup(word string, fields [numFields]bool, prefix bool) map[uuid.UUID]*searchHit {
+	hits := map[uuid.UUID]*searchHit{}
+	if node := index.find(word); node != nil {
+		node.collect(hits, fields, 2, prefix)
+	}
+	return hits
+}
+
+// collect adds the lists with the word at a node, and if deep is set the
+// words below it, to a set of hits.
+func (node *searchNode) collect(hits map[uuid.UUID]*searchHit, fields [numFields]bool, factor int, deep bool) {
+	for listid, p := range node.postings {
+		for field, count := range p {
+			if count == 0 || !fields[field] {
+				continue
+			}
+			hit, ok := hits[listid]
+			if !ok {
+				hit = &searchHit{}
+				hits[listid] = hit
+			}
+			hit.score += factor * fieldWeights[field] * count
+			hit.fields[field] = true
+		}
+	}
+	if deep {
+		for _, child := range node.children {
+			child.collect(hits, fields, 1, true)
+		}
+	}
+}
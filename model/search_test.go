@@ -0,0 +1,143 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenize(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		expected []string
+	}{
+		{"", []string{}},
+		{"Mow the yard", []string{"mow", "the", "yard"}},
+		{"  re-paint   the fence, twice!", []string{"re", "paint", "the", "fence", "twice"}},
+		{"Ærlig talt: 2026 Ålesund", []string{"ærlig", "talt", "2026", "ålesund"}},
+	} {
+		assert.ElementsMatch(t, c.expected, tokenize(c.text), c.text)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	index := newSearchIndex()
+	home := uuid.MustParse("d290f1ee-6c54-4b01-90e6-d701748f0851")
+	work := uuid.MustParse("d290f1ee-6c54-4b01-90e6-d701748f0852")
+
+	// Index some words; the same word twice counts twice.
+	index.add(home, fieldName, "Home")
+	index.add(home, fieldTasks, "wash the car")
+	index.add(home, fieldTasks, "wash the dog")
+	index.add(work, fieldName, "Work")
+	index.add(work, fieldDescription, "wash up after the party")
+	all := [numFields]bool{true, true, true}
+	hits := index.lookup("wash", all, false)
+	require.Equal(t, 2, len(hits))
+	assert.Equal(t, 2*2*fieldWeights[fieldTasks], hits[home].score)
+	assert.Equal(t, 2*fieldWeights[fieldDescription], hits[work].score)
+	assert.Equal(t, []string{"tasks"}, hits[home].matches())
+
+	// Prefixes count for less, and only in the fields asked for.
+	hits = index.lookup("wa", all, true)
+	assert.Equal(t, 2*fieldWeights[fieldTasks], hits[home].score)
+	hits = index.lookup("wa", [numFields]bool{fieldDescription: true}, true)
+	assert.Equal(t, 1, len(hits))
+	assert.Equal(t, []string{"description"}, hits[work].matches())
+	hits = index.lookup("wa", all, false)
+	assert.Equal(t, 0, len(hits))
+
+	// Removing one occurrence leaves the other.
+	index.remove(home, fieldTasks, "wash the dog")
+	hits = index.lookup("do", all, true)
+	assert.Equal(t, 0, len(hits))
+	hits = index.lookup("wash", all, false)
+	assert.Equal(t, fieldWeights[fieldTasks]*2, hits[home].score)
+
+	// Removing everything leaves an empty trie.
+	index.remove(home, fieldName, "Home")
+	index.remove(home, fieldTasks, "wash the car")
+	index.remove(work, fieldName, "Work")
+	index.remove(work, fieldDescription, "wash up after the party")
+	assert.Equal(t, 0, len(index.root.children))
+	assert.Equal(t, 0, len(index.root.postings))
+}
+
+// Words for the benchmark lists' names.
+var benchmarkWords = []string{
+	"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel",
+	"india", "juliet", "kilo", "lima", "mike", "november", "oscar", "papa",
+	"quebec", "romeo", "sierra", "tango", "uniform", "victor", "whiskey",
+	"xray", "yankee", "zulu",
+}
+
+var (
+	benchmarkOnce  sync.Once
+	benchmarkStore *MemoryStore
+)
+
+// Populates a store with 100,000 lists of two tasks each, shared between the
+// benchmarks since it takes a while.
+func populateBenchmark(b *testing.B) *MemoryStore {
+	benchmarkOnce.Do(func() {
+		benchmarkStore = NewMemoryStore()
+		n := len(benchmarkWords)
+		for i := 0; i < 100000; i++ {
+			_, err := benchmarkStore.AddList(TodoList{
+				Name:        fmt.Sprintf("%s %s %d", benchmarkWords[i%n], benchmarkWords[i/n%n], i),
+				Description: fmt.Sprintf("Things to do in week %d", i%52),
+				Tasks: []Task{
+					{Name: "call " + benchmarkWords[i/3%n]},
+					{Name: "buy " + benchmarkWords[i/7%n]},
+				},
+			})
+			require.Nil(b, err)
+		}
+	})
+	return benchmarkStore
+}
+
+var benchmarkSearches = []string{"oscar", "osc", "oscar tango", "4242"}
+
+// BenchmarkSearchIndex searches through the index, a page at a time.
+func BenchmarkSearchIndex(b *testing.B) {
+	store := populateBenchmark(b)
+	for _, search := range benchmarkSearches {
+		b.Run(search, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := store.GetLists(search, 0, 20, ListOptions{})
+				require.Nil(b, err)
+			}
+		})
+	}
+}
+
+// BenchmarkSearchScan is the regular expression scan the index replaced,
+// over the same fields, for comparison.
+func BenchmarkSearchScan(b *testing.B) {
+	store := populateBenchmark(b)
+	for _, search := range benchmarkSearches {
+		b.Run(search, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(search))
+				store.lock.RLock()
+				matches := []uuid.UUID{}
+				for listid, list := range store.lists {
+					found := re.MatchString(list.name) || re.MatchString(list.description)
+					for _, task := range list.tasks {
+						found = found || re.MatchString(task.name)
+					}
+					if found {
+						matches = append(matches, listid)
+					}
+				}
+				store.lock.RUnlock()
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package model
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GetList sorts a list's tasks, and GetLists the lists, in an order given as
+// a series of keys separated by commas, such as "-completed,name".  Each key
+// sorts in its natural order, described with the constants below, and a "-"
+// in front of it reverses that order.  Later keys break ties in earlier ones,
+// and any ties left are broken by name and then by ID, so that the order is
+// stable, which pagination depends on.
+//
+// For sorting, the store keeps the times each list and task was created and
+// last updated.  A task is updated by any change to it, including completing
+// or moving it, and a list by any change to it or to its tasks, but not by
+// comments, which have times of their own.  The times are taken from the
+// records, so that replaying a journal restores them; records journaled
+// before the times were kept leave them zero, which sorts as the oldest.
+
+// Sort keys.  Not all of them apply to both tasks and lists.
+const (
+	SortName      = "name"      // By name
+	SortPosition  = "position"  // Tasks in the order arranged with MoveTask
+	SortPriority  = "priority"  // Most urgent tasks first, by priority and then due time
+	SortDue       = "due"       // Tasks due soonest first, and those with no due time last
+	SortCreated   = "created"   // Oldest first
+	SortUpdated   = "updated"   // Least recently updated first
+	SortCompleted = "completed" // Incomplete tasks first, or the lists with the smallest share of their tasks completed
+	SortTasks     = "tasks"     // Lists with the fewest tasks first
+	SortRelevance = "relevance" // Lists matching the search string best first
+)
+
+var (
+	taskSortKeys = []string{SortName, SortPosition, SortPriority, SortDue, SortCreated, SortUpdated, SortCompleted}
+	listSortKeys = []string{SortRelevance, SortName, SortCreated, SortUpdated, SortTasks, SortCompleted}
+
+	// The default orders: tasks by name, which seems the most reasonable
+	// choice when users haven't arranged them themselves, and lists by how
+	// well they match the search string, if any, and then by name.
+	defaultTaskSort = []sortKey{{key: SortName}}
+	defaultListSort = []sortKey{{key: SortRelevance}, {key: SortName}}
+)
+
+// sortKey is one key of a sort order.
+type sortKey struct {
+	key     string
+	reverse bool
+}
+
+// parseSort parses a sort order made of the given keys.  An empty order is
+// the default.
+func parseSort(field string, text string, keys []string, defaultOrder []sortKey) ([]sortKey, error) {
+	if text == "" {
+		return defaultOrder, nil
+	}
+	order := []sortKey{}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(text, ",") {
+		k := sortKey{key: strings.TrimPrefix(part, "-"), reverse: strings.HasPrefix(part, "-")}
+		known := false
+		for _, key := range keys {
+			known = known || k.key == key
+		}
+		switch {
+		case !known:
+			return nil, invalid(field, "unknown key %q; must be one of %s, optionally after \"-\"", part, strings.Join(keys, ", "))
+		case seen[k.key]:
+			return nil, invalid(field, "key %q is given twice", k.key)
+		}
+		seen[k.key] = true
+		order = append(order, k)
+	}
+	return order, nil
+}
+
+// Helpers returning a negative number if a comes first, a positive one if b
+// does and zero if they're tied.
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareBools(a bool, b bool) int {
+	switch {
+	case !a && b:
+		return -1
+	case a && !b:
+		return 1
+	}
+	return 0
+}
+
+// compareTasks compares two tasks in a sort order.
+func compareTasks(order []sortKey, aid uuid.UUID, a task, bid uuid.UUID, b task) int {
+	for _, k := range order {
+		c := 0
+		switch k.key {
+		case SortName:
+			c = strings.Compare(a.name, b.name)
+		case SortPosition:
+			c = strings.Compare(a.position, b.position)
+		case SortPriority:
+			if c = compareInts(b.priority, a.priority); c == 0 {
+				c = compareDue(a, b)
+			}
+		case SortDue:
+			c = compareDue(a, b)
+		case SortCreated:
+			c = compareTimes(a.created, b.created)
+		case SortUpdated:
+			c = compareTimes(a.updated, b.updated)
+		case SortCompleted:
+			c = compareBools(a.completed, b.completed)
+		}
+		if k.reverse {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	if c := strings.Compare(a.name, b.name); c != 0 {
+		return c
+	}
+	return bytes.Compare(aid[:], bid[:])
+}
+
+// needsCounts reports whether a sort order for lists needs their tasks
+// counted, which means going through all of them.
+func needsCounts(order []sortKey) bool {
+	for _, k := range order {
+		if k.key == SortTasks || k.key == SortCompleted {
+			return true
+		}
+	}
+	return false
+}
+
+// compare compares two search results in a sort order.
+func (r listResult) compare(order []sortKey, other listResult) int {
+	for _, k := range order {
+		c := 0
+		switch k.key {
+		case SortRelevance:
+			c = compareInts(other.score, r.score)
+		case SortName:
+			c = strings.Compare(r.name, other.name)
+		case SortCreated:
+			c = compareTimes(r.created, other.created)
+		case SortUpdated:
+			c = compareTimes(r.updated, other.updated)
+		case SortTasks:
+			c = compareInts(r.tasks, other.tasks)
+		case SortCompleted:
+			// Compare the shares of the tasks completed without dividing,
+			// counting a list with no tasks as having none completed.
+			c = compareInts(r.completed*max(other.tasks, 1), other.completed*max(r.tasks, 1))
+		}
+		if k.reverse {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	if c := strings.Compare(r.name, other.name); c != 0 {
+		return c
+	}
+	return bytes.Compare(r.id[:], other.id[:])
+}
+
+// taskHeap keeps tasks with the last of them in a sort order on top.
+type taskHeap struct {
+	results []taskResult
+	order   []sortKey
+}
+
+func (h *taskHeap) Len() int {
+	return len(h.results)
+}
+
+func (h *taskHeap) Less(i, j int) bool {
+	a, b := h.results[i], h.results[j]
+	return compareTasks(h.order, a.id, a.task, b.id, b.task) > 0
+}
+
+func (h *taskHeap) Swap(i, j int) {
+	h.results[i], h.results[j] = h.results[j], h.results[i]
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	h.results = append(h.results, x.(taskResult))
+}
+
+func (h *taskHeap) Pop() interface{} {
+	last := h.results[len(h.results)-1]
+	h.results = h.results[:len(h.results)-1]
+	return last
+}
+
+// firstTasks returns the first n tasks in a sort order, sorted.  Rather than
+// sorting all of them, it keeps the first n seen so far in a heap, replacing
+// the last of them as better ones come along, which takes O(len(results) log
+// n) time, and mostly just a comparison with the top of the heap.
+func firstTasks(results []taskResult, n int, order []sortKey) []taskResult {
+	h := &taskHeap{make([]taskResult, 0, min(n, len(results))), order}
+	for _, result := range results {
+		switch {
+		case h.Len() < n:
+			heap.Push(h, result)
+		case compareTasks(order, result.id, result.task, h.results[0].id, h.results[0].task) < 0:
+			h.results[0] = result
+			heap.Fix(h, 0)
+		}
+	}
+	sort.Slice(h.results, func(i, j int) bool {
+		a, b := h.results[i], h.results[j]
+		return compareTasks(order, a.id, a.task, b.id, b.task) < 0
+	})
+	return h.results
+}
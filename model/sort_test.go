@@ -0,0 +1,102 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSort(t *testing.T) {
+	for _, c := range []struct {
+		text  string
+		keys  []string
+		order []sortKey
+		err   string
+	}{
+		{"name", taskSortKeys, []sortKey{{SortName, false}}, ""},
+		{"-completed,name", taskSortKeys, []sortKey{{SortCompleted, true}, {SortName, false}}, ""},
+		{"-updated,-tasks,relevance", listSortKeys, []sortKey{{SortUpdated, true}, {SortTasks, true}, {SortRelevance, false}}, ""},
+		{"tasks", taskSortKeys, nil, `sort: unknown key "tasks"; must be one of name, position, priority, due, created, updated, completed, optionally after "-"`},
+		{"position", listSortKeys, nil, `sort: unknown key "position"; must be one of relevance, name, created, updated, tasks, completed, optionally after "-"`},
+		{"name,", taskSortKeys, nil, `sort: unknown key ""; must be one of name, position, priority, due, created, updated, completed, optionally after "-"`},
+		{"--name", taskSortKeys, nil, `sort: unknown key "--name"; must be one of name, position, priority, due, created, updated, completed, optionally after "-"`},
+		{"name,-name", taskSortKeys, nil, `sort: key "name" is given twice`},
+	} {
+		order, err := parseSort("sort", c.text, c.keys, nil)
+		if c.err != "" {
+			var verr *ValidationError
+			if assert.ErrorAs(t, err, &verr, c.text) {
+				assert.Equal(t, c.err, verr.Fields[0].Field+": "+verr.Fields[0].Message, c.text)
+			}
+			continue
+		}
+		assert.Nil(t, err, c.text)
+		assert.Equal(t, c.order, order, c.text)
+	}
+
+	// An empty order is the default.
+	order, err := parseSort("sort", "", listSortKeys, defaultListSort)
+	assert.Nil(t, err)
+	assert.Equal(t, defaultListSort, order)
+}
+
+func TestFirstTasks(t *testing.T) {
+	// Some tasks with plenty of ties.
+	rng := rand.New(rand.NewSource(1))
+	results := []taskResult{}
+	for i := 0; i < 200; i++ {
+		results = append(results, taskResult{uuid.New(), task{name: fmt.Sprint(rng.Intn(20)), completed: rng.Intn(2) == 0}})
+	}
+	order := []sortKey{{SortCompleted, true}, {SortName, false}}
+	sorted := append([]taskResult(nil), results...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareTasks(order, sorted[i].id, sorted[i].task, sorted[j].id, sorted[j].task) < 0
+	})
+
+	// The first tasks are those of a full sort.
+	for _, n := range []int{1, 2, 10, 199, 200, 1000} {
+		first := firstTasks(results, n, order)
+		if n > len(results) {
+			n = len(results)
+		}
+		assert.Equal(t, sorted[:n], first, n)
+	}
+}
+
+// BenchmarkListPage gets the first page of a long list.
+func BenchmarkListPage(b *testing.B) {
+	store := populateLongList(b)
+	for i := 0; i < b.N; i++ {
+		_, _, err := store.GetListPage(benchmarkListID, nil, 20, ListOptions{})
+		require.Nil(b, err)
+	}
+}
+
+// BenchmarkListAll gets the whole of a long list, for comparison.
+func BenchmarkListAll(b *testing.B) {
+	store := populateLongList(b)
+	for i := 0; i < b.N; i++ {
+		_, err := store.GetList(benchmarkListID, ListOptions{})
+		require.Nil(b, err)
+	}
+}
+
+const benchmarkListID = "d290f1ee-6c54-4b01-90e6-d701748f0851"
+
+// Populates a store with a list of 10,000 tasks.
+func populateLongList(b *testing.B) *MemoryStore {
+	store := NewMemoryStore()
+	newlist := TodoList{ID: benchmarkListID, Name: "Long"}
+	for i := 0; i < 10000; i++ {
+		newlist.Tasks = append(newlist.Tasks, Task{Name: fmt.Sprintf("task %d", (i*7919)%10000)})
+	}
+	_, err := store.AddList(newlist)
+	require.Nil(b, err)
+	b.ResetTimer()
+	return store
+}
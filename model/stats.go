@@ -0,0 +1,50 @@
+package model
+
+import (
+	"time"
+)
+
+// Lists may come with a summary of their progress, so that a client can show
+// it without fetching every task.  The stats are computed from all of the
+// list's tasks, whatever the filter, when the list is read; they're cheap
+// next to producing the tasks' models, so there's nothing to keep up to
+// date.
+//
+// The percentage done is rounded down, so that a list is only 100% done when
+// all of its tasks are, and a list with no tasks is 0% done.  A task is
+// overdue if it isn't completed and its due time has passed.  The last
+// activity is the last time the list or any of its tasks changed, or a
+// comment was written or edited.
+
+// listStats returns the stats for a list.  The caller must hold the lock.
+func (s *MemoryStore) listStats(l list) *ListStats {
+	stats := &ListStats{Total: len(l.tasks)}
+	now := s.clock()
+	last := l.updated
+	for _, t := range l.tasks {
+		switch {
+		case t.completed:
+			stats.Completed++
+		case t.overdue(now):
+			stats.Overdue++
+		}
+		for _, c := range t.comments {
+			if c.created.After(last) {
+				last = c.created
+			}
+			if c.updated.After(last) {
+				last = c.updated
+			}
+		}
+	}
+	if stats.Total > 0 {
+		stats.PercentDone = 100 * stats.Completed / stats.Total
+	}
+	stats.LastActivity = timePointer(last)
+	return stats
+}
+
+// overdue reports whether a task is overdue at a given time.
+func (t task) overdue(now time.Time) bool {
+	return !t.completed && !t.due.IsZero() && t.due.Before(now)
+}
@@ -0,0 +1,104 @@
+package model
+
+// Store is the interface to the ToDo database.  The swagger handlers only
+// talk to a Store, so that several isolated instances can live in the same
+// process and so that the in-memory implementation can be swapped for a
+// durable one without touching the API layer.  All methods must be safe for
+// concurrent use.  Failures are reported with the errors in errors.go.
+type Store interface {
+	// AddList takes a model for a list and adds it to the store.  IDs left
+	// empty are generated.  It returns the list as added.
+	AddList(model TodoList) (TodoList, error)
+
+	// AddTask takes a model for a task and adds it to the list with the
+	// given ID.  If the task's ID is empty it is generated.  It returns the
+	// task as added.
+	AddTask(id string, model Task) (Task, error)
+
+	// SetCompleted sets the completed state of a task.
+	SetCompleted(id string, taskID string, model CompletedTask) error
+
+	// UpdateList applies a patch to a list's metadata and returns the
+	// updated list.
+	UpdateList(id string, patch TodoListPatch) (TodoList, error)
+
+	// UpdateTask applies a patch to a task and returns the updated task.
+	UpdateTask(id string, taskID string, patch TaskPatch) (Task, error)
+
+	// MoveTask moves a task immediately before or after another task,
+	// possibly into another list, and returns the moved task.
+	MoveTask(id string, taskID string, model TaskMove) (Task, error)
+
+	// DeleteList removes a list and all of its tasks.
+	DeleteList(id string) error
+
+	// DeleteTask removes a task from a list.
+	DeleteTask(id string, taskID string) error
+
+	// GetList returns a model for a list.
+	GetList(id string, options ListOptions) (TodoList, error)
+
+	// GetLists returns a model for a range of lists, potentially limited by
+	// a search term and/or using pagination.  A limit of zero is treated as
+	// no limit.  The options apply to each list's tasks; if they filter the
+	// tasks, only lists with matching tasks are included.
+	GetLists(searchString string, skip int, limit int, options ListOptions) ([]TodoList, error)
+
+	// GetListsAfter is like GetLists, but pages with cursors: it returns the
+	// lists after a cursor, or from the start if it is nil, and the cursor
+	// for the next page, or nil if there are no more lists.
+	GetListsAfter(searchString string, after *ListCursor, limit int, options ListOptions) ([]TodoList, *ListCursor, error)
+
+	// GetListPage is like GetList, but returns a page of the list's tasks:
+	// up to limit of those after a cursor, or from the start if it is nil,
+	// with the cursor for the next page, or nil if there are no more tasks.
+	// A limit of zero is treated as no limit.  The list's task counts are
+	// included.
+	GetListPage(id string, after *TaskCursor, limit int, options ListOptions) (TodoList, *TaskCursor, error)
+
+	// GetTasks returns the tasks selected by a filter, across all lists.
+	GetTasks(filter TaskFilter) ([]ListTask, error)
+
+	// GetTags returns every tag in use, with how many lists and tasks carry
+	// it.
+	GetTags() ([]TagCount, error)
+
+	// AddComment adds a comment to a task and returns it as added.  If the
+	// comment's ID is empty it is generated.
+	AddComment(id string, taskID string, model Comment) (Comment, error)
+
+	// GetComments returns a task's comments, oldest first.
+	GetComments(id string, taskID string) ([]Comment, error)
+
+	// UpdateComment applies a patch to a comment and returns the updated
+	// comment.
+	UpdateComment(id string, taskID string, commentID string, patch CommentPatch) (Comment, error)
+
+	// DeleteComment removes a comment from a task.
+	DeleteComment(id string, taskID string, commentID string) error
+
+	// GetListVersion returns what identifies the state of a list as GetList
+	// returns it, without producing the list; see version.go.
+	GetListVersion(id string) (VersionInfo, error)
+
+	// GetListsVersion is like GetListVersion, but covers all of the lists,
+	// as GetLists and GetListsAfter return them.
+	GetListsVersion() (VersionInfo, error)
+
+	// IfVersion returns a view of the store whose changes to lists and tasks
+	// fail with ErrVersionMismatch unless what they change is at one of the
+	// given versions; see version.go.
+	IfVersion(versions ...uint64) Store
+
+	// Batch makes the changes described by a series of operations in order,
+	// each seeing the ones before it, and returns what each returned.  The
+	// changes are made all or nothing: if one fails, none are made, and the
+	// error is a *BatchError naming it.  See batch.go.
+	Batch(ops []Operation) ([]OperationResult, error)
+}
+
+// Make sure the stores satisfy the interface.
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
@@ -0,0 +1,95 @@
+package model
+
+import (
+	"github.com/google/uuid"
+)
+
+// Tasks may have a parent task in the same list, so a list holds a forest of
+// tasks.  The parent links are the only record of the hierarchy; we work out
+// children and subtrees from them as needed, which is a scan of the list.
+// Lists are small enough that this is cheaper than keeping another index
+// consistent.
+//
+// A subtree goes wherever its root does: deleting a task deletes its
+// descendants, and moving it to another list takes them along.  Completing a
+// task may optionally do the same to its descendants.
+
+// children maps each task to its children.
+func children(tasks taskmap) map[uuid.UUID][]uuid.UUID {
+	result := make(map[uuid.UUID][]uuid.UUID)
+	for taskid, task := range tasks {
+		if task.parent != uuid.Nil {
+			result[task.parent] = append(result[task.parent], taskid)
+		}
+	}
+	return result
+}
+
+// subtree returns the IDs of a task and all of its descendants, the task
+// first.
+func subtree(tasks taskmap, root uuid.UUID) []uuid.UUID {
+	kids := children(tasks)
+	result := []uuid.UUID{root}
+	for i := 0; i < len(result); i++ {
+		result = append(result, kids[result[i]]...)
+	}
+	return result
+}
+
+// checkParent checks that a task may have the given parent: that the parent
+// is in the list and isn't the task itself or one of its descendants.  The
+// task needn't be in the list yet.
+func checkParent(tasks taskmap, taskid uuid.UUID, parentid uuid.UUID) error {
+	if parentid == uuid.Nil {
+		return nil
+	}
+
+	// Walk up from the parent.  If we meet the task, it would be its own
+	// ancestor.  A bound on the steps stops us going round an existing
+	// cycle, which can only happen while checking a new list.
+	for ancestor, steps := parentid, 0; ancestor != uuid.Nil; steps++ {
+		if ancestor == taskid || steps > len(tasks) {
+			return invalid("parent", "would make the task its own ancestor")
+		}
+		t, ok := tasks[ancestor]
+		if !ok {
+			return invalid("parent", "no such task in this list")
+		}
+		ancestor = t.parent
+	}
+	return nil
+}
+
+// parseParent parses a parent ID from the output model, where the empty
+// string means none.
+func parseParent(parent string) (uuid.UUID, error) {
+	if parent == "" {
+		return uuid.Nil, nil
+	}
+	parentid, err := uuid.Parse(parent)
+	if err != nil {
+		return uuid.Nil, invalid("parent", "not a valid ID")
+	}
+	return parentid, nil
+}
+
+func parentString(parentid uuid.UUID) string {
+	if parentid == uuid.Nil {
+		return ""
+	}
+	return parentid.String()
+}
+
+func parentPointer(parentid uuid.UUID) *uuid.UUID {
+	if parentid == uuid.Nil {
+		return nil
+	}
+	return &parentid
+}
+
+func parentValue(parentid *uuid.UUID) uuid.UUID {
+	if parentid == nil {
+		return uuid.Nil
+	}
+	return *parentid
+}
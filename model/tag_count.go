@@ -0,0 +1,17 @@
+/*
+ * Simple ToDo API
+ *
+ * This is a simple API for managing a TODO List
+ *
+ * API version: 1.0.0
+ * Contact: recruiting@dfsco.com
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package model
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Lists int    `json:"lists"`
+	Tasks int    `json:"tasks"`
+}
@@ -0,0 +1,178 @@
+package model
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Lists and tasks may carry free-form tags.  Tags are compared without regard
+// to case, so we store them trimmed and lowercased, sorted and without
+// duplicates.  A task is treated as carrying its list's tags as well as its
+// own when filtering, so that tagging a list tags everything in it.
+//
+// The store keeps an index from each tag to the lists and tasks carrying it,
+// maintained as records are applied.  It answers GetTags directly, and narrows
+// down the lists to look at when filtering by tag.
+
+const maxTagLength = 64
+
+// normalizeTags checks a set of tags and returns them in canonical form, or
+// nil if there are none.  Commas aren't allowed, since tag filters are given
+// as comma-separated lists.
+func normalizeTags(field string, tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		switch {
+		case tag == "":
+			return nil, invalid(field, "must not contain empty tags")
+		case len(tag) > maxTagLength:
+			return nil, invalid(field, "must not contain tags longer than %d bytes", maxTagLength)
+		case strings.Contains(tag, ","):
+			return nil, invalid(field, "must not contain tags with commas")
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// hasTags reports whether every one of the wanted tags is among the given
+// sets.  All must be in canonical form.
+func hasTags(wanted []string, own []string, inherited []string) bool {
+	for _, tag := range wanted {
+		if !containsTag(own, tag) && !containsTag(inherited, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsTag(tags []string, tag string) bool {
+	i := sort.SearchStrings(tags, tag)
+	return i < len(tags) && tags[i] == tag
+}
+
+// copyTags returns a copy of a set of tags for the output model, so that the
+// caller can't modify the store's copy.
+func copyTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return append([]string(nil), tags...)
+}
+
+type taskKey struct {
+	list uuid.UUID
+	task uuid.UUID
+}
+
+type tagEntry struct {
+	lists map[uuid.UUID]bool
+	tasks map[taskKey]bool
+}
+
+type tagIndex map[string]*tagEntry
+
+func (index tagIndex) entry(tag string) *tagEntry {
+	e, ok := index[tag]
+	if !ok {
+		e = &tagEntry{make(map[uuid.UUID]bool), make(map[taskKey]bool)}
+		index[tag] = e
+	}
+	return e
+}
+
+// tidy drops a tag once nothing carries it.
+func (index tagIndex) tidy(tag string) {
+	if e := index[tag]; len(e.lists) == 0 && len(e.tasks) == 0 {
+		delete(index, tag)
+	}
+}
+
+func (index tagIndex) addList(listid uuid.UUID, tags []string) {
+	for _, tag := range tags {
+		index.entry(tag).lists[listid] = true
+	}
+}
+
+func (index tagIndex) removeList(listid uuid.UUID, tags []string) {
+	for _, tag := range tags {
+		delete(index.entry(tag).lists, listid)
+		index.tidy(tag)
+	}
+}
+
+func (index tagIndex) addTask(key taskKey, tags []string) {
+	for _, tag := range tags {
+		index.entry(tag).tasks[key] = true
+	}
+}
+
+func (index tagIndex) removeTask(key taskKey, tags []string) {
+	for _, tag := range tags {
+		delete(index.entry(tag).tasks, key)
+		index.tidy(tag)
+	}
+}
+
+// candidates returns the IDs of the lists which might hold something matching
+// all the given tags: those carrying the rarest of them, or holding a task
+// which does.  A nil result means any list might.
+func (index tagIndex) candidates(tags []string) map[uuid.UUID]bool {
+	if len(tags) == 0 {
+		return nil
+	}
+	var rarest *tagEntry
+	for _, tag := range tags {
+		e, ok := index[tag]
+		if !ok {
+			return map[uuid.UUID]bool{}
+		}
+		if rarest == nil || len(e.lists)+len(e.tasks) < len(rarest.lists)+len(rarest.tasks) {
+			rarest = e
+		}
+	}
+	result := make(map[uuid.UUID]bool, len(rarest.lists)+len(rarest.tasks))
+	for listid := range rarest.lists {
+		result[listid] = true
+	}
+	for key := range rarest.tasks {
+		result[key.list] = true
+	}
+	return result
+}
+
+// GetTags returns every tag in use, with how many lists and tasks carry it.
+// The most used come first.
+func (s *MemoryStore) GetTags() ([]TagCount, error) {
+	response := []TagCount{}
+
+	// Lock the database.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	// Read the counts straight off the index.
+	for tag, e := range s.tags {
+		response = append(response, TagCount{tag, len(e.lists), len(e.tasks)})
+	}
+
+	// Sort them by total use, then by name.
+	sort.Slice(response, func(i, j int) bool {
+		a, b := response[i], response[j]
+		if a.Lists+a.Tasks != b.Lists+b.Tasks {
+			return a.Lists+a.Tasks > b.Lists+b.Tasks
+		}
+		return a.Tag < b.Tag
+	})
+	return response, nil
+}
@@ -10,8 +10,24 @@
 
 package model
 
+import (
+	"time"
+)
+
 type Task struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Completed bool   `json:"completed,omitempty"`
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Completed  bool       `json:"completed,omitempty"`
+	Start      *time.Time `json:"start,omitempty"`
+	Due        *time.Time `json:"due,omitempty"`
+	TimeZone   string     `json:"timeZone,omitempty"`
+	Priority   string     `json:"priority,omitempty"`
+	Recurrence string     `json:"recurrence,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	Parent     string     `json:"parent,omitempty"`
+	BlockedBy  []TaskRef  `json:"blockedBy,omitempty"`
+	Blocked    bool       `json:"blocked,omitempty"`
+	Subtasks   []Task     `json:"subtasks,omitempty"`
+	Comments   []Comment  `json:"comments,omitempty"`
+	Version    uint64     `json:"version,omitempty"`
 }
@@ -0,0 +1,16 @@
+/*
+ * Simple ToDo API
+ *
+ * This is a simple API for managing a TODO List
+ *
+ * API version: 1.0.0
+ * Contact: recruiting@dfsco.com
+ * Generated by: Swagger Codegen (https://github.com/swagger-api/swagger-codegen.git)
+ */
+
+package model
+
+type TaskCounts struct {
+	Total    int `json:"total"`
+	Filtered int `json:"filtered"`
+}
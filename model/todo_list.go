@@ -11,8 +11,13 @@
 package model
 
 type TodoList struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
-	Tasks       []Task `json:"tasks,omitempty"`
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Tasks       []Task      `json:"tasks,omitempty"`
+	Tags        []string    `json:"tags,omitempty"`
+	Matches     []string    `json:"matches,omitempty"`
+	Counts      *TaskCounts `json:"counts,omitempty"`
+	Stats       *ListStats  `json:"stats,omitempty"`
+	Version     uint64      `json:"version,omitempty"`
 }
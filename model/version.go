@@ -0,0 +1,188 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every list and task carries a version, so that clients can make a change
+// conditional on nothing having changed since they read it, rather than
+// silently overwriting each other.  The version is the sequence number of the
+// last record to change the list or task.  It only ever increases, even for a
+// list deleted and then added again with the same ID, and replaying a journal
+// restores it.
+//
+// A task's version changes with any change to the task itself, including its
+// blockers being deleted or moved, which changes its references to them.  A
+// list's changes with any change to the list or its tasks, and also to their
+// comments and to their blockers in other lists being completed or reopened,
+// so that it changes whenever the list GetList returns could.  Comments don't
+// change their task's version, so that discussing a task doesn't get in the
+// way of working on it.
+//
+// Versions also let clients which poll for lists tell cheaply whether they
+// have changed.  Besides the version, that depends on the time, as tasks fall
+// overdue; VersionInfo covers both.  The store as a whole has a version too,
+// the sequence number of the last record, which changes with anything
+// GetLists could return.
+
+// VersionInfo identifies the state of a list, or of all of them, as the
+// store returns it.
+type VersionInfo struct {
+	Version  uint64    // The list's, or the store's for all of them
+	Overdue  int       // How many tasks are overdue
+	Modified time.Time // When the version was set or a task last fell overdue, whichever is later; zero if unknown
+}
+
+// GetListVersion returns the version info for a list, without producing the
+// list.
+func (s *MemoryStore) GetListVersion(id string) (VersionInfo, error) {
+	// Parse the list ID.
+	listid, err := uuid.Parse(id)
+	if err != nil {
+		return VersionInfo{}, invalidID(id)
+	}
+
+	// Lock the database.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	list, ok := s.lists[listid]
+	if !ok {
+		return VersionInfo{}, listNotFound(listid)
+	}
+	info := VersionInfo{Version: list.version, Modified: list.changed}
+	info.addOverdue(list, s.clock())
+	return info, nil
+}
+
+// GetListsVersion returns the version info for all of the lists.  It keeps
+// count of the overdue tasks rather than looking at them all.
+func (s *MemoryStore) GetListsVersion() (VersionInfo, error) {
+	// Lock the database.
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	info := VersionInfo{Version: s.seq, Modified: s.changed}
+	now := s.clock()
+	overdue, latest, ok := s.dues.count(now)
+	if !ok {
+		// The clock has gone back, so count them the slow way; see due.go.
+		for _, list := range s.lists {
+			info.addOverdue(list, now)
+		}
+		return info, nil
+	}
+	info.Overdue = overdue
+	if !info.Modified.IsZero() && latest.After(info.Modified) {
+		info.Modified = latest
+	}
+	return info, nil
+}
+
+// addOverdue counts a list's overdue tasks, and moves the modified time up to
+// when the last of them fell due.
+func (info *VersionInfo) addOverdue(l list, now time.Time) {
+	for _, t := range l.tasks {
+		if t.overdue(now) {
+			info.Overdue++
+			if !info.Modified.IsZero() && t.due.After(info.Modified) {
+				info.Modified = t.due
+			}
+		}
+	}
+}
+
+// IfVersion returns a view of the store whose changes to lists and tasks only
+// go ahead if what they change is at one of the given versions, and otherwise
+// fail with ErrVersionMismatch.  AddTask, UpdateList and DeleteList check the
+// list's version; SetCompleted, UpdateTask, MoveTask, DeleteTask and the
+// changes to comments the task's.  Everything else is as in the store itself.
+func (s *MemoryStore) IfVersion(versions ...uint64) Store {
+	return &conditionalStore{s, &txn{cond: &condition{versions}}}
+}
+
+// condition is a set of versions a list or task must be at.  A nil condition
+// lets any version through.
+type condition struct {
+	versions []uint64
+}
+
+// checkList fails unless the condition lets a list's version through.
+func (c *condition) checkList(listid uuid.UUID, l list) error {
+	if !c.match(l.version) {
+		return listMismatch(listid, l.version)
+	}
+	return nil
+}
+
+// checkTask fails unless the condition lets a task's version through.
+func (c *condition) checkTask(taskid uuid.UUID, t task) error {
+	if !c.match(t.version) {
+		return taskMismatch(taskid, t.version)
+	}
+	return nil
+}
+
+func (c *condition) match(version uint64) bool {
+	if c == nil {
+		return true
+	}
+	for _, v := range c.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionalStore is the view returned by IfVersion.
+type conditionalStore struct {
+	*MemoryStore
+	tx *txn
+}
+
+func (s *conditionalStore) IfVersion(versions ...uint64) Store {
+	return s.MemoryStore.IfVersion(versions...)
+}
+
+func (s *conditionalStore) AddTask(id string, model Task) (Task, error) {
+	return s.addTask(id, model, s.tx)
+}
+
+func (s *conditionalStore) SetCompleted(id string, taskID string, model CompletedTask) error {
+	return s.setCompleted(id, taskID, model, s.tx)
+}
+
+func (s *conditionalStore) UpdateList(id string, patch TodoListPatch) (TodoList, error) {
+	return s.updateList(id, patch, s.tx)
+}
+
+func (s *conditionalStore) UpdateTask(id string, taskID string, patch TaskPatch) (Task, error) {
+	return s.updateTask(id, taskID, patch, s.tx)
+}
+
+func (s *conditionalStore) MoveTask(id string, taskID string, model TaskMove) (Task, error) {
+	return s.moveTask(id, taskID, model, s.tx)
+}
+
+func (s *conditionalStore) DeleteList(id string) error {
+	return s.deleteList(id, s.tx)
+}
+
+func (s *conditionalStore) DeleteTask(id string, taskID string) error {
+	return s.deleteTask(id, taskID, s.tx)
+}
+
+func (s *conditionalStore) AddComment(id string, taskID string, model Comment) (Comment, error) {
+	return s.addComment(id, taskID, model, s.tx)
+}
+
+func (s *conditionalStore) UpdateComment(id string, taskID string, commentID string, patch CommentPatch) (Comment, error) {
+	return s.updateComment(id, taskID, commentID, patch, s.tx)
+}
+
+func (s *conditionalStore) DeleteComment(id string, taskID string, commentID string) error {
+	return s.deleteComment(id, taskID, commentID, s.tx)
+}